@@ -12,6 +12,6 @@ func main() {
 
 	if err != nil {
 		log.Printf("Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
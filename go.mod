@@ -4,6 +4,7 @@ go 1.24.5
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
+	github.com/go-viper/mapstructure/v2 v2.2.1
 	github.com/grokify/html-strip-tags-go v0.1.0
 	github.com/pixellini/go-coqui v0.1.0
 	github.com/spf13/viper v1.20.1
@@ -27,7 +28,6 @@ require (
 	github.com/charmbracelet/x/term v0.2.1 // indirect
 	github.com/erikgeiser/coninput v0.0.0-20211004153227-1c3628e74d0f // indirect
 	github.com/fsnotify/fsnotify v1.8.0 // indirect
-	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-localereader v0.0.1 // indirect
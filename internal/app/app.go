@@ -2,33 +2,36 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/autotune"
 	"github.com/pixellini/go-audiobook/internal/config"
 	"github.com/pixellini/go-audiobook/internal/epub"
 	"github.com/pixellini/go-audiobook/internal/epubreader"
 	"github.com/pixellini/go-audiobook/internal/filemanager"
 	"github.com/pixellini/go-audiobook/internal/flags"
 	"github.com/pixellini/go-audiobook/internal/fsutils"
+	"github.com/pixellini/go-audiobook/internal/job"
 	"github.com/pixellini/go-audiobook/internal/logger"
 	"github.com/pixellini/go-audiobook/internal/metadata"
-	"github.com/pixellini/go-audiobook/internal/textutils"
+	"github.com/pixellini/go-audiobook/internal/report"
+	"github.com/pixellini/go-audiobook/internal/synthcache"
 	"github.com/pixellini/go-audiobook/internal/ttsservice"
 	"github.com/pixellini/go-audiobook/internal/tui"
-	"golang.org/x/sync/errgroup"
+	"github.com/pixellini/go-audiobook/internal/wav"
 )
 
 type Application struct {
 	config      *config.Config
 	fileManager filemanager.FileService
-	tts         ttsservice.TTSservice
 	audio       audioservice.AudioService
 	flag        *flags.Flags
 	tui         tui.TUIService
@@ -36,8 +39,31 @@ type Application struct {
 
 	chapters []*epubreader.EpubReaderChapter
 	cacheDir string
+
+	// jobsDir holds a job directory per book, which tracks progress so runs can be resumed.
+	jobsDir string
+	job     *job.Job
+
+	report *report.Report
+	// tuner adjusts the worker count while chapters are processed. It's nil unless concurrency is "auto".
+	tuner *autotune.Tuner
+
+	// audioCache holds synthesized paragraphs across runs and books.
+	audioCache *synthcache.Cache
+	// engines are the running TTS services by voice, started by startTTS and as chapters need them.
+	engines map[string]*engine
+	// sections maps chapter paths to the table of contents entries they sit under, for chapter overrides.
+	sections map[string][]string
+
+	// secondary is the engine for the "secondary" fallback step. It's nil unless that step is configured.
+	secondary            ttsservice.TTSservice
+	secondaryFingerprint string
 }
 
+// ErrInterrupted is returned when a run is cancelled before the audiobook is created.
+// Progress is kept, so running again picks up where it stopped.
+var ErrInterrupted = errors.New("interrupted, run again to resume")
+
 func New() (*Application, error) {
 	// Load configuration
 	c, err := config.Load()
@@ -46,18 +72,18 @@ func New() (*Application, error) {
 	}
 
 	fm := filemanager.New()
-	cacheDir, err := fm.CreateCacheDir()
+	baseDir, err := fm.CreateCacheDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize file manager")
 	}
 
-	// Initialize TTS service
-	tts, err := ttsservice.NewCoquiService(c, cacheDir)
+	// Synthesized audio is shared by every book, everything else belongs to the book's job.
+	audioCache, err := synthcache.New(filepath.Join(baseDir, "audio"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize TTS service: %w", err)
+		return nil, err
 	}
 
-	ffmpeg := audioservice.NewFFMpegService(cacheDir)
+	ffmpeg := audioservice.NewFFMpegService(baseDir)
 
 	// Create logger based on config
 	var (
@@ -75,11 +101,12 @@ func New() (*Application, error) {
 	return &Application{
 		config:      c,
 		fileManager: fm,
-		tts:         tts,
 		audio:       ffmpeg,
 		tui:         t,
 		logger:      l,
-		cacheDir:    cacheDir,
+		cacheDir:    baseDir,
+		jobsDir:     filepath.Join(baseDir, "jobs"),
+		audioCache:  audioCache,
 	}, nil
 }
 
@@ -99,29 +126,53 @@ func (app *Application) Run() error {
 }
 
 func (app *Application) RunContext(ctx context.Context) error {
-	return app.run(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Quitting the TUI stops the whole run, not just the display.
+	app.tui.OnQuit(cancel)
+
+	err := app.run(ctx)
+	if err != nil && ctx.Err() != nil {
+		err = ErrInterrupted
+	}
+
+	app.saveReport(err)
+
+	return err
 }
 
-func (app *Application) run(ctx context.Context) error {
-	defer app.fileManager.Remove(app.cacheDir)
+// saveReport writes the run report to the job directory, whatever the outcome of the run.
+func (app *Application) saveReport(runErr error) {
+	if app.report == nil || app.job == nil {
+		return
+	}
 
-	if app.flag.ResetProgress {
-		app.Reset()
-		// Recreate cache directory after reset
-		var err error
-		app.cacheDir, err = app.fileManager.CreateCacheDir()
-		if err != nil {
-			return fmt.Errorf("failed to recreate cache directory after reset: %w", err)
-		}
+	result := "completed"
+	if runErr != nil {
+		result = runErr.Error()
+	}
+	app.report.Finish(result)
 
-		// Reinitialize TTS service with new cache directory
-		app.tts, err = ttsservice.NewCoquiService(app.config, app.cacheDir)
-		if err != nil {
-			return fmt.Errorf("failed to reinitialize TTS service after reset: %w", err)
-		}
+	if err := app.report.Save(filepath.Join(app.job.Dir(), "report.json")); err != nil {
+		app.logger.Printf("Failed to save run report: %v", err)
+		return
+	}
+
+	app.logger.Printf("Synthesized %d of %d chunks at %.1f chars/s, %d workers at the end",
+		app.report.Synthesized, app.report.Chunks, app.report.Throughput(), app.report.Concurrency.Final)
+	if n := len(app.report.Review); n > 0 {
+		app.logger.Printf("%d chunks failed QA and are listed for review in report.json", n)
+	}
+	if n := app.job.Fallbacks(); n > 0 {
+		app.logger.Printf("%d chunks used a fallback, run with --%s to synthesize them again", n, flags.FlagPatch)
+	}
+}
 
-		// Reinitialize audio service with new cache directory
-		app.audio = audioservice.NewFFMpegService(app.cacheDir)
+func (app *Application) run(ctx context.Context) error {
+	// The voices are checked before the job is opened, a wrong speaker or language is a mistake in the config
+	if err := app.checkVoices(ctx); err != nil {
+		return err
 	}
 
 	// Use TUI unless verbose logging is enabled in config
@@ -129,6 +180,8 @@ func (app *Application) run(ctx context.Context) error {
 	if err := app.tui.Start(); err != nil {
 		log.Printf("Failed to start TUI: %v", err)
 	}
+	// Make sure the terminal is restored if we return early
+	defer app.tui.Stop()
 
 	start := time.Now()
 
@@ -144,66 +197,127 @@ func (app *Application) run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to read epub file: %w", err)
 	}
-	if app.config.Output.Filename == "" {
-		app.config.Output.Filename = book.Metadata.Title
+	outputs, err := resolveOutputs(app.config.Outputs, book.Metadata)
+	if err != nil {
+		return err
 	}
 
-	// File existence check
-	if fsutils.FileExists(app.config.Output.FullPath()) {
-		return fmt.Errorf("File '%s' has already been created.", app.config.Output.OutputFileName())
+	bookId, err := job.Id(epubPath, book.Metadata.Title)
+	if err != nil {
+		return fmt.Errorf("failed to identify book: %w", err)
 	}
 
-	rawChapters, err := r.GetChapters()
-	if err != nil {
-		return err
+	if app.flag.ResetProgress {
+		app.Reset(bookId, outputs)
 	}
 
-	chapters, err := app.ProcessChapters(ctx, rawChapters, book.Metadata)
+	if app.flag.Patch {
+		// The outputs are made again once the fallback chunks have been synthesized
+		for _, o := range outputs {
+			app.fileManager.Remove(o.FullPath())
+		}
+	}
+
+	// File existence check. Outputs that were created by an earlier run are skipped.
+	var pending []config.Output
+	for _, o := range outputs {
+		if fsutils.FileExists(o.FullPath()) {
+			app.logger.Printf("Skipping '%s', it has already been created.", o.FullPath())
+			continue
+		}
+		pending = append(pending, o)
+	}
+	if len(pending) == 0 {
+		return fmt.Errorf("File '%s' has already been created.", outputs[0].OutputFileName())
+	}
+
+	j, err := job.Open(app.jobsDir, job.Book{
+		Id:     bookId,
+		Title:  book.Metadata.Title,
+		Author: book.Metadata.Author,
+		Path:   epubPath,
+	}, app.config.Redacted())
 	if err != nil {
-		return fmt.Errorf("failed to process chapters: %w", err)
+		return fmt.Errorf("failed to open job: %w", err)
 	}
+	defer j.Close()
+	app.job = j
+	app.report = report.New(book.Metadata.Title)
 
-	tempAudiobookFile := filepath.Join(app.cacheDir, "audiobook.wav")
+	if app.flag.Patch {
+		n, err := j.Patch()
+		if err != nil {
+			return fmt.Errorf("failed to prepare patch run: %w", err)
+		}
+		app.logger.Printf("Patching %d chunks that fell back in an earlier run", n)
+	}
 
-	metadata, err := app.BuildMetadataFile(book.Metadata, chapters)
+	// Files for this run live in the job directory
+	app.cacheDir = j.WorkDir()
+	app.audio = audioservice.NewFFMpegService(app.cacheDir)
+	defer app.fileManager.Remove(app.cacheDir)
 
+	// The engines write there too, so the shared cache only ever holds finished audio
+	if app.audioCache, err = app.audioCache.WithTempDir(filepath.Join(app.cacheDir, "synth")); err != nil {
+		return err
+	}
+
+	if err := app.checkFilters(ctx); err != nil {
+		return fmt.Errorf("failed to check filters: %w", err)
+	}
+
+	// The engines are started once the job is open, since prepared speaker clips are kept in it.
+	if err := app.startTTS(ctx); err != nil {
+		return err
+	}
+	defer app.closeTTS()
+
+	rawChapters, err := r.GetChapters()
 	if err != nil {
-		return fmt.Errorf("failed to create metadata: %w", err)
+		return err
 	}
-	defer metadata.Remove()
 
-	// Close the metadata file to ensure all data is written before FFmpeg uses it
-	err = metadata.Close()
+	toc, err := r.GetToc()
 	if err != nil {
-		return fmt.Errorf("failed to close metadata file: %w", err)
+		// Only overrides by section need it
+		app.logger.Printf("Unable to read the table of contents: %v", err)
 	}
+	app.sections = epubreader.Sections(toc)
 
-	// Turn all the chapter wav files into a singular wav file.
-	app.tui.UpdateProgress("Combining all chapters into audiobook...")
-	err = app.CombineChapters(chapters, tempAudiobookFile)
+	chapters, err := app.ProcessChapters(ctx, rawChapters, book.Metadata)
 	if err != nil {
-		return fmt.Errorf("failed to combine chapter files: %w", err)
+		return fmt.Errorf("failed to process chapters: %w", err)
 	}
 
-	app.fileManager.Create(app.config.Output.Path)
+	// Filters go first, since they change how loud the chapters are
+	app.tui.UpdateProgress("Applying filters...")
+	if err := app.ApplyFilters(ctx, chapters); err != nil {
+		return fmt.Errorf("failed to apply filters: %w", err)
+	}
 
-	// Create the M4B audiobook file.
-	app.tui.UpdateProgress("Creating final audiobook file...")
-	err = app.audio.CreateAudiobook(
-		tempAudiobookFile,
-		app.config.Epub.CoverImage,
-		metadata.Name(),
-		app.config.Output.FullPath(),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create audiobook file: %w", err)
+	if app.config.Loudness.Enabled {
+		app.tui.UpdateProgress("Normalizing loudness...")
+		if err := app.NormalizeLoudness(ctx, chapters); err != nil {
+			return fmt.Errorf("failed to normalize loudness: %w", err)
+		}
+	}
+
+	if err := app.createOutputs(ctx, book.Metadata, chapters, pending); err != nil {
+		return err
 	}
 
-	_ = os.Remove(tempAudiobookFile)
+	// The chapter audio is no longer needed
+	if err := app.job.Finish(); err != nil {
+		app.logger.Printf("Failed to finish job: %v", err)
+	}
 
 	// Show completion message
 	completionTime := time.Since(start).Truncate(time.Second)
-	completionMsg := fmt.Sprintf("\n🎉 Audiobook \"%s\" created successfully! (%v)\n", app.config.Output.OutputFileName(), completionTime)
+	names := make([]string, len(pending))
+	for i, o := range pending {
+		names[i] = fmt.Sprintf("%q", o.OutputFileName())
+	}
+	completionMsg := fmt.Sprintf("\n🎉 Audiobook %s created successfully! (%v)\n", strings.Join(names, ", "), completionTime)
 
 	// Stop TUI first to ensure clean terminal state
 	if !app.config.VerboseLogs {
@@ -220,187 +334,46 @@ func (app *Application) run(ctx context.Context) error {
 	return nil
 }
 
-func (app *Application) ProcessChapters(ctx context.Context, chapters []*epubreader.EpubReaderChapter, metadata *epub.EpubMetadata) ([]*epub.EpubChapter, error) {
-	processedChapters := make([]*epub.EpubChapter, 0, len(chapters))
-
-	chapterNumber := 0
-	for _, chapter := range chapters {
-		ch, err := epub.NewChapter(chapter.Id, chapter.Title, chapter.Content)
-		if err != nil || !ch.IsValid() {
-			continue
-		}
-		ch.Path = filepath.Join(app.cacheDir, fmt.Sprintf("chapter-%d.wav", chapterNumber))
-
-		// Update TUI with current chapter being processed
-		app.tui.UpdateProgress(fmt.Sprintf("Processing — %s", ch.Title))
-
-		if fsutils.FileExists(ch.Path) {
-			processedChapters = append(processedChapters, ch)
-			// Mark chapter as complete (cached)
-			app.tui.CompleteCurrentTask(fmt.Sprintf("Chapter %d (cached): %s", chapterNumber, ch.Title))
-			chapterNumber++
-			continue
-		}
-
-		if app.flag.FinishAudiobook {
-			// Skip this chapter if we're finishing audiobook and the file doesn't exist
-			chapterNumber++
-			continue
-		}
-
-		files, err := app.CreateChapterAudio(ctx, ch, chapterNumber, metadata)
+func (app *Application) CombineChapters(ctx context.Context, chapters []*epub.EpubChapter, output string) error {
+	formats := make([]wav.Format, len(chapters))
+	for i, c := range chapters {
+		f, err := wav.ReadFormat(c.Path)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create chapter audio for chapter %d: %w", chapterNumber, err)
+			return fmt.Errorf("failed to read chapter %s: %w", c.Title, err)
 		}
-
-		if len(files) == 0 {
-			app.logger.Printf("No audio files created for chapter %d, skipping", chapterNumber)
-			continue
-		}
-
-		// Sort the paragraph files numerically, because they might be out of order due to concurrency.
-		fsutils.SortNumerically(files)
-
-		err = app.audio.CombineFiles(files, ch.Path)
-		if err != nil {
-			return nil, fmt.Errorf("error combining files for chapter %d: %w", chapterNumber, err)
-		}
-
-		processedChapters = append(processedChapters, ch)
-
-		// Mark chapter as complete
-		app.tui.CompleteCurrentTask(fmt.Sprintf("Chapter %d completed: %s", chapterNumber, ch.Title))
-
-		app.fileManager.RemoveFiles(files)
-
-		chapterNumber++
-	}
-
-	return processedChapters, nil
-}
-
-func (app *Application) CreateChapterAudio(ctx context.Context, chapter *epub.EpubChapter, chapterNumber int, bookMetadata *epub.EpubMetadata) ([]string, error) {
-	text := textutils.ExtractParagraphsFromHTML(chapter.Content)
-	if len(text) == 0 {
-		return nil, fmt.Errorf("chapter does not have text")
+		formats[i] = f
 	}
 
-	// Store the original title for potential removal from content
-	originalTitle := chapter.Title
-
-	// Handle chapter titles differently based on chapter number
-	if chapterNumber == 0 {
-		app.logger.Printf("\n\n-------Processing Introduction-------")
-		chapter.Title = "Introduction"
-
-		text = []string{chapter.Title, fmt.Sprintf("%s by %s", bookMetadata.Title, bookMetadata.Author)}
-	} else {
-		app.logger.Printf("\n\n-------Processing Chapter %d-------", chapterNumber)
-		// For subsequent chapters, ensure proper chapter numbering
-		if chapter.Title == "" {
-			chapter.Title = fmt.Sprintf("Chapter %d", chapterNumber)
-		} else {
-			// If we have a title, prepend chapter number only if it doesn't already contain it
-			if !strings.Contains(strings.ToLower(chapter.Title), "chapter") {
-				chapter.Title = fmt.Sprintf("Chapter %d: %s", chapterNumber, chapter.Title)
-			}
-		}
-
-		// Remove the original title from content if it appears as the first paragraph.
-		// For example, we make "Chapter 1: Title", and we don't want another paragraph with simply "Title", otherwise we have it spoken twice.
-		if originalTitle != "" && len(text) > 0 && strings.TrimSpace(text[0]) == strings.TrimSpace(originalTitle) {
-			text = text[1:]
-		}
-
-		// Prepend the chapter title as the first paragraph
-		text = append([]string{chapter.Title}, text...)
-	}
+	// Chapters spoken by different engines can differ in sample rate or channels, but they're joined without
+	// re-encoding, so when they differ every chapter is converted to 16-bit PCM with the first one's rate and channels.
+	convert := slices.ContainsFunc(formats, func(f wav.Format) bool { return f != formats[0] })
 
 	var files []string
-	var mu sync.Mutex
-	var completed int
-	totalParagraphs := len(text)
-
-	// Initialize progress
-	app.tui.UpdateProgressWithBar(
-		fmt.Sprintf("Processing — %s", chapter.Title), 0, totalParagraphs,
-	)
-
-	eg, ctx := errgroup.WithContext(ctx)
-	eg.SetLimit(int(app.config.Model.Concurrency))
-
-	add := func(p string) error {
-		mu.Lock()
-		files = append(files, p)
-		completed++
-		currentCompleted := completed
-		mu.Unlock()
-
-		// Update progress bar
-		app.tui.UpdateProgressWithBar(
-			fmt.Sprintf("Processing — %s", chapter.Title), currentCompleted, totalParagraphs,
-		)
-
-		return nil
-	}
-
-	for i, p := range text {
-		if p == "" {
+	for i, c := range chapters {
+		f := formats[i]
+		if !convert || (f.IsPCM16() && f.SampleRate == formats[0].SampleRate && f.Channels == formats[0].Channels) {
+			files = append(files, c.Path)
 			continue
 		}
-		i, p := i, p // capture
-		eg.Go(func() error {
-			name := fmt.Sprintf("paragraph-%d.wav", i+1)
-			path := filepath.Join(app.cacheDir, name)
 
-			if fsutils.FileExists(path) {
-				return add(path)
-			}
-
-			if app.flag.FinishAudiobook {
-				return nil
-			}
-
-			if _, err := app.tts.SynthesizeContext(ctx, p, name); err != nil {
-				return fmt.Errorf("chapter %d part %d: %w", chapterNumber, i+1, err)
-			}
-			if !fsutils.FileExists(path) {
-				return fmt.Errorf("synthesised file missing for chapter %d part %d", chapterNumber, i+1)
-			}
-
-			return add(path)
-		})
-	}
-
-	if err := eg.Wait(); err != nil {
-		return nil, err
-	}
-	return files, nil
-}
-
-func (app *Application) CombineChapters(chapters []*epub.EpubChapter, output string) error {
-	var files []string
-	for _, c := range chapters {
-		files = append(files, c.Path)
-	}
-
-	err := app.audio.CombineFiles(files, output)
-	if err != nil {
-		return err
+		path := filepath.Join(app.cacheDir, fmt.Sprintf("chapter-%04d-resampled.wav", i+1))
+		if err := app.audio.Resample(ctx, c.Path, path, formats[0].SampleRate, formats[0].Channels); err != nil {
+			return fmt.Errorf("failed to convert chapter %s: %w", c.Title, err)
+		}
+		defer os.Remove(path)
+		files = append(files, path)
 	}
 
-	// No longer need the chapter audio files
-	app.fileManager.RemoveFiles(files)
-
-	return nil
+	// The chapter files are kept until the audiobook is created, in case this run fails later on.
+	return app.audio.CombineFiles(ctx, files, output)
 }
 
-func (app *Application) BuildMetadataFile(book *epub.EpubMetadata, chapters []*epub.EpubChapter) (*metadata.Metadata, error) {
+func (app *Application) BuildMetadataFile(ctx context.Context, book *epub.EpubMetadata, chapters []*epub.EpubChapter, format string) (*metadata.Metadata, error) {
 	metaFile, err := metadata.New(app.cacheDir)
 	if err != nil {
 		return nil, err
 	}
-	metaFile.AddDetails(book)
+	metaFile.AddDetails(book, format)
 
 	startTime := 0
 
@@ -413,7 +386,7 @@ func (app *Application) BuildMetadataFile(book *epub.EpubMetadata, chapters []*e
 
 		// Get the chapter duration
 		// Then append it to the startTime so that we can calculate the endTime for the next chapter.
-		duration, err := app.audio.GetDuration(chapter.Path)
+		duration, err := app.audio.GetDuration(ctx, chapter.Path)
 		if err != nil {
 			metaFile.Close()
 			return nil, fmt.Errorf("failed to get duration for chapter: %s: %w", chapter.Title, err)
@@ -422,7 +395,7 @@ func (app *Application) BuildMetadataFile(book *epub.EpubMetadata, chapters []*e
 		durationMs := int(duration * 1000)
 		endTime := startTime + durationMs
 
-		err = metaFile.AddChapter(chapter.Title, startTime, endTime)
+		err = metaFile.AddChapter(chapter.Title, chapter.Voice, startTime, endTime)
 		if err != nil {
 			metaFile.Close()
 			return nil, fmt.Errorf("failed to create metadata for chapter: %s: %w", chapter.Title, err)
@@ -434,9 +407,18 @@ func (app *Application) BuildMetadataFile(book *epub.EpubMetadata, chapters []*e
 	return metaFile, nil
 }
 
-func (app *Application) Reset() {
-	app.fileManager.Remove(app.config.Output.Path)
-	app.fileManager.Remove(app.cacheDir)
+// Reset removes the book's outputs and job, so it is created from scratch.
+// Cached audio is shared with other books and is kept.
+func (app *Application) Reset(bookId string, outputs []config.Output) {
+	for _, o := range outputs {
+		app.fileManager.Remove(o.FullPath())
+	}
+	job.Remove(app.jobsDir, bookId)
+}
+
+// PruneCache trims the audio cache shared by every book, see synthcache.Cache.Prune.
+func (app *Application) PruneCache(maxSize int64, maxAge time.Duration) (synthcache.Pruned, error) {
+	return app.audioCache.Prune(maxSize, maxAge)
 }
 
 func (app *Application) ToggleTUI() {
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/tui"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// quittingTUI acts like the user quitting as soon as the UI starts.
+type quittingTUI struct {
+	tui.EmptyTUI
+	quit func()
+}
+
+func (q *quittingTUI) OnQuit(fn func()) { q.quit = fn }
+
+func (q *quittingTUI) Start() error {
+	q.quit()
+	return nil
+}
+
+func TestRunContextInterrupted(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	app.tui = &quittingTUI{}
+
+	if err := app.RunContext(context.Background()); !errors.Is(err, ErrInterrupted) {
+		t.Errorf("err = %v, want the run to be interrupted", err)
+	}
+
+	// Errors that aren't caused by stopping the run are returned as they are
+	app, _ = testApp(t, t.TempDir(), "test-book")
+	app.config.Epub.Path = filepath.Join(t.TempDir(), "missing.epub")
+	if err := app.RunContext(context.Background()); err == nil || errors.Is(err, ErrInterrupted) {
+		t.Errorf("err = %v, want the missing book", err)
+	}
+}
+
+// resamplingAudio writes a second of silence in the requested format for every file it resamples.
+type resamplingAudio struct {
+	testAudio
+	resampled []string
+}
+
+func (a *resamplingAudio) Resample(ctx context.Context, inputFile, outputFile string, sampleRate, channels int) error {
+	a.resampled = append(a.resampled, inputFile)
+	return wav.WriteFile(outputFile, sampleRate, make([]int16, sampleRate*channels))
+}
+
+func TestCombineChapters(t *testing.T) {
+	dir := t.TempDir()
+	chapter := func(name string, sampleRate int) *epub.EpubChapter {
+		path := filepath.Join(dir, name)
+		if err := wav.WriteFile(path, sampleRate, make([]int16, sampleRate)); err != nil {
+			t.Fatal(err)
+		}
+		return &epub.EpubChapter{Title: name, Path: path}
+	}
+
+	audio := &resamplingAudio{}
+	app := &Application{audio: audio, cacheDir: t.TempDir()}
+	output := filepath.Join(dir, "audiobook.wav")
+
+	// Chapters in the same format are joined as they are
+	same := []*epub.EpubChapter{chapter("one.wav", 22050), chapter("two.wav", 22050)}
+	if err := app.CombineChapters(context.Background(), same, output); err != nil {
+		t.Fatal(err)
+	}
+	if len(audio.resampled) != 0 {
+		t.Errorf("resampled %q, want nothing", audio.resampled)
+	}
+
+	// A chapter from another engine is brought to the first chapter's rate
+	mixed := []*epub.EpubChapter{same[0], chapter("other.wav", 24000), same[1]}
+	if err := app.CombineChapters(context.Background(), mixed, output); err != nil {
+		t.Fatal(err)
+	}
+	if len(audio.resampled) != 1 || audio.resampled[0] != mixed[1].Path {
+		t.Errorf("resampled %q, want only the 24kHz chapter", audio.resampled)
+	}
+	combined, err := wav.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if combined.SampleRate != 22050 || combined.Duration() != 3 {
+		t.Errorf("combined %gs at %dHz, want 3s at 22050Hz", combined.Duration(), combined.SampleRate)
+	}
+}
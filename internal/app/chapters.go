@@ -0,0 +1,423 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/autotune"
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/epubreader"
+	"github.com/pixellini/go-audiobook/internal/report"
+	"github.com/pixellini/go-audiobook/internal/synthcache"
+	"github.com/pixellini/go-audiobook/internal/textutils"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+	"github.com/pixellini/go-audiobook/internal/workqueue"
+)
+
+// chapterWork is a chapter whose paragraphs are waiting on the work queue.
+type chapterWork struct {
+	chapter *epub.EpubChapter
+	number  int
+
+	// engine speaks the chapter's voice.
+	engine *engine
+	// native is the prosody the engine applies while synthesizing, and residual is applied to the chapter afterwards.
+	native   config.Prosody
+	residual config.Prosody
+	// fingerprint identifies the engine settings for the chapter's audio in the cache.
+	fingerprint string
+
+	mu sync.Mutex
+	// files are kept in paragraph order, since they finish out of order due to concurrency.
+	files     []string
+	remaining int
+}
+
+// done stores the paragraph's audio and reports whether it was the last one the chapter was waiting for.
+func (w *chapterWork) done(i int, path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.files[i] = path
+	w.remaining--
+	return w.remaining == 0
+}
+
+// chunkPriority orders the queue by chapter, then paragraph.
+// Earlier chapters finish first, so they can be combined while later ones are still being synthesized.
+func chunkPriority(chapter, chunk int) int64 {
+	return int64(chapter)<<32 | int64(chunk)
+}
+
+// ProcessChapters synthesizes every chapter's paragraphs on one shared work queue,
+// and combines each chapter in the background as soon as all of its paragraphs are done.
+func (app *Application) ProcessChapters(ctx context.Context, chapters []*epubreader.EpubReaderChapter, metadata *epub.EpubMetadata) ([]*epub.EpubChapter, error) {
+	processedChapters := make([]*epub.EpubChapter, 0, len(chapters))
+	queue := workqueue.New(ctx, int(app.config.Model.Concurrency))
+
+	concurrency := report.Concurrency{Initial: queue.Limit()}
+	if app.config.Model.AutoConcurrency {
+		m := app.config.Model
+		app.tuner = autotune.New(int(m.MinConcurrency), int(m.MaxConcurrency), int(m.Concurrency), m.TuneInterval, queue.SetLimit)
+		queue.SetLimit(app.tuner.Workers())
+
+		concurrency.Auto = true
+		concurrency.Min = int(m.MinConcurrency)
+		concurrency.Max = int(m.MaxConcurrency)
+		concurrency.Initial = app.tuner.Workers()
+
+		tunerCtx, stopTuner := context.WithCancel(ctx)
+		defer stopTuner()
+		go app.tuner.Run(tunerCtx)
+	}
+
+	defer func() {
+		concurrency.Final = queue.Limit()
+		if app.tuner != nil {
+			concurrency.Adjustments = app.tuner.Decisions()
+		}
+		app.report.SetConcurrency(concurrency)
+	}()
+
+	var (
+		mu        sync.Mutex
+		completed int
+		total     int
+	)
+
+	progress := func(title string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		completed++
+		app.tui.UpdateProgressWithBar(
+			fmt.Sprintf("Processing — %s", title), completed, total,
+		)
+	}
+
+	chapterNumber := 0
+	for _, chapter := range chapters {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ch, err := epub.NewChapter(chapter.Id, chapter.Title, chapter.Content)
+		if err != nil || !ch.IsValid() {
+			continue
+		}
+		ch.Path = app.job.ChapterPath(chapterNumber)
+
+		// Overrides match the title as it is in the book, before it's numbered
+		info := config.ChapterInfo{
+			Index:    chapterNumber,
+			Title:    ch.Title,
+			Sections: app.sections[chapter.Path],
+		}
+		chapterConfig := app.config.ChapterConfig(info)
+		ch.Voice = chapterConfig.Voice()
+
+		// Chapters done in an earlier run need the same title as ones synthesized now, for the chapter markers
+		originalTitle := ch.Title
+		ch.Title = chapterTitle(ch.Title, chapterNumber)
+
+		text, textErr := app.chapterText(ch, originalTitle, chapterNumber, metadata)
+		fingerprint := app.chapterFingerprint(chapterConfig, info, text)
+
+		if app.job.ChapterDone(chapterNumber, fingerprint) {
+			processedChapters = append(processedChapters, ch)
+			// Mark chapter as complete (cached)
+			app.tui.UpdateProgress(fmt.Sprintf("Processing — %s", ch.Title))
+			app.tui.CompleteCurrentTask(fmt.Sprintf("Chapter %d (cached): %s", chapterNumber, ch.Title))
+			chapterNumber++
+			continue
+		}
+
+		if app.flag.FinishAudiobook {
+			// Skip this chapter if we're finishing audiobook and the file doesn't exist
+			chapterNumber++
+			continue
+		}
+
+		if textErr != nil {
+			return nil, fmt.Errorf("unable to create chapter audio for chapter %d: %w", chapterNumber, textErr)
+		}
+
+		e, err := app.engineFor(ctx, chapterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to start the voice for chapter %d: %w", chapterNumber, err)
+		}
+
+		prosody := app.config.ChapterProsody(info)
+		native := ttsservice.NativeProsody(e.tts, prosody)
+
+		w := &chapterWork{
+			chapter:     ch,
+			number:      chapterNumber,
+			engine:      e,
+			native:      native,
+			residual:    prosody.Without(native),
+			fingerprint: prosodyFingerprint(e.fingerprint, native),
+			files:       make([]string, len(text)),
+		}
+		for _, p := range text {
+			if p != "" {
+				w.remaining++
+			}
+		}
+
+		if w.remaining == 0 {
+			app.logger.Printf("No audio files created for chapter %d, skipping", chapterNumber)
+			chapterNumber++
+			continue
+		}
+
+		if err := app.job.StartChapter(chapterNumber, ch.Title, ch.Voice, fingerprint, len(text)); err != nil {
+			return nil, err
+		}
+
+		processedChapters = append(processedChapters, ch)
+
+		mu.Lock()
+		total += w.remaining
+		mu.Unlock()
+
+		for i, p := range text {
+			if p == "" {
+				continue
+			}
+
+			queue.Push(chunkPriority(w.number, i), func(ctx context.Context) error {
+				path, err := app.synthesizeChunk(ttsservice.WithProsody(ctx, w.native), w, i, p)
+				if err != nil {
+					return err
+				}
+
+				if w.done(i, path) {
+					queue.Go(func(ctx context.Context) error {
+						return app.combineChapter(ctx, w)
+					})
+				}
+
+				progress(w.chapter.Title)
+				return nil
+			})
+		}
+
+		chapterNumber++
+	}
+
+	if err := queue.Wait(); err != nil {
+		return nil, err
+	}
+
+	return processedChapters, nil
+}
+
+// chapterTitle is the title the chapter is announced and marked with. The first chapter is the introduction,
+// and the others are numbered unless their title already says which chapter they are.
+func chapterTitle(title string, chapterNumber int) string {
+	switch {
+	case chapterNumber == 0:
+		return "Introduction"
+	case title == "":
+		return fmt.Sprintf("Chapter %d", chapterNumber)
+	case !strings.Contains(strings.ToLower(title), "chapter"):
+		return fmt.Sprintf("Chapter %d: %s", chapterNumber, title)
+	default:
+		return title
+	}
+}
+
+// chapterText returns the paragraphs to be spoken for the chapter, starting with its title.
+// originalTitle is the chapter's title in the book, before chapterTitle.
+func (app *Application) chapterText(chapter *epub.EpubChapter, originalTitle string, chapterNumber int, bookMetadata *epub.EpubMetadata) ([]string, error) {
+	text := textutils.ExtractParagraphsFromHTML(chapter.Content)
+	if len(text) == 0 {
+		return nil, fmt.Errorf("chapter does not have text")
+	}
+
+	if chapterNumber == 0 {
+		app.logger.Printf("\n\n-------Processing Introduction-------")
+		return []string{chapter.Title, fmt.Sprintf("%s by %s", bookMetadata.Title, bookMetadata.Author)}, nil
+	}
+
+	app.logger.Printf("\n\n-------Processing Chapter %d-------", chapterNumber)
+
+	// Remove the original title from content if it appears as the first paragraph.
+	// For example, we make "Chapter 1: Title", and we don't want another paragraph with simply "Title", otherwise we have it spoken twice.
+	if originalTitle != "" && len(text) > 0 && strings.TrimSpace(text[0]) == strings.TrimSpace(originalTitle) {
+		text = text[1:]
+	}
+
+	// Prepend the chapter title as the first paragraph
+	return append([]string{chapter.Title}, text...), nil
+}
+
+// chapterFingerprint identifies the chapter's text and the settings its audio is made with,
+// so a chapter finished in an earlier run is only kept if it would sound the same now.
+func (app *Application) chapterFingerprint(c *config.Config, info config.ChapterInfo, text []string) string {
+	h := sha256.New()
+	fmt.Fprintln(h, ttsservice.Fingerprint(c))
+	fmt.Fprintf(h, "%+v\n", app.config.Speaker)
+	fmt.Fprintf(h, "%+v\n", app.config.ChapterProsody(info))
+	fmt.Fprintf(h, "%+v\n", app.config.Joins)
+	for _, p := range text {
+		fmt.Fprintln(h, synthcache.Key(p, ""))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// prosodyFingerprint adds the prosody the engine applies to the fingerprint, since it changes the audio.
+func prosodyFingerprint(fingerprint string, native config.Prosody) string {
+	if native.IsNeutral() {
+		return fingerprint
+	}
+	return fmt.Sprintf("%s|rate=%g|pitch=%g|gain=%g", fingerprint, native.Speed(), native.Pitch, native.Gain)
+}
+
+// synthesizeChunk returns the audio for one paragraph, from the cache if possible.
+func (app *Application) synthesizeChunk(ctx context.Context, w *chapterWork, i int, text string) (string, error) {
+	chapterNumber := w.number
+	key := synthcache.Key(text, w.fingerprint)
+
+	if path, ok := app.audioCache.Lookup(key); ok {
+		// The audio may have come from another chapter or book
+		if !app.job.ChunkDone(chapterNumber, i, key) {
+			if err := app.job.CompleteChunk(chapterNumber, i, key, 0); err != nil {
+				return "", err
+			}
+		}
+		app.report.AddChunk(utf8.RuneCountInString(text), true, 0)
+		w.engine.format.note(path)
+		return path, nil
+	}
+
+	start := time.Now()
+	t, err := app.synthesize(ctx, w.engine.tts, key, text)
+	if err != nil {
+		// Chunks stopped by cancellation haven't failed, they just need to run again.
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("chapter %d part %d: %w", chapterNumber, i+1, err)
+		}
+
+		// The fallback audio isn't cached, so the chunk is synthesized again by a later patch run.
+		path, fallbackErr := app.fallback(ctx, w, i, key, text, err)
+		if fallbackErr == nil {
+			return path, nil
+		}
+		if ctx.Err() == nil {
+			app.job.FailChunk(chapterNumber, i, key, err)
+		}
+		return "", fmt.Errorf("chapter %d part %d: %w", chapterNumber, i+1, err)
+	}
+
+	if t.attempts > 1 {
+		app.report.AddRetry()
+	}
+	var path string
+	if t.analysis != nil && !t.analysis.Ok() {
+		app.logger.Printf("Chapter %d part %d needs review after %d attempts: %s",
+			chapterNumber, i+1, t.attempts, strings.Join(t.analysis.Issues, ", "))
+		app.report.AddReview(report.Review{
+			Chapter:  chapterNumber,
+			Part:     i + 1,
+			Key:      key,
+			Text:     text,
+			Attempts: t.attempts,
+			Analysis: *t.analysis,
+		})
+
+		// Flagged audio isn't cached, so the next run synthesizes and checks it again.
+		path = filepath.Join(app.cacheDir, fmt.Sprintf("review-%d-%d.wav", chapterNumber, i))
+		err = os.Rename(filepath.Join(app.audioCache.TempDir(), t.name), path)
+	} else {
+		path, err = app.audioCache.Commit(key, t.name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("synthesised file missing for chapter %d part %d: %w", chapterNumber, i+1, err)
+	}
+
+	w.engine.format.note(path)
+
+	elapsed := time.Since(start)
+	if err := app.job.CompleteChunk(chapterNumber, i, key, elapsed); err != nil {
+		return "", err
+	}
+
+	app.report.AddChunk(utf8.RuneCountInString(text), false, elapsed)
+	if app.tuner != nil {
+		app.tuner.Record(utf8.RuneCountInString(text))
+	}
+
+	return path, nil
+}
+
+// combineChapter joins the chapter's paragraphs into the chapter audio file.
+func (app *Application) combineChapter(ctx context.Context, w *chapterWork) error {
+	output := w.chapter.Path
+	if !w.residual.IsNeutral() {
+		output = filepath.Join(app.cacheDir, fmt.Sprintf("chapter-%d-raw.wav", w.number))
+		defer app.fileManager.Remove(output)
+	}
+
+	if err := app.joinClips(ctx, w, output); err != nil {
+		return fmt.Errorf("error combining files for chapter %d: %w", w.number, err)
+	}
+
+	// Adjusting the whole chapter at once is quicker than every paragraph,
+	// and the chapter timings are read from the adjusted file.
+	if output != w.chapter.Path {
+		if err := app.audio.AdjustProsody(ctx, output, w.chapter.Path, w.residual); err != nil {
+			return fmt.Errorf("error adjusting prosody for chapter %d: %w", w.number, err)
+		}
+	}
+
+	if err := app.job.FinishChapter(w.number); err != nil {
+		return fmt.Errorf("failed to record chapter %d: %w", w.number, err)
+	}
+
+	// Mark chapter as complete
+	app.tui.UpdateProgress(fmt.Sprintf("Processing — %s", w.chapter.Title))
+	app.tui.CompleteCurrentTask(fmt.Sprintf("Chapter %d completed: %s", w.number, w.chapter.Title))
+
+	return nil
+}
+
+// joinClips joins the chapter's paragraphs with the configured pauses between them,
+// or as they are if joins are disabled.
+func (app *Application) joinClips(ctx context.Context, w *chapterWork, output string) error {
+	joins := app.config.Joins
+	if !joins.Enabled {
+		files := slices.DeleteFunc(slices.Clone(w.files), func(f string) bool { return f == "" })
+		return app.audio.CombineFiles(ctx, files, output)
+	}
+
+	// The first paragraph is the chapter title
+	var clips []audioservice.Clip
+	for i, f := range w.files {
+		if f == "" {
+			continue
+		}
+		pause := joins.ParagraphPause
+		if i == 0 {
+			pause = joins.TitlePause
+		}
+		clips = append(clips, audioservice.Clip{Path: f, Pause: pause})
+	}
+	if len(clips) > 0 {
+		clips[len(clips)-1].Pause = joins.ChapterPause
+	}
+
+	return app.audio.JoinClips(ctx, clips, output, joins)
+}
@@ -0,0 +1,428 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/epubreader"
+	"github.com/pixellini/go-audiobook/internal/filemanager"
+	"github.com/pixellini/go-audiobook/internal/flags"
+	"github.com/pixellini/go-audiobook/internal/job"
+	"github.com/pixellini/go-audiobook/internal/logger"
+	"github.com/pixellini/go-audiobook/internal/report"
+	"github.com/pixellini/go-audiobook/internal/synthcache"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+	"github.com/pixellini/go-audiobook/internal/tui"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+const (
+	testCharsPerSecond = 15
+	testSampleRate     = 22050
+)
+
+var testBook = &epub.EpubMetadata{Title: "The Test Book", Author: "A. Writer"}
+
+var testChapters = []*epubreader.EpubReaderChapter{
+	{Id: "title", Title: "Title Page", Content: "<p>The Test Book</p>"},
+	{Id: "ch01", Title: "The Beginning", Content: "<h1>The Beginning</h1><p>It was a bright cold day in April.</p><p>The clocks were striking thirteen.</p>"},
+	{Id: "ch02", Title: "Chapter Two", Content: "<p>Nothing happened for a while.</p>"},
+	// Skipped, it isn't a chapter
+	{Id: "css", Title: "style", Content: "body {}"},
+}
+
+// testAudio joins the fake engine's WAV files in Go, so the tests don't need ffmpeg.
+// Anything else it's asked to do panics.
+type testAudio struct {
+	audioservice.AudioService
+}
+
+func (testAudio) CombineFiles(ctx context.Context, inputFiles []string, outputFile string) error {
+	sampleRate := testSampleRate
+	var samples []int16
+	for i, f := range inputFiles {
+		audio, err := wav.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			sampleRate = audio.SampleRate
+		} else if audio.SampleRate != sampleRate {
+			return fmt.Errorf("can't join %dHz audio to %dHz", audio.SampleRate, sampleRate)
+		}
+		for _, v := range audio.Samples {
+			samples = append(samples, int16(max(math.MinInt16, min(math.MaxInt16, v*32768))))
+		}
+	}
+	return wav.WriteFile(outputFile, sampleRate, samples)
+}
+
+// countingTTS counts the chunks that are actually synthesized.
+type countingTTS struct {
+	ttsservice.TTSservice
+	calls atomic.Int64
+}
+
+func (c *countingTTS) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	c.calls.Add(1)
+	return c.TTSservice.SynthesizeContext(ctx, text, output)
+}
+
+// testApp returns an application in test mode that keeps its jobs and audio cache in root,
+// so a second one with the same root and book resumes the first one's run.
+func testApp(t *testing.T, root, book string) (*Application, *countingTTS) {
+	t.Helper()
+
+	c := &config.Config{TestMode: true}
+	c.Model.Concurrency = 2
+	c.Test = config.Test{Signal: ttsservice.SignalTone, CharsPerSecond: testCharsPerSecond, SampleRate: testSampleRate}
+
+	j, err := job.Open(filepath.Join(root, "jobs"), job.Book{Id: book, Title: testBook.Title}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	shared, err := synthcache.New(filepath.Join(root, "audio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioCache, err := shared.WithTempDir(filepath.Join(j.WorkDir(), "synth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &Application{
+		config:      c,
+		fileManager: filemanager.New(),
+		audio:       testAudio{},
+		flag:        &flags.Flags{},
+		tui:         tui.NewEmpty(),
+		logger:      logger.NewSilentLogger(),
+		cacheDir:    j.WorkDir(),
+		jobsDir:     filepath.Join(root, "jobs"),
+		job:         j,
+		report:      report.New(testBook.Title),
+		audioCache:  audioCache,
+	}
+	if err := app.startTTS(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.closeTTS)
+
+	main := app.mainEngine()
+	tts := &countingTTS{TTSservice: main.tts}
+	main.tts = tts
+	return app, tts
+}
+
+// mainEngine is the engine for chapters without a voice override.
+func (app *Application) mainEngine() *engine {
+	return app.engines[ttsservice.Fingerprint(app.config)]
+}
+
+// Resample copies audio that's already in the requested format. It can't convert anything.
+func (testAudio) Resample(ctx context.Context, inputFile, outputFile string, sampleRate, channels int) error {
+	audio, err := wav.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	if audio.SampleRate != sampleRate || audio.Channels != channels {
+		return fmt.Errorf("can't resample %dHz with %d channels to %dHz with %d", audio.SampleRate, audio.Channels, sampleRate, channels)
+	}
+
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFile, data, 0644)
+}
+
+// AdjustProsody only changes the speed, by dropping or repeating samples.
+func (testAudio) AdjustProsody(ctx context.Context, inputFile, outputFile string, prosody config.Prosody) error {
+	audio, err := wav.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+
+	rate := prosody.Speed()
+	samples := make([]int16, int(float64(len(audio.Samples))/rate))
+	for i := range samples {
+		samples[i] = int16(audio.Samples[int(float64(i)*rate)] * math.MaxInt16)
+	}
+	return wav.WriteFile(outputFile, audio.SampleRate, samples)
+}
+
+// ApplyFilters copies the input, ignoring the filters.
+func (testAudio) ApplyFilters(ctx context.Context, inputFile, outputFile string, filters []string) error {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFile, data, 0644)
+}
+
+// clipSeconds is how long the fake engine speaks text for.
+func clipSeconds(text string) float64 {
+	return max(0.25, float64(utf8.RuneCountInString(text))/testCharsPerSecond)
+}
+
+// wavSeconds is the length of a 16-bit mono WAV file.
+func wavSeconds(t *testing.T, path string) float64 {
+	t.Helper()
+
+	audio, err := wav.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return audio.Duration()
+}
+
+func TestProcessChaptersTestMode(t *testing.T) {
+	app, tts := testApp(t, t.TempDir(), "test-book")
+
+	chapters, err := app.ProcessChapters(context.Background(), testChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		title      string
+		paragraphs []string
+	}{
+		{"Introduction", []string{"Introduction", "The Test Book by A. Writer"}},
+		// The title isn't read twice
+		{"Chapter 1: The Beginning", []string{"Chapter 1: The Beginning", "It was a bright cold day in April.", "The clocks were striking thirteen."}},
+		{"Chapter Two", []string{"Chapter Two", "Nothing happened for a while."}},
+	}
+	if len(chapters) != len(want) {
+		t.Fatalf("got %d chapters, want %d", len(chapters), len(want))
+	}
+
+	for i, w := range want {
+		ch := chapters[i]
+		if ch.Title != w.title {
+			t.Errorf("chapter %d title = %q, want %q", i, ch.Title, w.title)
+		}
+		if ch.Path != app.job.ChapterPath(i) {
+			t.Errorf("chapter %d path = %s, want %s", i, ch.Path, app.job.ChapterPath(i))
+		}
+		if !chapterFinished(app, i) {
+			t.Errorf("chapter %d isn't done in the job", i)
+		}
+
+		expected := 0.0
+		for _, text := range w.paragraphs {
+			expected += clipSeconds(text)
+		}
+		// The fake engine rounds each clip down to a whole sample
+		if got := wavSeconds(t, ch.Path); math.Abs(got-expected) > 0.001 {
+			t.Errorf("chapter %d lasts %.3fs, want %.3fs", i, got, expected)
+		}
+	}
+
+	if n := tts.calls.Load(); n != 7 {
+		t.Errorf("synthesized %d chunks, want 7", n)
+	}
+	if app.report.Chunks != 7 || app.report.Synthesized != 7 {
+		t.Errorf("report has %d chunks, %d synthesized, want 7 and 7", app.report.Chunks, app.report.Synthesized)
+	}
+	if c := app.report.Concurrency; c.Auto || c.Initial != 2 || c.Final != 2 {
+		t.Errorf("report concurrency = %+v, want a fixed 2", c)
+	}
+}
+
+func TestProcessChaptersAutoConcurrency(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	app.config.Model.AutoConcurrency = true
+	app.config.Model.MinConcurrency = 1
+	app.config.Model.MaxConcurrency = 3
+	app.config.Model.TuneInterval = time.Hour
+
+	if _, err := app.ProcessChapters(context.Background(), testChapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+
+	if app.tuner == nil {
+		t.Fatal("no tuner with auto concurrency")
+	}
+	c := app.report.Concurrency
+	if !c.Auto || c.Min != 1 || c.Max != 3 || c.Initial != 2 || c.Final != 2 {
+		t.Errorf("report concurrency = %+v, want auto between 1 and 3 starting at 2", c)
+	}
+}
+
+func TestProcessChaptersCached(t *testing.T) {
+	root := t.TempDir()
+
+	app, _ := testApp(t, root, "test-book")
+	first, err := app.ProcessChapters(context.Background(), testChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another book with the same text is made from the cached audio
+	app, tts := testApp(t, root, "another-book")
+	again, err := app.ProcessChapters(context.Background(), testChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := tts.calls.Load(); n != 0 {
+		t.Errorf("synthesized %d chunks, want them all from the cache", n)
+	}
+	if app.report.Cached != 7 || app.report.Synthesized != 0 {
+		t.Errorf("report has %d cached, %d synthesized, want 7 and 0", app.report.Cached, app.report.Synthesized)
+	}
+	for i := range first {
+		if got, want := wavSeconds(t, again[i].Path), wavSeconds(t, first[i].Path); got != want {
+			t.Errorf("chapter %d lasts %.3fs from the cache, want %.3fs", i, got, want)
+		}
+	}
+}
+
+func TestProcessChaptersResume(t *testing.T) {
+	root := t.TempDir()
+
+	app, _ := testApp(t, root, "test-book")
+	first, err := app.ProcessChapters(context.Background(), testChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second run finds every chapter done, even with the audio cache gone
+	if err := os.RemoveAll(filepath.Join(root, "audio")); err != nil {
+		t.Fatal(err)
+	}
+	app, tts := testApp(t, root, "test-book")
+	resumed, err := app.ProcessChapters(context.Background(), testChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resumed) != len(first) {
+		t.Fatalf("resumed %d chapters, want %d", len(resumed), len(first))
+	}
+	for i := range first {
+		if resumed[i].Path != first[i].Path {
+			t.Errorf("resumed chapter %d is at %s, want %s", i, resumed[i].Path, first[i].Path)
+		}
+		if resumed[i].Title != first[i].Title {
+			t.Errorf("resumed chapter %d is titled %q, want %q", i, resumed[i].Title, first[i].Title)
+		}
+	}
+	if n := tts.calls.Load(); n != 0 {
+		t.Errorf("resumed run synthesized %d chunks, want none", n)
+	}
+}
+
+func TestProcessChaptersResumeChanged(t *testing.T) {
+	root := t.TempDir()
+
+	app, _ := testApp(t, root, "test-book")
+	if _, err := app.ProcessChapters(context.Background(), testChapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+	stale := app.job.ChapterPath(0)
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The paragraphs are still cached, but the chapter is joined with other pauses now
+	app, tts := testApp(t, root, "test-book")
+	app.config.Joins.ParagraphPause = time.Second
+	if _, err := app.ProcessChapters(context.Background(), testChapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(stale); err != nil || string(data) == "stale" {
+		t.Errorf("the finished chapter was kept after the joins changed: %v", err)
+	}
+	if n := tts.calls.Load(); n != 0 {
+		t.Errorf("synthesized %d chunks, want them all from the cache", n)
+	}
+}
+
+// chapterFinished reports whether the job recorded the chapter as finished.
+func chapterFinished(app *Application, index int) bool {
+	for _, ch := range app.job.Manifest().Chapters {
+		if ch.Index == index {
+			return ch.State == job.StateDone
+		}
+	}
+	return false
+}
+
+func TestProcessChaptersCancel(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := app.ProcessChapters(ctx, testChapters, testBook); err == nil {
+		t.Error("expected an error after cancelling")
+	}
+	if chapterFinished(app, 0) {
+		t.Error("a chapter was finished after cancelling")
+	}
+}
+
+// cancellingTTS cancels the run as soon as the first chunk is synthesized.
+type cancellingTTS struct {
+	ttsservice.TTSservice
+	cancel context.CancelFunc
+}
+
+func (c *cancellingTTS) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	c.cancel()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProcessChaptersCancelKeepsChunksPending(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app.mainEngine().tts = &cancellingTTS{TTSservice: app.mainEngine().tts, cancel: cancel}
+
+	if _, err := app.ProcessChapters(ctx, testChapters, testBook); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want the cancellation", err)
+	}
+
+	// Chunks stopped by cancelling run again next time rather than being recorded as failures
+	for _, ch := range app.job.Manifest().Chapters {
+		for i, chunk := range ch.Chunks {
+			if chunk.State == job.StateFailed {
+				t.Errorf("chapter %d chunk %d failed after cancelling: %s", ch.Index, i, chunk.Error)
+			}
+		}
+	}
+}
+
+func TestChapterTitle(t *testing.T) {
+	for _, tt := range []struct {
+		title  string
+		number int
+		want   string
+	}{
+		{"Cover", 0, "Introduction"},
+		{"", 3, "Chapter 3"},
+		{"The Storm", 2, "Chapter 2: The Storm"},
+		{"CHAPTER TWO", 2, "CHAPTER TWO"},
+	} {
+		if got := chapterTitle(tt.title, tt.number); got != tt.want {
+			t.Errorf("chapterTitle(%q, %d) = %q, want %q", tt.title, tt.number, got, tt.want)
+		}
+	}
+}
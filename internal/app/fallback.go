@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/report"
+	"github.com/pixellini/go-audiobook/internal/synthcache"
+	"github.com/pixellini/go-audiobook/internal/textutils"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// silenceSampleRate is used for silence when no clip has been synthesized yet to take the format from.
+const silenceSampleRate = 22050
+
+// clipFormat is the sample rate and channel count of the engine's clips.
+// Chapters are joined without re-encoding, so audio from fallback steps has to match it.
+type clipFormat struct {
+	mu       sync.Mutex
+	rate     int
+	channels int
+}
+
+// note records the format of the clip at path, unless it's already known.
+func (f *clipFormat) note(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rate != 0 {
+		return
+	}
+
+	audio, err := wav.ReadFile(path)
+	if err != nil {
+		return
+	}
+	f.rate, f.channels = audio.SampleRate, audio.Channels
+}
+
+func (f *clipFormat) get() (rate, channels int, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rate, f.channels, f.rate != 0
+}
+
+// fallback runs the configured fallback steps for a chunk the engine failed on,
+// and returns the audio from the first step that works. cause is returned if none do.
+func (app *Application) fallback(ctx context.Context, w *chapterWork, i int, key, text string, cause error) (string, error) {
+	chapterNumber := w.number
+	output := filepath.Join(app.cacheDir, fmt.Sprintf("fallback-%d-%d.wav", chapterNumber, i))
+
+	for _, step := range app.config.Fallback.Steps {
+		var (
+			path string
+			err  error
+		)
+		switch step {
+		case config.FallbackSplit:
+			path, err = app.fallbackSplit(ctx, w, text, output)
+		case config.FallbackSecondary:
+			path, err = app.fallbackSecondary(ctx, w, text, output)
+		case config.FallbackSilence:
+			path, err = app.fallbackSilence(ctx, w, output)
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err != nil {
+			app.logger.Printf("Fallback %q failed for chapter %d part %d: %v", step, chapterNumber, i+1, err)
+			continue
+		}
+
+		if err := app.job.FallbackChunk(chapterNumber, i, key, step, cause); err != nil {
+			return "", err
+		}
+		app.report.AddFallback(report.Fallback{
+			Chapter: chapterNumber,
+			Part:    i + 1,
+			Key:     key,
+			Text:    text,
+			Step:    step,
+			Error:   cause.Error(),
+		})
+		app.logger.Printf("Chapter %d part %d used fallback %q: %v", chapterNumber, i+1, step, cause)
+
+		return path, nil
+	}
+
+	return "", cause
+}
+
+// fallbackSplit synthesizes the text one clause at a time with the chapter's engine.
+func (app *Application) fallbackSplit(ctx context.Context, w *chapterWork, text, output string) (string, error) {
+	clauses := textutils.SplitClauses(text)
+	if len(clauses) < 2 {
+		return "", fmt.Errorf("text can't be split any further")
+	}
+
+	files := make([]string, 0, len(clauses))
+	for _, clause := range clauses {
+		path, err := app.synthesizeCached(ctx, w.engine.tts, w.fingerprint, clause)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, path)
+	}
+
+	if err := app.audio.CombineFiles(ctx, files, output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// fallbackSecondary synthesizes the text with the secondary engine.
+func (app *Application) fallbackSecondary(ctx context.Context, w *chapterWork, text, output string) (string, error) {
+	if app.secondary == nil {
+		return "", fmt.Errorf("no secondary engine configured")
+	}
+
+	// The prosody in ctx is what the main engine can do, the secondary one speaks at its normal rate.
+	ctx = ttsservice.WithProsody(ctx, config.Prosody{})
+	path, err := app.synthesizeCached(ctx, app.secondary, app.secondaryFingerprint, text)
+	if err != nil {
+		return "", err
+	}
+
+	rate, channels, ok := w.engine.format.get()
+	if !ok {
+		// Nothing to match yet
+		return path, nil
+	}
+
+	if err := app.audio.Resample(ctx, path, output, rate, channels); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// fallbackSilence writes a short silence in place of the chunk.
+func (app *Application) fallbackSilence(ctx context.Context, w *chapterWork, output string) (string, error) {
+	rate, channels, ok := w.engine.format.get()
+	if !ok {
+		rate, channels = silenceSampleRate, 1
+	}
+
+	samples := make([]int16, int(app.config.Fallback.Silence.Seconds()*float64(rate)))
+	if channels == 1 {
+		return output, wav.WriteFile(output, rate, samples)
+	}
+
+	mono := output + ".mono.wav"
+	if err := wav.WriteFile(mono, rate, samples); err != nil {
+		return "", err
+	}
+	defer app.fileManager.Remove(mono)
+
+	if err := app.audio.Resample(ctx, mono, output, rate, channels); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// synthesizeCached returns the audio for text from the cache, synthesizing it with tts first if needed.
+func (app *Application) synthesizeCached(ctx context.Context, tts ttsservice.TTSservice, fingerprint, text string) (string, error) {
+	key := synthcache.Key(text, fingerprint)
+	if path, ok := app.audioCache.Lookup(key); ok {
+		return path, nil
+	}
+
+	name := app.audioCache.TempName(key)
+	if _, err := tts.SynthesizeContext(ctx, text, name); err != nil {
+		app.audioCache.Discard(name)
+		return "", err
+	}
+
+	return app.audioCache.Commit(key, name)
+}
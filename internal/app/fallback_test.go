@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epubreader"
+	"github.com/pixellini/go-audiobook/internal/job"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// failingTTS fails on the texts in fail and passes everything else on.
+type failingTTS struct {
+	ttsservice.TTSservice
+	fail []string
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *failingTTS) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, text)
+	f.mu.Unlock()
+
+	if slices.Contains(f.fail, text) {
+		return nil, errors.New("engine crashed")
+	}
+	return f.TTSservice.SynthesizeContext(ctx, text, output)
+}
+
+var fallbackChapters = []*epubreader.EpubReaderChapter{
+	testChapters[0],
+	{Id: "ch01", Title: "Trouble", Content: "<p>Well, this is awkward; it keeps failing.</p><p>Fine.</p>"},
+}
+
+const troubleText = "Well, this is awkward; it keeps failing."
+
+// fallbackApp returns a test app whose engine fails on the chapter's first paragraph, and on anything else in fail.
+func fallbackApp(t *testing.T, root string, steps []string, fail ...string) (*Application, *failingTTS) {
+	t.Helper()
+
+	app, _ := testApp(t, root, "test-book")
+	app.config.Fallback = config.Fallback{Steps: steps, Silence: 1500 * time.Millisecond}
+	tts := &failingTTS{TTSservice: app.mainEngine().tts, fail: append([]string{troubleText}, fail...)}
+	app.mainEngine().tts = tts
+	return app, tts
+}
+
+func TestFallbackSplit(t *testing.T) {
+	app, tts := fallbackApp(t, t.TempDir(), []string{config.FallbackSplit, config.FallbackSilence})
+
+	chapters, err := app.ProcessChapters(context.Background(), fallbackChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, clause := range []string{"Well,", "this is awkward;", "it keeps failing."} {
+		if !slices.Contains(tts.calls, clause) {
+			t.Errorf("%q was never synthesized, calls were %q", clause, tts.calls)
+		}
+	}
+
+	want := clipSeconds("Chapter 1: Trouble") + clipSeconds("Well,") + clipSeconds("this is awkward;") + clipSeconds("it keeps failing.") + clipSeconds("Fine.")
+	if got := wavSeconds(t, chapters[1].Path); math.Abs(got-want) > 0.001 {
+		t.Errorf("chapter lasts %.3fs, want %.3fs", got, want)
+	}
+
+	assertFallback(t, app, config.FallbackSplit)
+}
+
+func TestFallbackSilence(t *testing.T) {
+	// The clauses fail too, so only silence is left
+	app, _ := fallbackApp(t, t.TempDir(), []string{config.FallbackSplit, config.FallbackSilence}, "Well,")
+
+	chapters, err := app.ProcessChapters(context.Background(), fallbackChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := clipSeconds("Chapter 1: Trouble") + 1.5 + clipSeconds("Fine.")
+	if got := wavSeconds(t, chapters[1].Path); math.Abs(got-want) > 0.001 {
+		t.Errorf("chapter lasts %.3fs, want %.3fs", got, want)
+	}
+
+	assertFallback(t, app, config.FallbackSilence)
+}
+
+func TestFallbackSilenceFormat(t *testing.T) {
+	app, _ := fallbackApp(t, t.TempDir(), []string{config.FallbackSilence})
+
+	// Each engine's silence matches its own clips
+	narrow := &engine{}
+	narrow.format.rate, narrow.format.channels = 16000, 1
+	for _, tt := range []struct {
+		engine *engine
+		want   int
+	}{
+		{narrow, 16000},
+		{&engine{}, silenceSampleRate},
+	} {
+		path, err := app.fallbackSilence(context.Background(), &chapterWork{engine: tt.engine}, filepath.Join(t.TempDir(), "silence.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		audio, err := wav.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if audio.SampleRate != tt.want {
+			t.Errorf("silence is %d Hz, want %d", audio.SampleRate, tt.want)
+		}
+	}
+}
+
+func TestFallbackSecondary(t *testing.T) {
+	app, _ := fallbackApp(t, t.TempDir(), []string{config.FallbackSecondary})
+
+	sc := *app.config
+	sc.Test.Signal = ttsservice.SignalSilence
+	secondary, err := ttsservice.New(&sc, app.audioCache.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+	app.secondary = secondary
+	app.secondaryFingerprint = ttsservice.Fingerprint(&sc)
+
+	chapters, err := app.ProcessChapters(context.Background(), fallbackChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := clipSeconds("Chapter 1: Trouble") + clipSeconds(troubleText) + clipSeconds("Fine.")
+	if got := wavSeconds(t, chapters[1].Path); math.Abs(got-want) > 0.001 {
+		t.Errorf("chapter lasts %.3fs, want %.3fs", got, want)
+	}
+
+	assertFallback(t, app, config.FallbackSecondary)
+}
+
+func TestFallbackNoneWork(t *testing.T) {
+	// Without a secondary engine and with nothing to split, the run stops
+	app, tts := fallbackApp(t, t.TempDir(), []string{config.FallbackSecondary})
+	tts.fail = []string{"Fine."}
+
+	_, err := app.ProcessChapters(context.Background(), fallbackChapters, testBook)
+	if err == nil {
+		t.Fatal("expected an error when every fallback fails")
+	}
+	if n := app.job.Fallbacks(); n != 0 {
+		t.Errorf("%d chunks fell back, want none", n)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	root := t.TempDir()
+	app, _ := fallbackApp(t, root, []string{config.FallbackSilence})
+	if _, err := app.ProcessChapters(context.Background(), fallbackChapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+	app.job.Close()
+
+	// The engine works now, and the patch run synthesizes only the chunk that fell back
+	app, tts := testApp(t, root, "test-book")
+	n, err := app.job.Patch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("patching %d chunks, want 1", n)
+	}
+
+	chapters, err := app.ProcessChapters(context.Background(), fallbackChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := tts.calls.Load(); n != 1 {
+		t.Errorf("patch run synthesized %d chunks, want 1", n)
+	}
+	if n := app.job.Fallbacks(); n != 0 {
+		t.Errorf("%d chunks still fell back after patching", n)
+	}
+
+	want := clipSeconds("Chapter 1: Trouble") + clipSeconds(troubleText) + clipSeconds("Fine.")
+	if got := wavSeconds(t, chapters[1].Path); math.Abs(got-want) > 0.001 {
+		t.Errorf("patched chapter lasts %.3fs, want %.3fs", got, want)
+	}
+}
+
+func assertFallback(t *testing.T, app *Application, step string) {
+	t.Helper()
+
+	if len(app.report.Fallbacks) != 1 {
+		t.Fatalf("report has %d fallbacks, want 1", len(app.report.Fallbacks))
+	}
+	f := app.report.Fallbacks[0]
+	if f.Chapter != 1 || f.Part != 2 || f.Text != troubleText || f.Step != step || f.Error != "engine crashed" {
+		t.Errorf("fallback = %+v, want chapter 1 part 2 by %s", f, step)
+	}
+
+	chunk := app.job.Manifest().Chapters[1].Chunks[1]
+	if chunk.State != job.StateFallback || chunk.Fallback != step {
+		t.Errorf("job chunk = %+v, want a %s fallback", chunk, step)
+	}
+	if app.job.Fallbacks() != 1 {
+		t.Errorf("job has %d fallbacks, want 1", app.job.Fallbacks())
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pixellini/go-audiobook/internal/epub"
+)
+
+// checkFilters makes sure ffmpeg can run the book's filter chain and every voice's,
+// so a mistake in the config stops the run before anything is synthesized.
+func (app *Application) checkFilters(ctx context.Context) error {
+	if err := app.audio.CheckFilters(ctx, app.config.Filters); err != nil {
+		return err
+	}
+	for _, v := range app.config.Voices {
+		if len(v.Filters) == 0 {
+			continue
+		}
+		if err := app.audio.CheckFilters(ctx, app.config.VoiceFilters(v.Name)); err != nil {
+			return fmt.Errorf("voice %q: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// ApplyFilters runs every chapter through the filters for its voice. The filtered audio is written to the
+// work directory and the chapters point to it, the chapter files in the job are left as they were synthesized.
+func (app *Application) ApplyFilters(ctx context.Context, chapters []*epub.EpubChapter) error {
+	dir := filepath.Join(app.cacheDir, "filters")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	paths := make([]string, len(chapters))
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(runtime.NumCPU())
+	for i, chapter := range chapters {
+		paths[i] = chapter.Path
+
+		filters := app.config.VoiceFilters(chapter.Voice)
+		if len(filters) == 0 {
+			continue
+		}
+
+		chain := make([]string, len(filters))
+		for j, f := range filters {
+			chain[j] = f.String()
+		}
+
+		eg.Go(func() error {
+			output := filepath.Join(dir, fmt.Sprintf("chapter-%04d.wav", i+1))
+			if err := app.audio.ApplyFilters(ctx, chapter.Path, output, chain); err != nil {
+				return fmt.Errorf("failed to filter chapter %s: %w", chapter.Title, err)
+			}
+			paths[i] = output
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	for i, chapter := range chapters {
+		chapter.Path = paths[i]
+	}
+	return nil
+}
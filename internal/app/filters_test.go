@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// filterAudio records the filter chains it checks and applies, and copies the audio as it is.
+type filterAudio struct {
+	testAudio
+	invalid string
+
+	mu      sync.Mutex
+	checked [][]config.Filter
+	applied map[string][]string
+}
+
+func (a *filterAudio) CheckFilters(ctx context.Context, filters []config.Filter) error {
+	a.checked = append(a.checked, filters)
+	for _, f := range filters {
+		if f.Name == a.invalid {
+			return errors.New("no such filter")
+		}
+	}
+	return nil
+}
+
+func (a *filterAudio) ApplyFilters(ctx context.Context, inputFile, outputFile string, filters []string) error {
+	a.mu.Lock()
+	a.applied[filepath.Base(outputFile)] = filters
+	a.mu.Unlock()
+	return a.testAudio.ApplyFilters(ctx, inputFile, outputFile, filters)
+}
+
+func filterConfig() *config.Config {
+	c := &config.Config{}
+	c.Filters = []config.Filter{{Name: "highpass", Params: map[string]any{"f": 80}}}
+	c.Voices = []config.Voice{
+		{Name: "p225"},
+		{Name: "p226", Filters: []config.Filter{{Name: "acompressor"}}},
+	}
+	return c
+}
+
+func TestCheckFilters(t *testing.T) {
+	audio := &filterAudio{}
+	app := &Application{config: filterConfig(), audio: audio}
+
+	if err := app.checkFilters(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The book's chain, and p226's since it adds to it
+	var chains []string
+	for _, filters := range audio.checked {
+		var names []string
+		for _, f := range filters {
+			names = append(names, f.Name)
+		}
+		chains = append(chains, strings.Join(names, ","))
+	}
+	if !slices.Equal(chains, []string{"highpass", "highpass,acompressor"}) {
+		t.Errorf("checked %q", chains)
+	}
+
+	audio.invalid = "acompressor"
+	if err := app.checkFilters(context.Background()); err == nil || !strings.HasPrefix(err.Error(), `voice "p226": `) {
+		t.Errorf("err = %v, want the voice named", err)
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	audio := &filterAudio{applied: map[string][]string{}}
+	app := &Application{config: filterConfig(), audio: audio, cacheDir: t.TempDir()}
+
+	chapters := splitChapters(t, "One", "Two")
+	chapters[0].Voice = "p225"
+	chapters[1].Voice = "p226"
+	original := chapters[0].Path
+
+	if err := app.ApplyFilters(context.Background(), chapters); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"chapter-0001.wav": {"highpass=f=80"},
+		"chapter-0002.wav": {"highpass=f=80", "acompressor"},
+	}
+	for file, chain := range want {
+		if !slices.Equal(audio.applied[file], chain) {
+			t.Errorf("%s filtered with %q, want %q", file, audio.applied[file], chain)
+		}
+	}
+	if chapters[0].Path != filepath.Join(app.cacheDir, "filters", "chapter-0001.wav") || chapters[0].Path == original {
+		t.Errorf("chapter 1 is at %s, want the filtered copy", chapters[0].Path)
+	}
+}
+
+func TestApplyFiltersNone(t *testing.T) {
+	audio := &filterAudio{applied: map[string][]string{}}
+	app := &Application{config: &config.Config{}, audio: audio, cacheDir: t.TempDir()}
+
+	chapters := splitChapters(t, "One")
+	path := chapters[0].Path
+	if err := app.ApplyFilters(context.Background(), chapters); err != nil {
+		t.Fatal(err)
+	}
+	if chapters[0].Path != path || len(audio.applied) != 0 {
+		t.Error("a chapter without filters was filtered")
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// joinAudio records the clips it's asked to join.
+type joinAudio struct {
+	testAudio
+	clips    []audioservice.Clip
+	combined []string
+}
+
+func (a *joinAudio) JoinClips(ctx context.Context, clips []audioservice.Clip, outputFile string, joins config.Joins) error {
+	a.clips = clips
+	return nil
+}
+
+func (a *joinAudio) CombineFiles(ctx context.Context, inputFiles []string, outputFile string) error {
+	a.combined = inputFiles
+	return nil
+}
+
+func TestJoinClipsPauses(t *testing.T) {
+	c := &config.Config{}
+	c.Joins = config.Joins{Enabled: true, TitlePause: time.Second, ParagraphPause: 600 * time.Millisecond, ChapterPause: 2 * time.Second}
+	audio := &joinAudio{}
+	app := &Application{config: c, audio: audio}
+
+	// The second paragraph had nothing to say
+	w := &chapterWork{files: []string{"title.wav", "", "one.wav", "two.wav"}}
+	if err := app.joinClips(context.Background(), w, "chapter.wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []audioservice.Clip{
+		{Path: "title.wav", Pause: time.Second},
+		{Path: "one.wav", Pause: 600 * time.Millisecond},
+		{Path: "two.wav", Pause: 2 * time.Second},
+	}
+	if !slices.Equal(audio.clips, want) {
+		t.Errorf("clips = %+v, want %+v", audio.clips, want)
+	}
+
+	// Disabled, the clips are joined as they are
+	c.Joins.Enabled = false
+	if err := app.joinClips(context.Background(), w, "chapter.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(audio.combined, []string{"title.wav", "one.wav", "two.wav"}) {
+		t.Errorf("combined %q", audio.combined)
+	}
+}
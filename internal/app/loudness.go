@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/report"
+)
+
+// NormalizeLoudness brings every chapter to the same loudness with two-pass EBU R128 normalization,
+// so the volume doesn't jump between chapters or voices. The normalized audio is written to the work
+// directory and the chapters point to it, the chapter files in the job are left as they were synthesized.
+func (app *Application) NormalizeLoudness(ctx context.Context, chapters []*epub.EpubChapter) error {
+	target := app.config.Loudness
+	dir := filepath.Join(app.cacheDir, "loudness")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	results := make([]report.ChapterLoudness, len(chapters))
+	paths := make([]string, len(chapters))
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(runtime.NumCPU())
+	for i, chapter := range chapters {
+		eg.Go(func() error {
+			results[i] = report.ChapterLoudness{Chapter: i + 1, Title: chapter.Title}
+			paths[i] = chapter.Path
+
+			measured, err := app.audio.MeasureLoudness(ctx, chapter.Path, target)
+			if err != nil {
+				return fmt.Errorf("failed to measure chapter %s: %w", chapter.Title, err)
+			}
+			// There is nothing to bring up in a silent chapter
+			if math.IsInf(measured.Integrated, 0) || math.IsInf(measured.TruePeak, 0) {
+				app.logger.Printf("Chapter %s is silent, leaving its loudness as it is", chapter.Title)
+				return nil
+			}
+
+			output := filepath.Join(dir, fmt.Sprintf("chapter-%04d.wav", i+1))
+			normalized, err := app.audio.NormalizeLoudness(ctx, chapter.Path, output, measured, target)
+			if err != nil {
+				return fmt.Errorf("failed to normalize chapter %s: %w", chapter.Title, err)
+			}
+
+			results[i].Measured = level(measured)
+			results[i].Output = level(normalized)
+			results[i].Normalization = normalized.Normalization
+			paths[i] = output
+
+			if normalized.Normalization != "linear" {
+				app.logger.Printf("Chapter %s normalized with %s gain, it's too loud at its peaks for %g LUFS", chapter.Title, normalized.Normalization, target.Target)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	for i, chapter := range chapters {
+		chapter.Path = paths[i]
+	}
+
+	app.report.SetLoudness(report.Loudness{
+		Target:   target.Target,
+		TruePeak: target.TruePeak,
+		Range:    target.Range,
+		Chapters: results,
+	})
+	return nil
+}
+
+func level(l audioservice.Loudness) *report.Level {
+	return &report.Level{
+		Integrated: l.Integrated,
+		TruePeak:   l.TruePeak,
+		Range:      l.Range,
+	}
+}
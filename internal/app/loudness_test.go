@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/logger"
+	"github.com/pixellini/go-audiobook/internal/report"
+	"github.com/pixellini/go-audiobook/internal/tui"
+)
+
+// loudnessAudio measures each file as the level it was given, and normalizes by copying.
+// Chapters louder than -10 LUFS need dynamic normalization.
+type loudnessAudio struct {
+	testAudio
+	levels map[string]float64
+}
+
+func (a loudnessAudio) MeasureLoudness(ctx context.Context, inputFile string, target config.Loudness) (audioservice.Loudness, error) {
+	level, ok := a.levels[inputFile]
+	if !ok {
+		return audioservice.Loudness{}, os.ErrNotExist
+	}
+	return audioservice.Loudness{Integrated: level, TruePeak: level + 10, Range: 5}, nil
+}
+
+func (a loudnessAudio) NormalizeLoudness(ctx context.Context, inputFile, outputFile string, measured audioservice.Loudness, target config.Loudness) (audioservice.Loudness, error) {
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return audioservice.Loudness{}, err
+	}
+	if err := os.WriteFile(outputFile, data, 0644); err != nil {
+		return audioservice.Loudness{}, err
+	}
+
+	normalization := "linear"
+	if measured.Integrated > -10 {
+		normalization = "dynamic"
+	}
+	return audioservice.Loudness{Integrated: target.Target, TruePeak: target.TruePeak, Range: 5, Normalization: normalization}, nil
+}
+
+func TestNormalizeLoudness(t *testing.T) {
+	chapters := splitChapters(t, "Quiet", "Silent", "Loud")
+	original := []string{chapters[0].Path, chapters[1].Path, chapters[2].Path}
+
+	c := &config.Config{}
+	c.Loudness = config.Loudness{Enabled: true, Target: -18, TruePeak: -3, Range: 11}
+	app := &Application{
+		config:   c,
+		audio:    loudnessAudio{levels: map[string]float64{original[0]: -30, original[1]: math.Inf(-1), original[2]: -8}},
+		tui:      tui.NewEmpty(),
+		logger:   logger.NewSilentLogger(),
+		report:   report.New("Book"),
+		cacheDir: t.TempDir(),
+	}
+
+	if err := app.NormalizeLoudness(context.Background(), chapters); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(app.cacheDir, "loudness")
+	want := []string{filepath.Join(dir, "chapter-0001.wav"), original[1], filepath.Join(dir, "chapter-0003.wav")}
+	for i, chapter := range chapters {
+		if chapter.Path != want[i] {
+			t.Errorf("chapter %d is at %s, want %s", i+1, chapter.Path, want[i])
+		}
+	}
+	// The synthesized chapters are kept for the next run
+	for _, path := range original {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+
+	l := app.report.Loudness
+	if l == nil || l.Target != -18 || len(l.Chapters) != 3 {
+		t.Fatalf("report loudness = %+v, want all three chapters against -18 LUFS", l)
+	}
+	if quiet := l.Chapters[0]; quiet.Measured.Integrated != -30 || quiet.Output.Integrated != -18 || quiet.Normalization != "linear" {
+		t.Errorf("quiet chapter = %+v", quiet)
+	}
+	if silent := l.Chapters[1]; silent.Title != "Silent" || silent.Measured != nil || silent.Normalization != "" {
+		t.Errorf("silent chapter = %+v, want it left alone", silent)
+	}
+	if loud := l.Chapters[2]; loud.Chapter != 3 || loud.Normalization != "dynamic" {
+		t.Errorf("loud chapter = %+v, want dynamic normalization", loud)
+	}
+}
+
+func TestNormalizeLoudnessError(t *testing.T) {
+	chapters := splitChapters(t, "One")
+	path := chapters[0].Path
+
+	app := &Application{
+		config:   &config.Config{},
+		audio:    loudnessAudio{},
+		logger:   logger.NewSilentLogger(),
+		report:   report.New("Book"),
+		cacheDir: t.TempDir(),
+	}
+
+	err := app.NormalizeLoudness(context.Background(), chapters)
+	if err == nil || !strings.Contains(err.Error(), "failed to measure chapter One") {
+		t.Errorf("err = %v, want the measurement to fail", err)
+	}
+	if chapters[0].Path != path || app.report.Loudness != nil {
+		t.Error("chapters were changed after a failure")
+	}
+}
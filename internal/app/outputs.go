@@ -0,0 +1,148 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/metadata"
+	"github.com/pixellini/go-audiobook/internal/report"
+)
+
+// resolveOutputs fills in every target's file name from its template and the book.
+func resolveOutputs(outputs []config.Output, book *epub.EpubMetadata) ([]config.Output, error) {
+	resolved := make([]config.Output, len(outputs))
+	seen := map[string]bool{}
+
+	for i, o := range outputs {
+		if o.Filename == "" {
+			o.Filename = sanitizeFileName(book.Title)
+		} else {
+			tmpl, err := template.New("filename").Parse(o.Filename)
+			if err != nil {
+				return nil, fmt.Errorf("invalid output filename: %w", err)
+			}
+
+			var name bytes.Buffer
+			err = tmpl.Execute(&name, trackName{
+				Book:   sanitizeFileName(book.Title),
+				Author: sanitizeFileName(book.Author),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to name output %d: %w", i+1, err)
+			}
+			o.Filename = name.String()
+		}
+
+		if seen[o.FullPath()] {
+			return nil, fmt.Errorf("more than one output is written to %s", o.FullPath())
+		}
+		seen[o.FullPath()] = true
+		resolved[i] = o
+	}
+
+	return resolved, nil
+}
+
+// createOutputs encodes the chapters into every output at once. Each output succeeds or fails on its own,
+// and is recorded in the report either way. The error lists every output that failed.
+func (app *Application) createOutputs(ctx context.Context, book *epub.EpubMetadata, chapters []*epub.EpubChapter, outputs []config.Output) error {
+	// Outputs with chapter markers share the joined audio, and the metadata if they take the same tags
+	var audiobook string
+	metadataPaths := map[bool]string{}
+	for _, o := range outputs {
+		album := metadata.AlbumTags(o.Format)
+		if _, ok := metadataPaths[album]; o.Split || ok {
+			continue
+		}
+
+		m, err := app.BuildMetadataFile(ctx, book, chapters, o.Format)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata: %w", err)
+		}
+		defer os.Remove(m.Name())
+
+		// Close the metadata file to ensure all data is written before FFmpeg uses it
+		if err := m.Close(); err != nil {
+			return fmt.Errorf("failed to close metadata file: %w", err)
+		}
+		metadataPaths[album] = m.Name()
+	}
+
+	if slices.ContainsFunc(outputs, func(o config.Output) bool { return !o.Split }) {
+		// Turn all the chapter wav files into a singular wav file.
+		app.tui.UpdateProgress("Combining all chapters into audiobook...")
+		audiobook = filepath.Join(app.cacheDir, "audiobook.wav")
+		if err := app.CombineChapters(ctx, chapters, audiobook); err != nil {
+			return fmt.Errorf("failed to combine chapter files: %w", err)
+		}
+		defer os.Remove(audiobook)
+	}
+
+	app.tui.UpdateProgress(fmt.Sprintf("Creating %d audiobook files...", len(outputs)))
+
+	errs := make([]error, len(outputs))
+	var wg sync.WaitGroup
+	for i, o := range outputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			var err error
+			if o.Split {
+				err = app.CreateSplit(ctx, book, chapters, o)
+			} else {
+				err = app.createAudiobook(ctx, audiobook, metadataPaths[metadata.AlbumTags(o.Format)], o)
+			}
+
+			result := report.Output{
+				Path:    o.FullPath(),
+				Format:  o.Format,
+				Split:   o.Split,
+				Elapsed: time.Since(start).Truncate(time.Millisecond),
+			}
+			if err != nil {
+				result.Error = err.Error()
+				errs[i] = fmt.Errorf("failed to create %s: %w", o.FullPath(), err)
+				app.logger.Printf("Failed to create %s: %v", o.FullPath(), err)
+			} else {
+				app.logger.Printf("Created %s in %v", o.FullPath(), result.Elapsed)
+			}
+			app.report.AddOutput(result)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// createAudiobook encodes the joined chapters into a single file with chapter markers.
+func (app *Application) createAudiobook(ctx context.Context, audiobook, metadataPath string, o config.Output) error {
+	app.fileManager.Create(filepath.Dir(o.FullPath()))
+
+	err := app.audio.CreateAudiobook(
+		ctx,
+		audiobook,
+		app.config.Epub.CoverImage,
+		metadataPath,
+		o.FullPath(),
+		o.Encoder,
+	)
+	if err != nil {
+		// Don't leave a partial file, it would be skipped as done by the next run
+		os.Remove(o.FullPath())
+		return err
+	}
+
+	return nil
+}
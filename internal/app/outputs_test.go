@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/filemanager"
+	"github.com/pixellini/go-audiobook/internal/report"
+)
+
+func TestResolveOutputs(t *testing.T) {
+	outputs, err := resolveOutputs([]config.Output{
+		{Path: "out/", Format: config.FormatM4B},
+		{Path: "out/", Format: config.FormatMP3, Filename: "{{.Author}} - {{.Book}}"},
+		{Path: "out/", Format: config.FormatMP3, Split: true},
+	}, splitBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, o := range outputs {
+		got = append(got, o.FullPath())
+	}
+	want := []string{"out/The Book.m4b", "out/A. Writer - The Book.mp3", "out/The Book"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("outputs = %q, want %q", got, want)
+	}
+}
+
+func TestResolveOutputsSamePath(t *testing.T) {
+	_, err := resolveOutputs([]config.Output{
+		{Path: "out/", Format: config.FormatMP3},
+		{Path: "out/", Format: config.FormatMP3, Filename: "{{.Book}}"},
+	}, splitBook)
+	if err == nil || !strings.Contains(err.Error(), "more than one output is written to out/The Book.mp3") {
+		t.Errorf("err = %v, want the clash reported", err)
+	}
+}
+
+func TestCreateOutputs(t *testing.T) {
+	app, audio := splitApp(t)
+	app.fileManager = filemanager.New()
+	app.report = report.New(splitBook.Title)
+	audio.fail = config.FormatOpus
+
+	dir := t.TempDir() + "/"
+	outputs := []config.Output{
+		{Path: dir, Filename: "book", Format: config.FormatM4B},
+		{Path: dir, Filename: "book", Format: config.FormatOpus},
+		{Path: dir, Filename: "book", Format: config.FormatFLAC},
+		{Path: dir, Filename: "tracks", Format: config.FormatMP3, Split: true, Template: "{{.Track}}"},
+	}
+
+	err := app.createOutputs(context.Background(), splitBook, splitChapters(t, "One", "Two"), outputs)
+	if err == nil || !strings.Contains(err.Error(), "failed to create "+dir+"book.opus") || strings.Contains(err.Error(), "m4b") {
+		t.Errorf("err = %v, want only the opus output to fail", err)
+	}
+
+	for _, file := range []string{"book.m4b", "book.flac", "tracks/01.mp3", "tracks/02.mp3"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "book.opus")); !os.IsNotExist(err) {
+		t.Errorf("failed output was left behind: %v", err)
+	}
+
+	// The outputs with chapter markers get the whole book's metadata
+	if tags := audio.tags["book.m4b"]; !strings.Contains(tags, "title=One\n") || !strings.Contains(tags, "title=Two\n") {
+		t.Errorf("m4b tags = %q, want both chapters", tags)
+	}
+	// Only music formats are tagged as an album
+	if tags := audio.tags["book.m4b"]; strings.Contains(tags, "album=") || strings.Contains(tags, "genre=") {
+		t.Errorf("m4b tags = %q, want no album or genre", tags)
+	}
+	if tags := audio.tags["book.flac"]; !strings.Contains(tags, "album=") || !strings.Contains(tags, "genre=Audiobook\n") {
+		t.Errorf("flac tags = %q, want the album and genre", tags)
+	}
+
+	app.report.Finish("failed")
+	if len(app.report.Outputs) != 4 {
+		t.Fatalf("report has %d outputs, want 4", len(app.report.Outputs))
+	}
+	for _, o := range app.report.Outputs {
+		if failed := o.Error != ""; failed != (o.Format == config.FormatOpus) {
+			t.Errorf("output %s has error %q", o.Path, o.Error)
+		}
+	}
+}
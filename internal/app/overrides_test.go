@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epubreader"
+)
+
+func TestProcessChaptersOverrides(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	app.config.Model.SpeakerIdx = "p225"
+	app.config.Chapters = []config.ChapterOverride{
+		{Title: "^Chapter Two$", SpeakerIdx: "p226"},
+		{Section: "^Part One$", Language: "fr"},
+	}
+
+	chapters := make([]*epubreader.EpubReaderChapter, len(testChapters))
+	for i, ch := range testChapters {
+		c := *ch
+		c.Path = "text/" + ch.Id + ".xhtml"
+		chapters[i] = &c
+	}
+	app.sections = map[string][]string{"text/ch01.xhtml": {"Part One", "The Beginning"}}
+
+	got, err := app.ProcessChapters(context.Background(), chapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test mode reads every voice with the same fake engine, but each chapter keeps its voice
+	want := []string{"p225", "p225", "p226"}
+	manifest := app.job.Manifest()
+	for i, voice := range want {
+		if got[i].Voice != voice {
+			t.Errorf("chapter %d voice = %q, want %q", i, got[i].Voice, voice)
+		}
+		if manifest.Chapters[i].Voice != voice {
+			t.Errorf("job chapter %d voice = %q, want %q", i, manifest.Chapters[i].Voice, voice)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestProcessChaptersProsody(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	two := 2
+	app.config.Chapters = []config.ChapterOverride{{Index: &two, Prosody: config.Prosody{Rate: 2}}}
+
+	chapters, err := app.ProcessChapters(context.Background(), testChapters, testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake engine can't change its speed, so the whole chapter is sped up afterwards
+	want := (clipSeconds("Chapter Two") + clipSeconds("Nothing happened for a while.")) / 2
+	if got := wavSeconds(t, chapters[2].Path); math.Abs(got-want) > 0.001 {
+		t.Errorf("chapter 2 lasts %.3fs, want %.3fs", got, want)
+	}
+
+	want = clipSeconds("Introduction") + clipSeconds("The Test Book by A. Writer")
+	if got := wavSeconds(t, chapters[0].Path); math.Abs(got-want) > 0.001 {
+		t.Errorf("introduction lasts %.3fs, want it unchanged at %.3fs", got, want)
+	}
+}
+
+func TestProsodyFingerprint(t *testing.T) {
+	if got := prosodyFingerprint("engine", config.Prosody{}); got != "engine" {
+		t.Errorf("neutral prosody changed the fingerprint to %q", got)
+	}
+
+	fast := prosodyFingerprint("engine", config.Prosody{Rate: 1.5})
+	if fast == "engine" || fast == prosodyFingerprint("engine", config.Prosody{Rate: 1.25}) {
+		t.Errorf("fingerprint %q doesn't tell the rates apart", fast)
+	}
+}
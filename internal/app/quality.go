@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/pixellini/go-audiobook/internal/audioqa"
+	"github.com/pixellini/go-audiobook/internal/textutils"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+)
+
+// take is a synthesized clip waiting in the cache's temporary directory.
+type take struct {
+	name     string
+	attempts int
+	// analysis is nil when QA is disabled or the clip couldn't be analysed.
+	analysis *audioqa.Analysis
+}
+
+// synthesize renders text into a temporary file in the audio cache.
+// With QA enabled, a clip that looks wrong is synthesized again with a different seed,
+// and on the last attempt sentence by sentence, keeping whichever attempt looked best.
+func (app *Application) synthesize(ctx context.Context, tts ttsservice.TTSservice, key, text string) (take, error) {
+	name, err := app.attempt(ctx, tts, key, text, 0, false)
+	if err != nil {
+		return take{}, err
+	}
+
+	t := take{name: name, attempts: 1}
+	if !app.config.QA.Enabled {
+		return t, nil
+	}
+
+	best, err := app.analyze(ctx, name, text)
+	if err != nil {
+		// The engine may not produce WAV, in which case there's nothing to check.
+		app.logger.Printf("Skipping QA: %v", err)
+		return t, nil
+	}
+
+	retries := app.config.QA.Retries
+	sentences := textutils.SplitSentences(text)
+
+	for i := 1; i <= retries && !best.Ok(); i++ {
+		split := i == retries && len(sentences) > 1
+
+		candidate, err := app.attempt(ctx, tts, key, text, int64(i), split)
+		t.attempts++
+		if err != nil {
+			if ctx.Err() != nil {
+				app.audioCache.Discard(t.name)
+				return take{}, err
+			}
+			app.logger.Printf("Retry %d failed: %v", i, err)
+			continue
+		}
+
+		a, err := app.analyze(ctx, candidate, text)
+		if err != nil || !audioqa.Better(a, best) {
+			app.audioCache.Discard(candidate)
+			continue
+		}
+
+		app.audioCache.Discard(t.name)
+		t.name, best = candidate, a
+	}
+
+	t.analysis = &best
+	return t, nil
+}
+
+// attempt synthesizes text once, either whole or one sentence at a time.
+// A seed of 0 leaves the engine's randomness alone.
+func (app *Application) attempt(ctx context.Context, tts ttsservice.TTSservice, key, text string, seed int64, split bool) (string, error) {
+	name := app.audioCache.TempName(key)
+
+	var err error
+	if split {
+		err = app.synthesizeSentences(ctx, tts, key, text, name)
+	} else {
+		if seed != 0 {
+			ctx = ttsservice.WithSeed(ctx, seed)
+		}
+		_, err = tts.SynthesizeContext(ctx, text, name)
+	}
+
+	if err != nil {
+		app.audioCache.Discard(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// synthesizeSentences synthesizes each sentence on its own and joins them into name.
+// Shorter inputs give the engine less room to ramble or cut off early.
+func (app *Application) synthesizeSentences(ctx context.Context, tts ttsservice.TTSservice, key, text, name string) error {
+	var parts []string
+	defer func() {
+		for _, p := range parts {
+			app.audioCache.Discard(p)
+		}
+	}()
+
+	var files []string
+	for _, sentence := range textutils.SplitSentences(text) {
+		part := app.audioCache.TempName(key)
+		parts = append(parts, part)
+
+		if _, err := tts.SynthesizeContext(ctx, sentence, part); err != nil {
+			return err
+		}
+		files = append(files, filepath.Join(app.audioCache.TempDir(), part))
+	}
+
+	return app.audio.CombineFiles(ctx, files, filepath.Join(app.audioCache.TempDir(), name))
+}
+
+func (app *Application) analyze(ctx context.Context, name, text string) (audioqa.Analysis, error) {
+	// Clips the engine speeds up are expected to be shorter
+	qa := app.config.QA
+	qa.CharsPerSecond *= ttsservice.ProsodyFromContext(ctx).Speed()
+
+	a, err := audioqa.Analyze(filepath.Join(app.audioCache.TempDir(), name), utf8.RuneCountInString(text), qa)
+	if err != nil {
+		return audioqa.Analysis{}, fmt.Errorf("unable to analyse %s: %w", name, err)
+	}
+	return a, nil
+}
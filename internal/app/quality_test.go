@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epubreader"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// mumblingTTS replaces the audio for the texts in bad with silence of the same length.
+// With goodAfter set, each text only comes out wrong that many times.
+type mumblingTTS struct {
+	ttsservice.TTSservice
+	dir       string
+	bad       []string
+	goodAfter int
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *mumblingTTS) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	m.mu.Lock()
+	m.calls = append(m.calls, text)
+	previous := m.count(text) - 1
+	m.mu.Unlock()
+
+	data, err := m.TTSservice.SynthesizeContext(ctx, text, output)
+	if err != nil || !slices.Contains(m.bad, text) || (m.goodAfter > 0 && previous >= m.goodAfter) {
+		return data, err
+	}
+
+	samples := int(max(0.25, float64(utf8.RuneCountInString(text))/testCharsPerSecond) * testSampleRate)
+	return nil, wav.WriteFile(filepath.Join(m.dir, output), testSampleRate, make([]int16, samples))
+}
+
+func (m *mumblingTTS) count(text string) int {
+	n := 0
+	for _, c := range m.calls {
+		if c == text {
+			n++
+		}
+	}
+	return n
+}
+
+// testQA enables QA with limits the fake engine's tone passes.
+func testQA(app *Application) {
+	app.config.QA = config.QA{
+		Enabled:          true,
+		Retries:          2,
+		CharsPerSecond:   testCharsPerSecond,
+		MinDurationRatio: 0.35,
+		MaxDurationRatio: 2.5,
+		MinLevel:         -40,
+		SilenceLevel:     -50,
+		MaxSilence:       2 * time.Second,
+		MaxClipping:      0.001,
+	}
+}
+
+func TestQARetry(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	testQA(app)
+	tts := &mumblingTTS{TTSservice: app.mainEngine().tts, dir: app.audioCache.TempDir(), bad: []string{"It was a bright cold day in April."}, goodAfter: 1}
+	app.mainEngine().tts = tts
+
+	if _, err := app.ProcessChapters(context.Background(), testChapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+
+	if app.report.Retried != 1 || len(app.report.Review) != 0 {
+		t.Errorf("report has %d retried and %d to review, want 1 and 0", app.report.Retried, len(app.report.Review))
+	}
+	if n := tts.count("It was a bright cold day in April."); n != 2 {
+		t.Errorf("the bad chunk was synthesized %d times, want 2", n)
+	}
+	if n := len(tts.calls); n != 8 {
+		t.Errorf("synthesized %d times, want one retry on top of 7 chunks", n)
+	}
+}
+
+func TestQAReview(t *testing.T) {
+	chapters := []*epubreader.EpubReaderChapter{
+		testChapters[0],
+		{Id: "ch01", Title: "Mumbling", Content: "<p>First part of it. Second part of it.</p>"},
+	}
+	bad := []string{"First part of it. Second part of it.", "First part of it.", "Second part of it."}
+
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	testQA(app)
+	tts := &mumblingTTS{TTSservice: app.mainEngine().tts, dir: app.audioCache.TempDir(), bad: bad}
+	app.mainEngine().tts = tts
+
+	if _, err := app.ProcessChapters(context.Background(), chapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(app.report.Review) != 1 {
+		t.Fatalf("report has %d chunks to review, want 1", len(app.report.Review))
+	}
+	r := app.report.Review[0]
+	if r.Text != bad[0] || r.Attempts != 3 || r.Analysis.Ok() {
+		t.Errorf("review = %+v, want the paragraph after 3 attempts with its issues", r)
+	}
+	if _, ok := app.audioCache.Lookup(r.Key); ok {
+		t.Error("the flagged paragraph was cached")
+	}
+
+	// The last attempt goes sentence by sentence
+	for _, sentence := range bad[1:] {
+		if !slices.Contains(tts.calls, sentence) {
+			t.Errorf("%q was never synthesized on its own, calls were %q", sentence, tts.calls)
+		}
+	}
+}
+
+func TestQADisabled(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	tts := &mumblingTTS{TTSservice: app.mainEngine().tts, dir: app.audioCache.TempDir(), bad: []string{"It was a bright cold day in April."}}
+	app.mainEngine().tts = tts
+
+	if _, err := app.ProcessChapters(context.Background(), testChapters, testBook); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without QA every chunk is synthesized once and nothing is flagged
+	if len(tts.calls) != 7 || app.report.Retried != 0 || len(app.report.Review) != 0 {
+		t.Errorf("%d calls, %d retried, %d to review, want 7, 0 and 0", len(tts.calls), app.report.Retried, len(app.report.Review))
+	}
+}
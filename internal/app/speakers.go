@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/speaker"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+)
+
+// engine is a running TTS service for one voice. Chapters that speak with the same voice share it.
+type engine struct {
+	tts ttsservice.TTSservice
+	// fingerprint identifies the engine's audio in the cache.
+	fingerprint string
+	// format is the format of the engine's clips, known once it has made one.
+	format clipFormat
+}
+
+// startTTS starts the main engine, and the secondary one if a fallback step uses it.
+// Engines for chapter overrides are started when their first chapter is reached.
+func (app *Application) startTTS(ctx context.Context) error {
+	app.engines = map[string]*engine{}
+	if _, err := app.engineFor(ctx, app.config); err != nil {
+		return fmt.Errorf("failed to initialize TTS service: %w", err)
+	}
+
+	if slices.Contains(app.config.Fallback.Steps, config.FallbackSecondary) {
+		sc := app.config.SecondaryConfig()
+		if err := app.prepareSpeakers(ctx, &sc.Model, app.job.SpeakerDir()); err != nil {
+			return err
+		}
+
+		var err error
+		app.secondary, err = app.newTTS(ctx, sc)
+		if err != nil {
+			return fmt.Errorf("failed to initialize secondary TTS service: %w", err)
+		}
+		app.secondaryFingerprint = ttsservice.Fingerprint(sc)
+	}
+
+	return nil
+}
+
+// checkVoices checks the voices of the book, of every chapter override and of the secondary engine against their
+// models, so a speaker or language a model doesn't have stops the run before anything is started.
+func (app *Application) checkVoices(ctx context.Context) error {
+	configs := []*config.Config{app.config}
+	for _, o := range app.config.Chapters {
+		configs = append(configs, app.config.WithOverride(o))
+	}
+	if slices.Contains(app.config.Fallback.Steps, config.FallbackSecondary) {
+		configs = append(configs, app.config.SecondaryConfig())
+	}
+
+	for _, c := range configs {
+		if err := ttsservice.CheckConfigVoice(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// engineFor returns the engine for c, preparing its speaker clips and starting it the first time it's needed.
+func (app *Application) engineFor(ctx context.Context, c *config.Config) (*engine, error) {
+	// Engines are looked up by the clips as configured, since preparing them changes their paths.
+	id := ttsservice.Fingerprint(c)
+	if e, ok := app.engines[id]; ok {
+		return e, nil
+	}
+
+	prepared := *c
+	if err := app.prepareSpeakers(ctx, &prepared.Model, app.job.SpeakerDir()); err != nil {
+		return nil, err
+	}
+
+	tts, err := app.newTTS(ctx, &prepared)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &engine{tts: tts, fingerprint: ttsservice.Fingerprint(&prepared)}
+	app.engines[id] = e
+	return e, nil
+}
+
+// newTTS starts the engine for c and checks that it has the configured voice.
+func (app *Application) newTTS(ctx context.Context, c *config.Config) (ttsservice.TTSservice, error) {
+	tts, err := ttsservice.New(c, app.audioCache.TempDir())
+	if err != nil {
+		return nil, err
+	}
+
+	caps, err := tts.Capabilities(ctx)
+	if err != nil {
+		app.logger.Printf("Unable to check the voice against the model: %v", err)
+		return tts, nil
+	}
+
+	if err := ttsservice.CheckVoice(caps, c); err != nil {
+		tts.Close()
+		return nil, err
+	}
+
+	return tts, nil
+}
+
+func (app *Application) closeTTS() {
+	for _, e := range app.engines {
+		e.tts.Close()
+	}
+	if app.secondary != nil {
+		app.secondary.Close()
+	}
+}
+
+// prepareSpeakers checks the model's speaker reference clips, and replaces them with versions prepared in dir if preprocessing is on.
+// Problems are logged, or stop the run in strict mode.
+func (app *Application) prepareSpeakers(ctx context.Context, m *config.Model, dir string) error {
+	sc := app.config.Speaker
+
+	clips := m.SpeakerClips()
+	if len(clips) == 0 {
+		return nil
+	}
+
+	prepared := make([]string, len(clips))
+	for i, clip := range clips {
+		prepared[i] = clip
+		if sc.Preprocess {
+			app.tui.UpdateProgress(fmt.Sprintf("Preparing speaker clip %s...", clip))
+
+			path, err := speaker.Prepare(ctx, app.audio, clip, dir, sc)
+			if err != nil {
+				return err
+			}
+			prepared[i] = path
+		}
+
+		check, err := speaker.Validate(prepared[i], sc)
+		if err == nil && check.Ok() {
+			continue
+		}
+
+		problem := err
+		if problem == nil {
+			problem = fmt.Errorf("speaker clip %s: %s", clip, strings.Join(check.Issues, ", "))
+		}
+		if sc.Strict {
+			return problem
+		}
+		app.logger.Printf("Warning: %v", problem)
+	}
+
+	m.SetSpeakerClips(prepared)
+	return nil
+}
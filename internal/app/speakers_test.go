@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// writeTone writes seconds of a steady tone, which makes a poor speaker clip since it has no pauses.
+func writeTone(t *testing.T, seconds float64) string {
+	t.Helper()
+
+	samples := make([]int16, int(seconds*testSampleRate))
+	for i := range samples {
+		samples[i] = int16(8000 * (i%100 - 50) / 50)
+	}
+	path := filepath.Join(t.TempDir(), "speaker.wav")
+	if err := wav.WriteFile(path, testSampleRate, samples); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func speakerApp(t *testing.T) *Application {
+	t.Helper()
+
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	app.config.Speaker = config.Speaker{
+		MinDuration:   3 * time.Second,
+		MinSampleRate: 16000,
+		SampleRate:    testSampleRate,
+		MinLevel:      -35,
+		MaxNoiseFloor: -45,
+		MaxClipping:   0.001,
+	}
+	return app
+}
+
+func TestPrepareSpeakersWarns(t *testing.T) {
+	app := speakerApp(t)
+	clip := writeTone(t, 1)
+	m := config.Model{SpeakerWav: clip}
+
+	if err := app.prepareSpeakers(context.Background(), &m, app.job.SpeakerDir()); err != nil {
+		t.Fatal(err)
+	}
+	if m.SpeakerWav != clip {
+		t.Errorf("speaker_wav = %s, want the clip as it was", m.SpeakerWav)
+	}
+}
+
+func TestPrepareSpeakersStrict(t *testing.T) {
+	app := speakerApp(t)
+	app.config.Speaker.Strict = true
+	m := config.Model{SpeakerWav: writeTone(t, 1)}
+
+	err := app.prepareSpeakers(context.Background(), &m, app.job.SpeakerDir())
+	if err == nil || !strings.Contains(err.Error(), "at least 3s is needed") || !strings.Contains(err.Error(), "noisy background") {
+		t.Errorf("err = %v, want the clip's issues", err)
+	}
+}
+
+func TestPrepareSpeakersPreprocess(t *testing.T) {
+	app := speakerApp(t)
+	app.config.Speaker.Preprocess = true
+	m := config.Model{SpeakerWav: writeTone(t, 4), SpeakerWavs: []string{writeTone(t, 5)}}
+
+	if err := app.prepareSpeakers(context.Background(), &m, app.job.SpeakerDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	clips := m.SpeakerClips()
+	if len(clips) != 2 {
+		t.Fatalf("got %d clips, want 2", len(clips))
+	}
+	for _, clip := range clips {
+		if filepath.Dir(clip) != app.job.SpeakerDir() {
+			t.Errorf("clip %s wasn't prepared into the job", clip)
+		}
+	}
+	if clips[0] == clips[1] {
+		t.Error("both clips were prepared to the same file")
+	}
+}
+
+func TestPrepareSpeakersIdx(t *testing.T) {
+	// A built-in speaker has nothing to check
+	app := speakerApp(t)
+	app.config.Speaker.Strict = true
+	m := config.Model{SpeakerIdx: "p225"}
+
+	if err := app.prepareSpeakers(context.Background(), &m, app.job.SpeakerDir()); err != nil {
+		t.Fatal(err)
+	}
+}
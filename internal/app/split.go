@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/metadata"
+)
+
+const (
+	playlistFile = "playlist.m3u8"
+	coverFile    = "cover.jpg"
+)
+
+// unsafeFileChars can't be used in file names on at least one common file system.
+var unsafeFileChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]+`)
+
+// trackName is what the output template can use to name a chapter file.
+type trackName struct {
+	Track  string
+	Title  string
+	Book   string
+	Author string
+}
+
+// sanitizeFileName makes s safe to use as part of a file name.
+func sanitizeFileName(s string) string {
+	s = unsafeFileChars.ReplaceAllString(s, " ")
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.Trim(s, ". ")
+}
+
+// CreateSplit writes every chapter to its own file in the output's directory, tagged as a track of the book,
+// along with an M3U8 playlist and the cover as cover.jpg.
+// The files are written next to the directory first, so a run that fails part way doesn't leave an incomplete one behind.
+func (app *Application) CreateSplit(ctx context.Context, book *epub.EpubMetadata, chapters []*epub.EpubChapter, o config.Output) error {
+	dir := o.FullPath()
+	tmpl, err := template.New("output").Parse(o.Template)
+	if err != nil {
+		return fmt.Errorf("invalid output template: %w", err)
+	}
+
+	work := dir + ".partial"
+	if err := os.RemoveAll(work); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(work, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	// Track numbers are at least two digits, and as wide as the last one
+	width := max(2, len(strconv.Itoa(len(chapters))))
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&playlist, "#PLAYLIST:%s\n", book.Title)
+
+	for i, chapter := range chapters {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		track := i + 1
+		var name bytes.Buffer
+		err := tmpl.Execute(&name, trackName{
+			Track:  fmt.Sprintf("%0*d", width, track),
+			Title:  sanitizeFileName(chapter.Title),
+			Book:   sanitizeFileName(book.Title),
+			Author: sanitizeFileName(book.Author),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to name chapter %d: %w", track, err)
+		}
+		file := filepath.Clean(name.String()) + "." + o.Format
+		if !filepath.IsLocal(file) {
+			return fmt.Errorf("chapter %d is named %q, which is outside the output directory", track, file)
+		}
+
+		duration, err := app.audio.GetDuration(ctx, chapter.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get duration for chapter: %s: %w", chapter.Title, err)
+		}
+
+		if err := app.createTrack(ctx, book, chapter, track, len(chapters), filepath.Join(work, file), o.Encoder); err != nil {
+			return fmt.Errorf("failed to create chapter file %s: %w", file, err)
+		}
+
+		fmt.Fprintf(&playlist, "#EXTINF:%d,%s - %s\n%s\n", int(math.Round(duration)), book.Author, chapter.Title, filepath.ToSlash(file))
+	}
+
+	if err := os.WriteFile(filepath.Join(work, playlistFile), []byte(playlist.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write playlist: %w", err)
+	}
+
+	if app.config.Epub.CoverImage != "" {
+		if err := writeCoverJPEG(app.config.Epub.CoverImage, filepath.Join(work, coverFile)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", coverFile, err)
+		}
+	}
+
+	return os.Rename(work, dir)
+}
+
+// createTrack encodes one chapter as a file of its own.
+func (app *Application) createTrack(ctx context.Context, book *epub.EpubMetadata, chapter *epub.EpubChapter, track, tracks int, output string, enc config.Encoder) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+
+	tags, err := metadata.New(app.cacheDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tags.Name())
+
+	if err := tags.AddTrack(book, chapter.Title, track, tracks); err != nil {
+		tags.Close()
+		return err
+	}
+	if err := tags.Close(); err != nil {
+		return err
+	}
+
+	return app.audio.CreateAudiobook(ctx, chapter.Path, app.config.Epub.CoverImage, tags.Name(), output, enc)
+}
+
+// writeCoverJPEG saves the cover image as a JPEG, which is what players look for next to the audio files.
+func writeCoverJPEG(input, output string) error {
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	// JPEG has no transparency, so see-through parts are shown on white
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, flat, &jpeg.Options{Quality: 90}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
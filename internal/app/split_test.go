@@ -0,0 +1,222 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/logger"
+	"github.com/pixellini/go-audiobook/internal/tui"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// splitAudio copies the chapter audio as each file it creates, and keeps the tags it was given.
+// Files in the fail format aren't created.
+type splitAudio struct {
+	testAudio
+	fail string
+
+	mu   sync.Mutex
+	tags map[string]string
+}
+
+func (a *splitAudio) GetDuration(ctx context.Context, file string) (float64, error) {
+	audio, err := wav.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return audio.Duration(), nil
+}
+
+func (a *splitAudio) CreateAudiobook(ctx context.Context, file, image, metadataPath, output string, enc config.Encoder) error {
+	if a.fail != "" && strings.HasSuffix(output, "."+a.fail) {
+		return fmt.Errorf("can't encode %s", a.fail)
+	}
+
+	tags, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.tags[filepath.Base(output)] = string(tags)
+	a.mu.Unlock()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, data, 0644)
+}
+
+func splitApp(t *testing.T) (*Application, *splitAudio) {
+	t.Helper()
+
+	audio := &splitAudio{tags: map[string]string{}}
+	return &Application{
+		config:   &config.Config{},
+		audio:    audio,
+		tui:      tui.NewEmpty(),
+		logger:   logger.NewSilentLogger(),
+		cacheDir: t.TempDir(),
+	}, audio
+}
+
+// splitOutput is a split MP3 target named The Book, in a directory of its own.
+func splitOutput(t *testing.T, template string) config.Output {
+	return config.Output{
+		Path:     t.TempDir() + "/",
+		Filename: "The Book",
+		Format:   config.FormatMP3,
+		Split:    true,
+		Template: template,
+	}
+}
+
+var splitBook = &epub.EpubMetadata{Title: "The Book", Author: "A. Writer"}
+
+func splitChapters(t *testing.T, titles ...string) []*epub.EpubChapter {
+	t.Helper()
+
+	chapters := make([]*epub.EpubChapter, len(titles))
+	for i, title := range titles {
+		chapters[i] = &epub.EpubChapter{Title: title, Path: writeTone(t, 1.4)}
+	}
+	return chapters
+}
+
+func TestCreateSplit(t *testing.T) {
+	app, audio := splitApp(t)
+	o := splitOutput(t, "{{.Track}} - {{.Title}}")
+	dir := o.FullPath()
+
+	chapters := splitChapters(t, "Chapter One", "What? A/B Test")
+	if err := app.CreateSplit(context.Background(), splitBook, chapters, o); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"01 - Chapter One.mp3", "02 - What A B Test.mp3", playlistFile}
+	if !slices.Equal(names, want) {
+		t.Errorf("files = %q, want %q", names, want)
+	}
+	if _, err := os.Stat(dir + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("partial dir was left behind: %v", err)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(dir, playlistFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPlaylist := "#EXTM3U\n#PLAYLIST:The Book\n" +
+		"#EXTINF:1,A. Writer - Chapter One\n01 - Chapter One.mp3\n" +
+		"#EXTINF:1,A. Writer - What? A/B Test\n02 - What A B Test.mp3\n"
+	if string(playlist) != wantPlaylist {
+		t.Errorf("playlist = %q, want %q", playlist, wantPlaylist)
+	}
+
+	tags := audio.tags["02 - What A B Test.mp3"]
+	for _, tag := range []string{"title=What? A/B Test\n", "album=The Book\n", "track=2/2\n"} {
+		if !strings.Contains(tags, tag) {
+			t.Errorf("tags = %q, want %q", tags, tag)
+		}
+	}
+}
+
+func TestCreateSplitTrackWidth(t *testing.T) {
+	app, _ := splitApp(t)
+	o := splitOutput(t, "{{.Author}}/{{.Track}}")
+	dir := o.FullPath()
+
+	titles := make([]string, 100)
+	if err := app.CreateSplit(context.Background(), splitBook, splitChapters(t, titles...), o); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"001.mp3", "100.mp3"} {
+		if _, err := os.Stat(filepath.Join(dir, "A. Writer", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCreateSplitOutsideDir(t *testing.T) {
+	app, _ := splitApp(t)
+	o := splitOutput(t, "../{{.Track}}")
+	dir := o.FullPath()
+
+	err := app.CreateSplit(context.Background(), splitBook, splitChapters(t, "One"), o)
+	if err == nil || !strings.Contains(err.Error(), "outside the output directory") {
+		t.Errorf("err = %v, want the name to be rejected", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("output dir was created: %v", err)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	for in, want := range map[string]string{
+		"Chapter One":          "Chapter One",
+		"What? A/B: Test":      "What A B Test",
+		"  ...Dots and space.": "Dots and space",
+		"Tab\there":            "Tab here",
+	} {
+		if got := sanitizeFileName(in); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteCoverJPEG(t *testing.T) {
+	dir := t.TempDir()
+
+	// Transparent, apart from a red pixel
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(1, 0, color.NRGBA{R: 255, A: 255})
+	input := filepath.Join(dir, "cover.png")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	output := filepath.Join(dir, coverFile)
+	if err := writeCoverJPEG(input, output); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got, err := jpeg.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := got.At(0, 0).RGBA(); r>>8 < 200 || g>>8 < 200 || b>>8 < 200 {
+		t.Errorf("transparent pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+	if r, g, _, _ := got.At(1, 0).RGBA(); r>>8 < 150 || g>>8 > 150 {
+		t.Errorf("red pixel = %v", got.At(1, 0))
+	}
+}
@@ -0,0 +1,250 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+	"github.com/pixellini/go-audiobook/internal/epubreader"
+	"github.com/pixellini/go-audiobook/internal/textutils"
+	"github.com/pixellini/go-audiobook/internal/ttsservice"
+)
+
+// Every preview is converted to this format, so voices from different engines can be joined into one file.
+const (
+	previewSampleRate = 24000
+	previewChannels   = 1
+)
+
+var unsafeLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// PreviewVoice is a voice to audition. Empty fields keep the configured value.
+type PreviewVoice struct {
+	Backend    string
+	SpeakerIdx string
+	SpeakerWav string
+}
+
+// Label names the voice in file names and announcements.
+func (v PreviewVoice) Label() string {
+	var parts []string
+	if v.Backend != "" {
+		parts = append(parts, v.Backend)
+	}
+	switch {
+	case v.SpeakerWav != "":
+		parts = append(parts, strings.TrimSuffix(filepath.Base(v.SpeakerWav), filepath.Ext(v.SpeakerWav)))
+	case v.SpeakerIdx != "":
+		parts = append(parts, v.SpeakerIdx)
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, " ")
+}
+
+// apply returns a copy of c that speaks with the voice.
+func (v PreviewVoice) apply(c config.Config) *config.Config {
+	if v.Backend != "" {
+		c.Model.Backend = v.Backend
+	}
+	switch {
+	case v.SpeakerWav != "":
+		c.Model.SetSpeakerClips([]string{v.SpeakerWav})
+	case v.SpeakerIdx != "":
+		c.Model.SetSpeakerClips(nil)
+		c.Model.SpeakerIdx = v.SpeakerIdx
+		c.HTTP.Voice = v.SpeakerIdx
+	}
+	return &c
+}
+
+type PreviewOptions struct {
+	// Text is the passage to read. If it's empty, Chapter is read from the configured book instead.
+	Text    string
+	Chapter int
+	Voices  []PreviewVoice
+	// OutputDir receives a file per voice and the comparison file.
+	OutputDir string
+	// Announce speaks each voice's label before it in the comparison file.
+	Announce bool
+}
+
+// Preview is what PreviewVoices created.
+type Preview struct {
+	Files      []string
+	Comparison string
+}
+
+// PreviewVoices renders the same text with every voice into its own file, then joins them into one file for comparison.
+func (app *Application) PreviewVoices(ctx context.Context, opts PreviewOptions) (*Preview, error) {
+	if len(opts.Voices) == 0 {
+		return nil, fmt.Errorf("no voices to preview")
+	}
+
+	text, err := app.previewText(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create preview directory: %w", err)
+	}
+
+	work, err := os.MkdirTemp(app.cacheDir, "preview-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	preview := &Preview{}
+	var comparison []string
+
+	// Announcements are read by the configured voice
+	var announcer ttsservice.TTSservice
+	if opts.Announce {
+		announcer, err = ttsservice.New(app.config, app.audioCache.TempDir())
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize TTS service for announcements: %w", err)
+		}
+		defer announcer.Close()
+	}
+	announcerFingerprint := ttsservice.Fingerprint(app.config)
+
+	for i, voice := range opts.Voices {
+		label := voice.Label()
+		app.logger.Printf("Previewing voice %d of %d: %s", i+1, len(opts.Voices), label)
+
+		raw := filepath.Join(work, fmt.Sprintf("voice-%d.wav", i))
+		if err := app.renderVoice(ctx, voice, text, raw); err != nil {
+			return nil, fmt.Errorf("voice %s: %w", label, err)
+		}
+
+		output := filepath.Join(opts.OutputDir, fmt.Sprintf("%02d-%s.wav", i+1, unsafeLabelChars.ReplaceAllString(label, "-")))
+		if err := app.audio.Resample(ctx, raw, output, previewSampleRate, previewChannels); err != nil {
+			return nil, fmt.Errorf("voice %s: %w", label, err)
+		}
+		preview.Files = append(preview.Files, output)
+
+		if announcer != nil {
+			clip, err := app.synthesizeCached(ctx, announcer, announcerFingerprint, fmt.Sprintf("Voice %d. %s.", i+1, label))
+			if err != nil {
+				return nil, fmt.Errorf("failed to announce voice %s: %w", label, err)
+			}
+
+			announcement := filepath.Join(work, fmt.Sprintf("announce-%d.wav", i))
+			if err := app.audio.Resample(ctx, clip, announcement, previewSampleRate, previewChannels); err != nil {
+				return nil, err
+			}
+			comparison = append(comparison, announcement)
+		}
+		comparison = append(comparison, output)
+	}
+
+	preview.Comparison = filepath.Join(opts.OutputDir, "comparison.wav")
+	if err := app.audio.CombineFiles(ctx, comparison, preview.Comparison); err != nil {
+		return nil, fmt.Errorf("failed to create comparison file: %w", err)
+	}
+
+	return preview, nil
+}
+
+// renderVoice reads the paragraphs with the voice into output.
+func (app *Application) renderVoice(ctx context.Context, voice PreviewVoice, paragraphs []string, output string) error {
+	c := voice.apply(*app.config)
+	if err := app.prepareSpeakers(ctx, &c.Model, filepath.Join(app.cacheDir, "speakers")); err != nil {
+		return err
+	}
+
+	tts, err := app.newTTS(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer tts.Close()
+	fingerprint := ttsservice.Fingerprint(c)
+
+	files := make([]string, 0, len(paragraphs))
+	for _, p := range paragraphs {
+		path, err := app.synthesizeCached(ctx, tts, fingerprint, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, path)
+	}
+
+	return app.audio.CombineFiles(ctx, files, output)
+}
+
+// VoiceList is what an engine reports about its voices.
+type VoiceList struct {
+	Backend string `json:"backend"`
+	ttsservice.Capabilities
+}
+
+// ListVoices asks the engine for the configured backend, or the given one, what it supports.
+func (app *Application) ListVoices(ctx context.Context, backend string) (*VoiceList, error) {
+	c := *app.config
+	if backend != "" {
+		c.Model.Backend = backend
+	}
+
+	tts, err := ttsservice.New(&c, app.audioCache.TempDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize TTS service: %w", err)
+	}
+	defer tts.Close()
+
+	caps, err := tts.Capabilities(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list voices: %w", err)
+	}
+
+	list := &VoiceList{Backend: c.Model.Backend, Capabilities: caps}
+	if c.TestMode {
+		list.Backend = "test"
+	}
+	return list, nil
+}
+
+// previewText returns the paragraphs to read, from the options or from the book.
+func (app *Application) previewText(opts PreviewOptions) ([]string, error) {
+	if strings.TrimSpace(opts.Text) != "" {
+		return textutils.SplitIntoParagraphs(opts.Text), nil
+	}
+
+	r, err := epubreader.NewGoEpubReaderService(app.config.Epub.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	chapters, err := r.GetChapters()
+	if err != nil {
+		return nil, err
+	}
+
+	// Chapters are numbered like the audiobook's, skipping the ones that aren't narrated.
+	number := 0
+	for _, chapter := range chapters {
+		ch, err := epub.NewChapter(chapter.Id, chapter.Title, chapter.Content)
+		if err != nil || !ch.IsValid() {
+			continue
+		}
+
+		if number == opts.Chapter {
+			text := textutils.ExtractParagraphsFromHTML(ch.Content)
+			if len(text) == 0 {
+				return nil, fmt.Errorf("chapter %d does not have text", number)
+			}
+			return text, nil
+		}
+		number++
+	}
+
+	return nil, fmt.Errorf("the book has no chapter %d", opts.Chapter)
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestPreviewVoiceLabel(t *testing.T) {
+	tests := []struct {
+		voice PreviewVoice
+		want  string
+	}{
+		{PreviewVoice{}, "default"},
+		{PreviewVoice{SpeakerIdx: "p225"}, "p225"},
+		{PreviewVoice{SpeakerWav: "/voices/Jane Doe.wav"}, "Jane Doe"},
+		{PreviewVoice{Backend: "http", SpeakerIdx: "alloy"}, "http alloy"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.voice.Label(); got != tt.want {
+			t.Errorf("%+v label = %q, want %q", tt.voice, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewVoiceApply(t *testing.T) {
+	c := config.Config{}
+	c.Model.Backend = "coqui"
+	c.Model.SpeakerWav = "/voices/narrator.wav"
+
+	got := PreviewVoice{Backend: "http", SpeakerIdx: "alloy"}.apply(c)
+	if got.Model.Backend != "http" || got.Model.SpeakerIdx != "alloy" || got.HTTP.Voice != "alloy" || len(got.Model.SpeakerClips()) != 0 {
+		t.Errorf("model = %+v, http voice %q, want the http alloy voice", got.Model, got.HTTP.Voice)
+	}
+	if c.Model.SpeakerWav != "/voices/narrator.wav" {
+		t.Error("apply changed the original config")
+	}
+
+	got = PreviewVoice{SpeakerWav: "/voices/other.wav"}.apply(c)
+	if got.Model.Backend != "coqui" || got.Model.SpeakerWav != "/voices/other.wav" {
+		t.Errorf("model = %+v, want coqui with the other clip", got.Model)
+	}
+}
+
+func TestPreviewVoices(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+	// The fake engine already speaks in the preview format, so nothing needs converting
+	app.config.Test.SampleRate = previewSampleRate
+
+	const text = "The first paragraph.\n\nAnd the second one."
+	out := filepath.Join(t.TempDir(), "preview")
+	preview, err := app.PreviewVoices(context.Background(), PreviewOptions{
+		Text:      text,
+		Voices:    []PreviewVoice{{SpeakerIdx: "p225"}, {SpeakerIdx: "p226"}},
+		OutputDir: out,
+		Announce:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(out, "01-p225.wav"), filepath.Join(out, "02-p226.wav")}
+	if len(preview.Files) != len(want) {
+		t.Fatalf("files = %q, want %q", preview.Files, want)
+	}
+	voice := clipSeconds("The first paragraph.") + clipSeconds("And the second one.")
+	for i, f := range preview.Files {
+		if f != want[i] {
+			t.Errorf("file %d = %s, want %s", i, f, want[i])
+		}
+		if got := wavSeconds(t, f); math.Abs(got-voice) > 0.001 {
+			t.Errorf("%s lasts %.3fs, want %.3fs", f, got, voice)
+		}
+	}
+
+	// Each voice is announced before it's heard
+	comparison := clipSeconds("Voice 1. p225.") + clipSeconds("Voice 2. p226.") + 2*voice
+	if got := wavSeconds(t, preview.Comparison); math.Abs(got-comparison) > 0.001 {
+		t.Errorf("comparison lasts %.3fs, want %.3fs", got, comparison)
+	}
+}
+
+func TestPreviewVoicesErrors(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+
+	if _, err := app.PreviewVoices(context.Background(), PreviewOptions{Text: "Hello."}); err == nil {
+		t.Error("expected an error without voices")
+	}
+
+	// Converting isn't something the test double can do, and the error says which voice failed
+	_, err := app.PreviewVoices(context.Background(), PreviewOptions{
+		Text:      "Hello.",
+		Voices:    []PreviewVoice{{SpeakerIdx: "p225"}},
+		OutputDir: t.TempDir(),
+	})
+	if err == nil {
+		t.Error("expected an error converting the voice")
+	}
+}
+
+func TestListVoices(t *testing.T) {
+	app, _ := testApp(t, t.TempDir(), "test-book")
+
+	list, err := app.ListVoices(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Backend != "test" || list.SampleRate != testSampleRate {
+		t.Errorf("list = %+v, want the fake engine at %d Hz", list, testSampleRate)
+	}
+}
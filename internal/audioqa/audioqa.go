@@ -0,0 +1,195 @@
+package audioqa
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+const (
+	// frameLength is the window used to measure levels over time.
+	frameLength = 20 * time.Millisecond
+	// clipLevel is the sample level treated as clipped.
+	clipLevel = 0.999
+	// durationSlack allows very short texts, such as titles, some leeway in either direction.
+	durationSlack = 1.5 // seconds
+
+	defaultCharsPerSecond = 14
+)
+
+// Analysis is what was measured for a clip, and anything that looks wrong with it.
+type Analysis struct {
+	Duration       float64  `json:"duration"`
+	Expected       float64  `json:"expected"`
+	Level          float64  `json:"level"`
+	LongestSilence float64  `json:"longest_silence"`
+	Clipped        float64  `json:"clipped"`
+	Issues         []string `json:"issues,omitempty"`
+}
+
+// Ok reports whether nothing suspicious was found.
+func (a Analysis) Ok() bool {
+	return len(a.Issues) == 0
+}
+
+// Analyze checks the clip at path, synthesized from text with chars characters.
+func Analyze(path string, chars int, c config.QA) (Analysis, error) {
+	audio, err := wav.ReadFile(path)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("failed to read clip for analysis: %w", err)
+	}
+
+	cps := c.CharsPerSecond
+	if cps <= 0 {
+		cps = defaultCharsPerSecond
+	}
+
+	a := Analysis{
+		Duration: audio.Duration(),
+		Expected: float64(chars) / cps,
+	}
+
+	mono := Mono(audio)
+	a.Level = Level(mono)
+	a.LongestSilence = longestInternalSilence(mono, audio.SampleRate, c.SilenceLevel)
+	a.Clipped = ClippedRatio(mono)
+
+	switch {
+	case a.Duration < a.Expected*c.MinDurationRatio-durationSlack:
+		a.Issues = append(a.Issues, fmt.Sprintf("too short: %.1fs, expected about %.1fs", a.Duration, a.Expected))
+	case a.Duration > a.Expected*c.MaxDurationRatio+durationSlack:
+		a.Issues = append(a.Issues, fmt.Sprintf("too long: %.1fs, expected about %.1fs", a.Duration, a.Expected))
+	}
+
+	if a.Level < c.MinLevel {
+		a.Issues = append(a.Issues, fmt.Sprintf("too quiet: %.1f dBFS", a.Level))
+	}
+
+	if max := c.MaxSilence.Seconds(); max > 0 && a.LongestSilence > max {
+		a.Issues = append(a.Issues, fmt.Sprintf("silence of %.1fs inside the clip", a.LongestSilence))
+	}
+
+	if a.Clipped > c.MaxClipping {
+		a.Issues = append(a.Issues, fmt.Sprintf("clipping in %.2f%% of samples", a.Clipped*100))
+	}
+
+	return a, nil
+}
+
+// Better reports whether a is a better clip than b, by number of issues and then by closeness to the expected duration.
+func Better(a, b Analysis) bool {
+	if len(a.Issues) != len(b.Issues) {
+		return len(a.Issues) < len(b.Issues)
+	}
+	return math.Abs(a.Duration-a.Expected) < math.Abs(b.Duration-b.Expected)
+}
+
+// Mono averages the channels of a.
+func Mono(a *wav.Audio) []float64 {
+	if a.Channels == 1 {
+		return a.Samples
+	}
+
+	mono := make([]float64, len(a.Samples)/a.Channels)
+	for i := range mono {
+		var sum float64
+		for ch := 0; ch < a.Channels; ch++ {
+			sum += a.Samples[i*a.Channels+ch]
+		}
+		mono[i] = sum / float64(a.Channels)
+	}
+	return mono
+}
+
+// Level is the RMS level of samples in dBFS.
+func Level(samples []float64) float64 {
+	return dbfs(rms(samples))
+}
+
+// NoiseFloor estimates the background noise level in dBFS, from the quietest tenth of the clip.
+func NoiseFloor(samples []float64, sampleRate int) float64 {
+	frame := int(frameLength.Seconds() * float64(sampleRate))
+	if frame == 0 || len(samples) < frame {
+		return Level(samples)
+	}
+
+	levels := make([]float64, len(samples)/frame)
+	for i := range levels {
+		levels[i] = rms(samples[i*frame : (i+1)*frame])
+	}
+	slices.Sort(levels)
+
+	return dbfs(levels[len(levels)/10])
+}
+
+func rms(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, s := range samples {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func dbfs(v float64) float64 {
+	if v <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(v)
+}
+
+// longestInternalSilence finds the longest stretch below level, ignoring silence at the start and end of the clip.
+func longestInternalSilence(samples []float64, sampleRate int, level float64) float64 {
+	frame := int(frameLength.Seconds() * float64(sampleRate))
+	if frame == 0 || len(samples) < frame {
+		return 0
+	}
+
+	frames := len(samples) / frame
+	silent := make([]bool, frames)
+	for i := range silent {
+		silent[i] = dbfs(rms(samples[i*frame:(i+1)*frame])) < level
+	}
+
+	first, last := 0, frames-1
+	for first < frames && silent[first] {
+		first++
+	}
+	for last >= 0 && silent[last] {
+		last--
+	}
+
+	var longest, run int
+	for i := first; i <= last; i++ {
+		if silent[i] {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+
+	return float64(longest*frame) / float64(sampleRate)
+}
+
+// ClippedRatio is the fraction of samples at full scale.
+func ClippedRatio(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	var n int
+	for _, s := range samples {
+		if math.Abs(s) >= clipLevel {
+			n++
+		}
+	}
+	return float64(n) / float64(len(samples))
+}
@@ -0,0 +1,146 @@
+package audioqa
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+const sampleRate = 8000
+
+var qa = config.QA{
+	CharsPerSecond:   10,
+	MinDurationRatio: 0.35,
+	MaxDurationRatio: 2.5,
+	MinLevel:         -40,
+	SilenceLevel:     -50,
+	MaxSilence:       2 * time.Second,
+	MaxClipping:      0.001,
+}
+
+// segment is a stretch of a sine tone at amplitude, or silence when amplitude is 0.
+type segment struct {
+	seconds   float64
+	amplitude float64
+}
+
+func writeClip(t *testing.T, segments ...segment) string {
+	t.Helper()
+
+	var samples []int16
+	for _, s := range segments {
+		for i := range int(s.seconds * sampleRate) {
+			v := s.amplitude * math.Sin(2*math.Pi*220*float64(i)/sampleRate)
+			samples = append(samples, int16(max(-32768, min(32767, v*32768))))
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "clip.wav")
+	if err := wav.WriteFile(path, sampleRate, samples); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name     string
+		chars    int
+		segments []segment
+		issue    string
+	}{
+		{"good", 40, []segment{{4, 0.3}}, ""},
+		// Silence at the ends doesn't count
+		{"padded", 40, []segment{{3, 0}, {4, 0.3}, {3, 0}}, ""},
+		{"short title", 5, []segment{{1.5, 0.3}}, ""},
+		{"too short", 100, []segment{{1, 0.3}}, "too short"},
+		{"too long", 20, []segment{{8, 0.3}}, "too long"},
+		{"too quiet", 40, []segment{{4, 0.005}}, "too quiet"},
+		{"internal silence", 60, []segment{{2, 0.3}, {3, 0}, {2, 0.3}}, "silence of 3.0s"},
+		{"clipping", 40, []segment{{4, 1.5}}, "clipping"},
+	}
+
+	for _, tt := range tests {
+		a, err := Analyze(writeClip(t, tt.segments...), tt.chars, qa)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		issues := strings.Join(a.Issues, "; ")
+		if tt.issue == "" && !a.Ok() {
+			t.Errorf("%s: unexpected issues: %s", tt.name, issues)
+		}
+		if tt.issue != "" && !strings.Contains(issues, tt.issue) {
+			t.Errorf("%s: issues %q don't include %q", tt.name, issues, tt.issue)
+		}
+	}
+}
+
+func TestAnalyzeMeasurements(t *testing.T) {
+	a, err := Analyze(writeClip(t, segment{1, 0.5}, segment{0.5, 0}, segment{1, 0.5}), 25, qa)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Duration != 2.5 || a.Expected != 2.5 {
+		t.Errorf("duration = %g, expected %g, want 2.5 and 2.5", a.Duration, a.Expected)
+	}
+	// A sine at half scale has an RMS of 0.5/√2, about -9 dBFS, less for the silent part
+	if a.Level > -9 || a.Level < -11 {
+		t.Errorf("level = %.1f dBFS, want about -10", a.Level)
+	}
+	if math.Abs(a.LongestSilence-0.5) > 0.041 {
+		t.Errorf("longest silence = %.3fs, want about 0.5", a.LongestSilence)
+	}
+	if a.Clipped != 0 {
+		t.Errorf("clipped = %g, want 0", a.Clipped)
+	}
+}
+
+func TestAnalyzeNotWAV(t *testing.T) {
+	if _, err := Analyze(filepath.Join(t.TempDir(), "missing.wav"), 10, qa); err == nil {
+		t.Error("expected an error for a missing clip")
+	}
+}
+
+func TestBetter(t *testing.T) {
+	ok := Analysis{Duration: 3, Expected: 2}
+	closer := Analysis{Duration: 2.5, Expected: 2}
+	flawed := Analysis{Duration: 2, Expected: 2, Issues: []string{"too quiet"}}
+
+	if !Better(ok, flawed) || Better(flawed, ok) {
+		t.Error("fewer issues should be better")
+	}
+	if !Better(closer, ok) || Better(ok, closer) {
+		t.Error("closer to the expected duration should be better")
+	}
+	if Better(ok, ok) {
+		t.Error("a clip isn't better than itself")
+	}
+}
+
+func TestNoiseFloor(t *testing.T) {
+	read := func(segments ...segment) []float64 {
+		audio, err := wav.ReadFile(writeClip(t, segments...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Mono(audio)
+	}
+
+	// Pauses between the words set the floor, not the words
+	clean := read(segment{1, 0.3}, segment{0.5, 0}, segment{1, 0.3})
+	if floor := NoiseFloor(clean, sampleRate); !math.IsInf(floor, -1) {
+		t.Errorf("noise floor of a clean clip = %.1f dBFS, want silence", floor)
+	}
+
+	noisy := read(segment{1, 0.3}, segment{0.5, 0.01}, segment{1, 0.3})
+	if floor := NoiseFloor(noisy, sampleRate); math.Abs(floor-Level(read(segment{1, 0.01}))) > 0.5 {
+		t.Errorf("noise floor = %.1f dBFS, want the level of the noise", floor)
+	}
+}
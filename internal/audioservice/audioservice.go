@@ -4,22 +4,66 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/pixellini/go-audiobook/internal/config"
 )
 
 type AudioService interface {
-	CombineFiles(inputFiles []string, outputFile string) error
-	ConvertFile(inputFile, outputFile string) error
-	GetDuration(audioFilePath string) (float64, error)
-	CreateAudiobook(file, image, metadataPath, output string) error
+	CombineFiles(ctx context.Context, inputFiles []string, outputFile string) error
+	JoinClips(ctx context.Context, clips []Clip, outputFile string, joins config.Joins) error
+	ConvertFile(ctx context.Context, inputFile, outputFile string) error
+	GetDuration(ctx context.Context, audioFilePath string) (float64, error)
+	Resample(ctx context.Context, inputFile, outputFile string, sampleRate, channels int) error
+	AdjustProsody(ctx context.Context, inputFile, outputFile string, prosody config.Prosody) error
+	ApplyFilters(ctx context.Context, inputFile, outputFile string, filters []string) error
+	CheckFilters(ctx context.Context, filters []config.Filter) error
+	CreateAudiobook(ctx context.Context, file, image, metadataPath, output string, enc config.Encoder) error
+	MeasureLoudness(ctx context.Context, inputFile string, target config.Loudness) (Loudness, error)
+	NormalizeLoudness(ctx context.Context, inputFile, outputFile string, measured Loudness, target config.Loudness) (Loudness, error)
 }
 
 type FFMpegService struct {
 	outputDir string
+
+	filters  capabilities
+	encoders capabilities
+}
+
+// capabilities is a list the local ffmpeg prints, such as its filters. It's kept once it has been read,
+// a failed read is tried again next time so a cancelled probe doesn't hide what ffmpeg can do.
+type capabilities struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// has reports whether name is in the list ffmpeg prints for flag.
+// parse returns the name on a line of the list, or "" for lines that aren't entries.
+func (c *capabilities) has(ctx context.Context, flag, name string, parse func(fields []string) string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.names == nil {
+		out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", flag).Output()
+		if err != nil {
+			return false
+		}
+
+		c.names = make(map[string]bool)
+		for _, line := range strings.Split(string(out), "\n") {
+			if n := parse(strings.Fields(line)); n != "" {
+				c.names[n] = true
+			}
+		}
+	}
+
+	return c.names[name]
 }
 
 func NewFFMpegService(outputDir string) *FFMpegService {
@@ -28,7 +72,7 @@ func NewFFMpegService(outputDir string) *FFMpegService {
 	}
 }
 
-func (f *FFMpegService) CombineFiles(inputFiles []string, outputFile string) error {
+func (f *FFMpegService) CombineFiles(ctx context.Context, inputFiles []string, outputFile string) error {
 	// fmt.Println("Combining audio files:", len(inputFiles), "files into", outputFile)
 	if len(inputFiles) == 0 {
 		return fmt.Errorf("no input files provided for combination")
@@ -65,7 +109,7 @@ func (f *FFMpegService) CombineFiles(inputFiles []string, outputFile string) err
 		outputFile,
 	}
 
-	err = f.ffmpeg(args...)
+	err = f.ffmpegContext(ctx, args...)
 	if err != nil {
 		return fmt.Errorf("failed to concatenate audio files: %v", err)
 	}
@@ -90,12 +134,9 @@ func (f *FFMpegService) writeFileList(inputFiles []string, listFile string) erro
 	return os.WriteFile(listFile, []byte(fileListContent.String()), 0644)
 }
 
-func (f *FFMpegService) ConvertFile(inputFile, outputFile string) error {
-	return nil
-}
-
-func (f *FFMpegService) GetDuration(audioFilePath string) (float64, error) {
-	probeResult, err := exec.Command(
+func (f *FFMpegService) GetDuration(ctx context.Context, audioFilePath string) (float64, error) {
+	probeResult, err := exec.CommandContext(
+		ctx,
 		"ffprobe",
 		"-v",
 		"error",
@@ -118,25 +159,108 @@ func (f *FFMpegService) GetDuration(audioFilePath string) (float64, error) {
 	return duration, nil
 }
 
-func (f *FFMpegService) CreateAudiobook(file, image, metadataPath, output string) error {
-	return f.ffmpeg(
-		"-i", file,
-		"-i", metadataPath,
-		"-i", image,
-		"-map", "0:a",
-		"-map", "2:v",
-		"-map_metadata", "1",
-		"-c:a", "aac",
-		"-b:a", "64k",
-		"-c:v", "png",
-		"-disposition:v:0", "attached_pic",
-		"-f", "ipod",
-		output,
+// Resample converts the input to 16-bit PCM WAV with the given sample rate and channel count,
+// so it can be joined with other clips without re-encoding.
+func (f *FFMpegService) Resample(ctx context.Context, inputFile, outputFile string, sampleRate, channels int) error {
+	return f.ffmpegContext(ctx,
+		"-i", inputFile,
+		"-ar", strconv.Itoa(sampleRate),
+		"-ac", strconv.Itoa(channels),
+		"-c:a", "pcm_s16le",
+		"-y",
+		outputFile,
 	)
 }
 
-func (f *FFMpegService) ffmpeg(args ...string) error {
-	return f.ffmpegContext(context.Background(), args...)
+// AdjustProsody changes the speed, pitch and volume of the input.
+// The speed changes without affecting the pitch, so the duration of the output is the input's divided by the rate.
+func (f *FFMpegService) AdjustProsody(ctx context.Context, inputFile, outputFile string, prosody config.Prosody) error {
+	filters, err := f.prosodyFilters(ctx, prosody)
+	if err != nil {
+		return err
+	}
+
+	return f.ApplyFilters(ctx, inputFile, outputFile, filters)
+}
+
+// ApplyFilters runs the input through a chain of ffmpeg audio filters.
+func (f *FFMpegService) ApplyFilters(ctx context.Context, inputFile, outputFile string, filters []string) error {
+	if len(filters) == 0 {
+		filters = []string{"anull"}
+	}
+
+	return f.ffmpegContext(ctx,
+		"-i", inputFile,
+		"-af", strings.Join(filters, ","),
+		"-y",
+		outputFile,
+	)
+}
+
+func (f *FFMpegService) prosodyFilters(ctx context.Context, prosody config.Prosody) ([]string, error) {
+	var filters []string
+
+	rate := prosody.Speed()
+	switch {
+	case prosody.Pitch != 0:
+		// Only rubberband can shift the pitch while keeping the speed, so let it do both.
+		if !f.hasFilter(ctx, "rubberband") {
+			return nil, fmt.Errorf("changing the pitch needs ffmpeg built with librubberband")
+		}
+		filters = append(filters, fmt.Sprintf("rubberband=tempo=%g:pitch=%g", rate, math.Pow(2, prosody.Pitch/12)))
+	case rate != 1:
+		filters = append(filters, atempoFilters(rate)...)
+	}
+
+	if prosody.Gain != 0 {
+		filters = append(filters, fmt.Sprintf("volume=%gdB", prosody.Gain))
+	}
+
+	return filters, nil
+}
+
+// atempoFilters chains atempo filters for rate, since each one only goes from half to double speed.
+func atempoFilters(rate float64) []string {
+	var filters []string
+	for rate > 2 {
+		filters = append(filters, "atempo=2")
+		rate /= 2
+	}
+	for rate < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		rate /= 0.5
+	}
+	return append(filters, fmt.Sprintf("atempo=%g", rate))
+}
+
+// hasFilter reports whether the local ffmpeg has the named filter.
+func (f *FFMpegService) hasFilter(ctx context.Context, name string) bool {
+	// Lines look like " ... rubberband        A->A       Apply time-stretching and pitch-shifting."
+	return f.filters.has(ctx, "-filters", name, func(fields []string) string {
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			return fields[1]
+		}
+		return ""
+	})
+}
+
+// bestEncoder returns the first of the encoders the local ffmpeg has.
+// If it has none of them, or can't be asked, the last one is returned and ffmpeg reports the problem when it's used.
+func (f *FFMpegService) bestEncoder(ctx context.Context, encoders []string) string {
+	// Lines look like " A....D libfdk_aac           Fraunhofer FDK AAC (codec aac)"
+	parse := func(fields []string) string {
+		if len(fields) >= 2 && strings.HasPrefix(fields[0], "A") && len(fields[0]) == 6 {
+			return fields[1]
+		}
+		return ""
+	}
+
+	for _, e := range encoders {
+		if f.encoders.has(ctx, "-encoders", e, parse) {
+			return e
+		}
+	}
+	return encoders[len(encoders)-1]
 }
 
 func (f *FFMpegService) ffmpegContext(ctx context.Context, args ...string) error {
@@ -0,0 +1,90 @@
+package audioservice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestAtempoFilters(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want []string
+	}{
+		{1.5, []string{"atempo=1.5"}},
+		{5, []string{"atempo=2", "atempo=2", "atempo=1.25"}},
+		{0.2, []string{"atempo=0.5", "atempo=0.5", "atempo=0.8"}},
+	}
+
+	for _, tt := range tests {
+		if got := atempoFilters(tt.rate); !slices.Equal(got, tt.want) {
+			t.Errorf("atempoFilters(%g) = %q, want %q", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestProsodyFilters(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	ctx := context.Background()
+
+	tests := []struct {
+		prosody config.Prosody
+		want    []string
+	}{
+		{config.Prosody{}, nil},
+		{config.Prosody{Rate: 1.25, Gain: -3}, []string{"atempo=1.25", "volume=-3dB"}},
+	}
+	for _, tt := range tests {
+		got, err := f.prosodyFilters(ctx, tt.prosody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("prosodyFilters(%+v) = %q, want %q", tt.prosody, got, tt.want)
+		}
+	}
+
+	// Pitch needs rubberband
+	f.filters.names = map[string]bool{"rubberband": true}
+	got, err := f.prosodyFilters(ctx, config.Prosody{Rate: 1.5, Pitch: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"rubberband=tempo=1.5:pitch=2"}) {
+		t.Errorf("pitch filters = %q", got)
+	}
+
+	f = NewFFMpegService(t.TempDir())
+	f.filters.names = map[string]bool{}
+	if _, err := f.prosodyFilters(ctx, config.Prosody{Pitch: 1}); err == nil {
+		t.Error("expected an error changing the pitch without rubberband")
+	}
+}
+
+func TestHasFilterRetries(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	ctx := context.Background()
+
+	// Without ffmpeg nothing can be found, and nothing is kept
+	t.Setenv("PATH", t.TempDir())
+	if f.hasFilter(ctx, "rubberband") {
+		t.Error("found a filter without ffmpeg")
+	}
+	if f.filters.names != nil {
+		t.Error("kept the failed probe")
+	}
+
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho ' ... rubberband        A->A       Apply time-stretching and pitch-shifting.'\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	if !f.hasFilter(ctx, "rubberband") {
+		t.Error("rubberband wasn't found once ffmpeg could be asked")
+	}
+}
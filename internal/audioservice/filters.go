@@ -0,0 +1,37 @@
+package audioservice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// CheckFilters makes sure the local ffmpeg can run the filter chain, by checking it has every filter
+// and running a short tone through the chain, which catches options the filters don't understand.
+func (f *FFMpegService) CheckFilters(ctx context.Context, filters []config.Filter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	chain := make([]string, len(filters))
+	for i, filter := range filters {
+		if !f.hasFilter(ctx, filter.Name) {
+			return fmt.Errorf("ffmpeg doesn't have the %s filter", filter.Name)
+		}
+		chain[i] = filter.String()
+	}
+
+	err := f.ffmpegContext(ctx,
+		"-f", "lavfi",
+		"-i", "sine=frequency=440:sample_rate=22050:duration=0.5",
+		"-af", strings.Join(chain, ","),
+		"-f", "null",
+		"-",
+	)
+	if err != nil {
+		return fmt.Errorf("invalid filter chain %s: %w", strings.Join(chain, ","), err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package audioservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestCheckFilters(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	ctx := context.Background()
+
+	if err := f.CheckFilters(ctx, nil); err != nil {
+		t.Errorf("no filters: %v", err)
+	}
+
+	f.filters.names = map[string]bool{"highpass": true}
+	err := f.CheckFilters(ctx, []config.Filter{{Name: "highpass"}, {Name: "deesser"}})
+	if err == nil || err.Error() != "ffmpeg doesn't have the deesser filter" {
+		t.Errorf("err = %v, want the missing filter reported", err)
+	}
+}
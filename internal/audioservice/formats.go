@@ -0,0 +1,347 @@
+package audioservice
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/flac"
+	"github.com/pixellini/go-audiobook/internal/metadata"
+)
+
+// formatWAV isn't an output format, but files can be converted back to it.
+const formatWAV = "wav"
+
+// muxer is how audio is written in one format.
+type muxer struct {
+	// format is the ffmpeg muxer.
+	format string
+	// codecs are the encoders for the format, best first. The last one is used if the local ffmpeg can't be asked.
+	codecs  []string
+	bitrate string
+	// sampleRate is the only rate the format allows, or 0 if it takes any.
+	sampleRate int
+	// cover is the codec the cover is attached with, as a video stream.
+	// It's empty for formats that can only carry the cover as a tag.
+	cover     string
+	coverArgs []string
+	args      []string
+}
+
+var muxers = map[string]muxer{
+	// libfdk_aac and aac_at sound better than ffmpeg's own AAC encoder at speech bitrates
+	config.FormatM4B: {
+		format:  "ipod",
+		codecs:  []string{"libfdk_aac", "aac_at", "aac"},
+		bitrate: "64k",
+		cover:   "png",
+	},
+	config.FormatM4A: {
+		format:  "ipod",
+		codecs:  []string{"libfdk_aac", "aac_at", "aac"},
+		bitrate: "64k",
+		cover:   "png",
+	},
+	// Chapters are written as ID3v2 CHAP frames with a CTOC, which more players read from ID3v2.3 than v2.4.
+	config.FormatMP3: {
+		format:    "mp3",
+		codecs:    []string{"libmp3lame"},
+		bitrate:   "64k",
+		cover:     "mjpeg",
+		coverArgs: []string{"-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)"},
+		args:      []string{"-id3v2_version", "3", "-write_id3v1", "1"},
+	},
+	// Chapters are written as CHAPTERxxx Vorbis comments, and the cover as a METADATA_BLOCK_PICTURE comment.
+	config.FormatOpus: {
+		format:     "opus",
+		codecs:     []string{"libopus"},
+		bitrate:    "32k",
+		sampleRate: 48000,
+	},
+	// Chapters are written as Vorbis comments by ffmpeg and as a CUESHEET block afterwards.
+	config.FormatFLAC: {
+		format:    "flac",
+		codecs:    []string{"flac"},
+		cover:     "png",
+		coverArgs: []string{"-metadata:s:v", "comment=Cover (front)"},
+	},
+	formatWAV: {
+		format: "wav",
+		codecs: []string{"pcm_s16le"},
+	},
+}
+
+// audioArgs are the encoder arguments for the format, with the settings from enc in place of its defaults.
+func (f *FFMpegService) audioArgs(ctx context.Context, m muxer, enc config.Encoder) []string {
+	codec := enc.Codec
+	if codec == "" {
+		codec = f.bestEncoder(ctx, m.codecs)
+	}
+	args := []string{"-c:a", codec}
+
+	// Lossless formats don't have a bitrate or quality to set
+	bitrate := cmp.Or(enc.Bitrate, m.bitrate)
+	switch {
+	case m.bitrate == "":
+	case enc.Quality == "":
+		args = append(args, "-b:a", bitrate)
+	case codec == "libopus":
+		args = append(args, "-vbr", "on", "-b:a", bitrate)
+	case codec == "libfdk_aac":
+		args = append(args, "-vbr", enc.Quality)
+	case codec == "aac_at":
+		args = append(args, "-aac_at_mode", "vbr", "-q:a", enc.Quality)
+	default:
+		args = append(args, "-q:a", enc.Quality)
+	}
+
+	if rate := cmp.Or(m.sampleRate, enc.SampleRate); rate != 0 {
+		args = append(args, "-ar", strconv.Itoa(rate))
+	}
+	switch enc.Channels {
+	case "mono":
+		args = append(args, "-ac", "1")
+	case "stereo":
+		args = append(args, "-ac", "2")
+	}
+
+	return args
+}
+
+// coverFilter scales the cover down to fit enc.CoverSize, keeping its shape.
+func coverFilter(enc config.Encoder) string {
+	return fmt.Sprintf("scale=w='min(%[1]d,iw)':h='min(%[1]d,ih)':force_original_aspect_ratio=decrease", enc.CoverSize)
+}
+
+// formatOf returns the format for a file, from its extension.
+func formatOf(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+func muxerFor(path string) (muxer, error) {
+	m, ok := muxers[formatOf(path)]
+	if !ok {
+		return muxer{}, fmt.Errorf("unsupported format for %s, supported formats are %s", path, strings.Join(config.Formats, ", "))
+	}
+	return m, nil
+}
+
+// ConvertFile encodes the input in the format given by the output's extension, keeping its tags.
+func (f *FFMpegService) ConvertFile(ctx context.Context, inputFile, outputFile string) error {
+	m, err := muxerFor(outputFile)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"-i", inputFile, "-vn"}, f.audioArgs(ctx, m, config.Encoder{})...)
+	args = append(args, m.args...)
+	args = append(args, "-f", m.format, "-y", outputFile)
+
+	return f.ffmpegContext(ctx, args...)
+}
+
+// CreateAudiobook encodes the audio in the format given by the output's extension with the encoder settings,
+// with the tags and chapters from the metadata file and the image as cover art. The image is optional.
+func (f *FFMpegService) CreateAudiobook(ctx context.Context, file, image, metadataPath, output string, enc config.Encoder) error {
+	m, err := muxerFor(output)
+	if err != nil {
+		return err
+	}
+	format := formatOf(output)
+
+	if image != "" && m.cover == "" {
+		metadataPath, err = f.withPicture(ctx, metadataPath, image, enc)
+		if err != nil {
+			return fmt.Errorf("failed to add cover: %w", err)
+		}
+		defer os.Remove(metadataPath)
+		image = ""
+	}
+
+	args := []string{"-i", file, "-i", metadataPath}
+	if image != "" {
+		args = append(args, "-i", image)
+	}
+	args = append(args, "-map", "0:a", "-map_metadata", "1", "-map_chapters", "1")
+	args = append(args, f.audioArgs(ctx, m, enc)...)
+	if image != "" {
+		args = append(args, "-map", "2:v", "-c:v", cmp.Or(enc.CoverCodec, m.cover), "-disposition:v:0", "attached_pic")
+		if enc.CoverSize > 0 {
+			args = append(args, "-filter:v", coverFilter(enc))
+		}
+		args = append(args, m.coverArgs...)
+	}
+	args = append(args, m.args...)
+	args = append(args, "-f", m.format, output)
+
+	if err := f.ffmpegContext(ctx, args...); err != nil {
+		return err
+	}
+
+	if format == config.FormatFLAC {
+		tracks, err := readChapters(metadataPath)
+		if err != nil {
+			return err
+		}
+		if err := flac.EmbedCueSheet(output, tracks); err != nil {
+			return fmt.Errorf("failed to add cue sheet: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// withPicture copies the metadata file with the image added as a METADATA_BLOCK_PICTURE tag,
+// which is how Ogg files carry cover art. The image is converted first if enc changes the cover.
+func (f *FFMpegService) withPicture(ctx context.Context, metadataPath, imagePath string, enc config.Encoder) (string, error) {
+	if enc.CoverCodec != "" || enc.CoverSize > 0 {
+		converted, err := f.convertCover(ctx, imagePath, enc)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(converted)
+		imagePath = converted
+	}
+
+	picture, err := pictureBlock(imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Global tags have to come before the first chapter, straight after the header is simplest
+	header, rest, _ := bytes.Cut(content, []byte("\n"))
+	tag := "METADATA_BLOCK_PICTURE=" + metadata.Escape(base64.StdEncoding.EncodeToString(picture))
+
+	file, err := os.CreateTemp(f.outputDir, "metadata-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s\n%s\n%s", header, tag, rest); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// convertCover writes the image with the cover codec and size from enc into a temporary file.
+func (f *FFMpegService) convertCover(ctx context.Context, imagePath string, enc config.Encoder) (string, error) {
+	codec, ext := "png", ".png"
+	if enc.CoverCodec == "mjpeg" {
+		codec, ext = "mjpeg", ".jpg"
+	}
+
+	file, err := os.CreateTemp(f.outputDir, "cover-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	args := []string{"-i", imagePath, "-frames:v", "1", "-c:v", codec}
+	if enc.CoverSize > 0 {
+		args = append(args, "-vf", coverFilter(enc))
+	}
+	args = append(args, "-y", file.Name())
+
+	if err := f.ffmpegContext(ctx, args...); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// pictureBlock encodes the image as a FLAC PICTURE block for the front cover.
+func pictureBlock(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mime := http.DetectContentType(data)
+	// The size is informational, so an image Go can't decode is still attached
+	cfg, _, _ := image.DecodeConfig(bytes.NewReader(data))
+
+	const frontCover = 3
+	var buf bytes.Buffer
+	for _, v := range []any{
+		uint32(frontCover),
+		uint32(len(mime)), []byte(mime),
+		uint32(0), // description
+		uint32(cfg.Width), uint32(cfg.Height),
+		uint32(24), // colour depth
+		uint32(0),  // palette size, for indexed images only
+		uint32(len(data)), data,
+	} {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// readChapters returns the chapter start times from an ffmetadata file.
+func readChapters(metadataPath string) ([]flac.Track, error) {
+	file, err := os.Open(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		tracks   []flac.Track
+		timebase = time.Millisecond
+	)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "[CHAPTER]" {
+			tracks = append(tracks, flac.Track{})
+			timebase = time.Millisecond
+			continue
+		}
+		if len(tracks) == 0 {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "TIMEBASE":
+			num, den, _ := strings.Cut(value, "/")
+			n, err1 := strconv.ParseInt(num, 10, 64)
+			d, err2 := strconv.ParseInt(den, 10, 64)
+			if err1 != nil || err2 != nil || d == 0 {
+				return nil, fmt.Errorf("invalid chapter timebase %q", value)
+			}
+			timebase = time.Duration(n) * time.Second / time.Duration(d)
+		case "START":
+			start, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chapter start %q", value)
+			}
+			tracks[len(tracks)-1].Start = time.Duration(start) * timebase
+		}
+	}
+
+	return tracks, scanner.Err()
+}
@@ -0,0 +1,210 @@
+package audioservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/flac"
+)
+
+func TestMuxerFor(t *testing.T) {
+	for _, path := range []string{"book.m4b", "book.M4A", "book.mp3", "book.opus", "book.flac", "chapter.wav"} {
+		if _, err := muxerFor(path); err != nil {
+			t.Errorf("muxerFor(%s): %v", path, err)
+		}
+	}
+
+	_, err := muxerFor("book.ogg")
+	if err == nil || !strings.Contains(err.Error(), "m4b, m4a, mp3, opus, flac") {
+		t.Errorf("err = %v, want the supported formats", err)
+	}
+}
+
+func TestConvertFileUnsupported(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	if err := f.ConvertFile(context.Background(), "in.wav", "out.aiff"); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+
+func writeMetadata(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "metadata.txt")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadChapters(t *testing.T) {
+	path := writeMetadata(t, ";FFMETADATA1\ntitle=Book\nSTART=999\n"+
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=1500\ntitle=One\n\n"+
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=1500\nEND=3000\ntitle=Two\n\n"+
+		"[CHAPTER]\nTIMEBASE=1/10\nSTART=30\nEND=40\ntitle=Three\n")
+
+	tracks, err := readChapters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flac.Track{{Start: 0}, {Start: 1500 * time.Millisecond}, {Start: 3 * time.Second}}
+	if len(tracks) != len(want) {
+		t.Fatalf("tracks = %+v, want %+v", tracks, want)
+	}
+	for i, w := range want {
+		if tracks[i] != w {
+			t.Errorf("track %d = %+v, want %+v", i, tracks[i], w)
+		}
+	}
+
+	for _, bad := range []string{"TIMEBASE=1/0", "START=soon"} {
+		if _, err := readChapters(writeMetadata(t, "[CHAPTER]\n"+bad+"\n")); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func writePNG(t *testing.T, width, height int) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPictureBlock(t *testing.T) {
+	path := writePNG(t, 3, 2)
+	data, _ := os.ReadFile(path)
+
+	block, err := pictureBlock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(block)
+	read := func() uint32 {
+		var v uint32
+		binary.Read(r, binary.BigEndian, &v)
+		return v
+	}
+	readBytes := func() []byte {
+		b := make([]byte, read())
+		r.Read(b)
+		return b
+	}
+
+	if kind := read(); kind != 3 {
+		t.Errorf("picture type = %d, want the front cover", kind)
+	}
+	if mime := readBytes(); string(mime) != "image/png" {
+		t.Errorf("mime type = %q", mime)
+	}
+	if desc := readBytes(); len(desc) != 0 {
+		t.Errorf("description = %q, want none", desc)
+	}
+	if w, h := read(), read(); w != 3 || h != 2 {
+		t.Errorf("size = %dx%d, want 3x2", w, h)
+	}
+	read() // colour depth
+	read() // palette
+	if img := readBytes(); !bytes.Equal(img, data) {
+		t.Error("picture data isn't the image")
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes left over", r.Len())
+	}
+}
+
+func TestWithPicture(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	metadataPath := writeMetadata(t, ";FFMETADATA1\ntitle=Book\n[CHAPTER]\nSTART=0\n")
+
+	path, err := f.withPicture(context.Background(), metadataPath, writePNG(t, 1, 1), config.Encoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	if lines[0] != ";FFMETADATA1" || lines[2] != "title=Book" || lines[3] != "[CHAPTER]" {
+		t.Errorf("metadata = %q, want the tag added before the rest", lines)
+	}
+
+	tag, ok := strings.CutPrefix(lines[1], "METADATA_BLOCK_PICTURE=")
+	if !ok {
+		t.Fatalf("line 2 = %q, want the picture tag", lines[1])
+	}
+	// Base64 has '=' padding, which has to be escaped
+	block, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(tag, `\=`, "="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(block) != 3 {
+		t.Error("tag isn't a front cover picture block")
+	}
+}
+
+// withEncoders is an ffmpeg service that has only the given encoders.
+func withEncoders(encoders ...string) *FFMpegService {
+	f := NewFFMpegService("")
+	f.encoders.names = map[string]bool{}
+	for _, e := range encoders {
+		f.encoders.names[e] = true
+	}
+	return f
+}
+
+func TestAudioArgs(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		encoders []string
+		format   string
+		enc      config.Encoder
+		want     string
+	}{
+		{"defaults", nil, config.FormatM4B, config.Encoder{}, "-c:a aac -b:a 64k"},
+		{"best aac", []string{"aac", "aac_at"}, config.FormatM4B, config.Encoder{}, "-c:a aac_at -b:a 64k"},
+		{"codec", []string{"libfdk_aac"}, config.FormatM4B, config.Encoder{Codec: "aac", Bitrate: "48k"}, "-c:a aac -b:a 48k"},
+		{"opus", nil, config.FormatOpus, config.Encoder{Bitrate: "24k", SampleRate: 22050}, "-c:a libopus -b:a 24k -ar 48000"},
+		{"opus vbr", nil, config.FormatOpus, config.Encoder{Quality: "5"}, "-c:a libopus -vbr on -b:a 32k -ar 48000"},
+		{"fdk vbr", []string{"libfdk_aac"}, config.FormatM4B, config.Encoder{Quality: "3"}, "-c:a libfdk_aac -vbr 3"},
+		{"audiotoolbox vbr", []string{"aac_at"}, config.FormatM4B, config.Encoder{Quality: "9"}, "-c:a aac_at -aac_at_mode vbr -q:a 9"},
+		{"lame vbr", nil, config.FormatMP3, config.Encoder{Quality: "6", Channels: "mono"}, "-c:a libmp3lame -q:a 6 -ac 1"},
+		{"lossless", nil, config.FormatFLAC, config.Encoder{Bitrate: "64k", SampleRate: 44100, Channels: "stereo"}, "-c:a flac -ar 44100 -ac 2"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := withEncoders(tt.encoders...)
+			got := strings.Join(f.audioArgs(context.Background(), muxers[tt.format], tt.enc), " ")
+			if got != tt.want {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoverFilter(t *testing.T) {
+	got := coverFilter(config.Encoder{CoverSize: 600})
+	if want := "scale=w='min(600,iw)':h='min(600,ih)':force_original_aspect_ratio=decrease"; got != want {
+		t.Errorf("filter = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package audioservice
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+const (
+	// trimFrame is the window the level at the ends of a clip is measured over.
+	trimFrame = 10 * time.Millisecond
+	// minSound is how many windows in a row have to be loud for the audio to count as sound,
+	// so a click at the end of a clip is trimmed with its padding.
+	minSound = 3
+)
+
+// Clip is a clip to join, and the pause that follows it.
+type Clip struct {
+	Path  string
+	Pause time.Duration
+}
+
+// JoinClips joins the clips into a 16-bit PCM WAV file. The ends of each clip are trimmed to the first and
+// last sound louder than the trim level, faded in and out, and followed by the clip's pause.
+// A clip that is silent throughout is kept as it is. All clips must have the same sample rate and channels.
+func (f *FFMpegService) JoinClips(ctx context.Context, clips []Clip, outputFile string, joins config.Joins) (err error) {
+	if len(clips) == 0 {
+		return fmt.Errorf("no input files provided for combination")
+	}
+
+	var out *wav.Writer
+	defer func() {
+		// Don't leave a partial chapter behind
+		if err != nil && out != nil {
+			out.Close()
+			os.Remove(outputFile)
+		}
+	}()
+
+	var sampleRate, channels int
+	for _, clip := range clips {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		audio, err := wav.ReadFile(clip.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", clip.Path, err)
+		}
+
+		if out == nil {
+			sampleRate, channels = audio.SampleRate, audio.Channels
+			if out, err = wav.Create(outputFile, sampleRate, channels); err != nil {
+				return err
+			}
+		} else if audio.SampleRate != sampleRate || audio.Channels != channels {
+			return fmt.Errorf("%s is %dHz with %d channels, but the clips before it are %dHz with %d channels",
+				clip.Path, audio.SampleRate, audio.Channels, sampleRate, channels)
+		}
+
+		if err := out.Write(trimClip(audio, joins)); err != nil {
+			return err
+		}
+		if err := out.Silence(int(clip.Pause.Seconds() * float64(sampleRate))); err != nil {
+			return err
+		}
+	}
+
+	return out.Close()
+}
+
+// trimClip returns the clip's samples without the quiet audio at either end, faded in and out.
+func trimClip(audio *wav.Audio, joins config.Joins) []float64 {
+	ch := audio.Channels
+	frames := len(audio.Samples) / ch
+	window := max(1, int(trimFrame.Seconds()*float64(audio.SampleRate)))
+	fade := int(joins.Fade.Seconds() * float64(audio.SampleRate))
+	threshold := math.Pow(10, joins.TrimLevel/20)
+
+	loud := make([]bool, (frames+window-1)/window)
+	for i := range loud {
+		start, end := i*window, min((i+1)*window, frames)
+		var sum float64
+		for _, s := range audio.Samples[start*ch : end*ch] {
+			sum += s * s
+		}
+		loud[i] = math.Sqrt(sum/float64((end-start)*ch)) >= threshold
+	}
+
+	// sound reports whether the windows from i on, up to minSound of them, are all loud
+	sound := func(i int) bool {
+		for j := i; j < min(i+minSound, len(loud)); j++ {
+			if !loud[j] {
+				return false
+			}
+		}
+		return true
+	}
+
+	first := 0
+	for first < len(loud) && !sound(first) {
+		first++
+	}
+	if first == len(loud) {
+		return audio.Samples
+	}
+	last := len(loud) - 1
+	for last > first && !sound(max(first, last-minSound+1)) {
+		last--
+	}
+
+	// The fades go over the quiet audio either side, so the start and end of the speech aren't softened
+	start := max(0, first*window-fade)
+	end := min(frames, (last+1)*window+fade)
+	samples := audio.Samples[start*ch : end*ch]
+
+	fade = min(fade, (end-start)/2)
+	for i := range fade {
+		gain := (float64(i) + 0.5) / float64(fade)
+		for c := range ch {
+			samples[i*ch+c] *= gain
+			samples[len(samples)-(i+1)*ch+c] *= gain
+		}
+	}
+	return samples
+}
@@ -0,0 +1,120 @@
+package audioservice
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+const joinRate = 1000
+
+var testJoins = config.Joins{Enabled: true, TrimLevel: -40, Fade: 5 * time.Millisecond}
+
+// padded is a second of tone with the given amount of silence either side, at 1kHz.
+func padded(before, after time.Duration) []float64 {
+	var samples []float64
+	samples = append(samples, make([]float64, int(before.Seconds()*joinRate))...)
+	for i := range joinRate {
+		samples = append(samples, 0.5*math.Sin(float64(i)))
+	}
+	return append(samples, make([]float64, int(after.Seconds()*joinRate))...)
+}
+
+func writeClip(t *testing.T, name string, channels int, samples []float64) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	w, err := wav.Create(path, joinRate, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTrimClip(t *testing.T) {
+	audio := &wav.Audio{SampleRate: joinRate, Channels: 1, Samples: padded(200*time.Millisecond, 300*time.Millisecond)}
+
+	got := trimClip(audio, testJoins)
+	// The tone, and the fade either side of it
+	if len(got) != joinRate+10 {
+		t.Errorf("trimmed to %d samples, want %d", len(got), joinRate+10)
+	}
+	if got[0] != 0 || got[len(got)-1] != 0 {
+		t.Errorf("ends are %g and %g, want the silence before and after the tone", got[0], got[len(got)-1])
+	}
+}
+
+func TestTrimClipClick(t *testing.T) {
+	samples := padded(0, 500*time.Millisecond)
+	// A click is shorter than minSound windows, so it goes with the padding
+	samples[len(samples)-100] = 1
+
+	got := trimClip(&wav.Audio{SampleRate: joinRate, Channels: 1, Samples: samples}, testJoins)
+	if len(got) != joinRate+5 {
+		t.Errorf("trimmed to %d samples, want %d", len(got), joinRate+5)
+	}
+}
+
+func TestTrimClipSilent(t *testing.T) {
+	samples := make([]float64, 500)
+	if got := trimClip(&wav.Audio{SampleRate: joinRate, Channels: 1, Samples: samples}, testJoins); len(got) != 500 {
+		t.Errorf("trimmed a silent clip to %d samples, want it kept", len(got))
+	}
+}
+
+func TestJoinClips(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	clips := []Clip{
+		{Path: writeClip(t, "a.wav", 1, padded(100*time.Millisecond, 100*time.Millisecond)), Pause: 500 * time.Millisecond},
+		{Path: writeClip(t, "b.wav", 1, padded(0, 700*time.Millisecond)), Pause: time.Second},
+	}
+	output := filepath.Join(t.TempDir(), "chapter.wav")
+
+	if err := f.JoinClips(context.Background(), clips, output, testJoins); err != nil {
+		t.Fatal(err)
+	}
+
+	audio, err := wav.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two seconds of tone, the fades around them and the pauses
+	want := (joinRate + 10) + 500 + (joinRate + 5) + 1000
+	if len(audio.Samples) != want {
+		t.Errorf("joined %d samples, want %d", len(audio.Samples), want)
+	}
+}
+
+func TestJoinClipsMismatch(t *testing.T) {
+	f := NewFFMpegService(t.TempDir())
+	clips := []Clip{
+		{Path: writeClip(t, "mono.wav", 1, padded(0, 0))},
+		{Path: writeClip(t, "stereo.wav", 2, padded(0, 0))},
+	}
+	output := filepath.Join(t.TempDir(), "chapter.wav")
+
+	err := f.JoinClips(context.Background(), clips, output, testJoins)
+	if err == nil || !strings.Contains(err.Error(), "with 2 channels, but the clips before it are 1000Hz with 1 channels") {
+		t.Errorf("err = %v, want the mismatch reported", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("partial chapter was left behind: %v", err)
+	}
+
+	if err := f.JoinClips(context.Background(), nil, output, testJoins); err == nil {
+		t.Error("expected an error with no clips")
+	}
+}
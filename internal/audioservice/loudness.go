@@ -0,0 +1,153 @@
+package audioservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// Loudness is what ffmpeg's loudnorm filter measured, before and after normalizing.
+// Levels are in LUFS, peaks in dBTP and ranges in LU.
+type Loudness struct {
+	Integrated float64
+	TruePeak   float64
+	Range      float64
+	// Threshold and Offset are only needed to normalize the measured file.
+	Threshold float64
+	Offset    float64
+	// Normalization is "linear" when the gain was the same throughout, or "dynamic" when the
+	// true peak limit or loudness range couldn't be kept otherwise.
+	Normalization string
+}
+
+// loudnormStats is the JSON loudnorm prints, where every value is a string.
+type loudnormStats struct {
+	InputI            string `json:"input_i"`
+	InputTP           string `json:"input_tp"`
+	InputLRA          string `json:"input_lra"`
+	InputThresh       string `json:"input_thresh"`
+	OutputI           string `json:"output_i"`
+	OutputTP          string `json:"output_tp"`
+	OutputLRA         string `json:"output_lra"`
+	OutputThresh      string `json:"output_thresh"`
+	NormalizationType string `json:"normalization_type"`
+	TargetOffset      string `json:"target_offset"`
+}
+
+// MeasureLoudness is the first pass of EBU R128 normalization, it measures the input against the target.
+func (f *FFMpegService) MeasureLoudness(ctx context.Context, inputFile string, target config.Loudness) (Loudness, error) {
+	stats, err := f.loudnorm(ctx, loudnormFilter(target), inputFile, "-f", "null", "-")
+	if err != nil {
+		return Loudness{}, err
+	}
+
+	return parseLoudness(stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh, stats.TargetOffset, "")
+}
+
+// NormalizeLoudness is the second pass of EBU R128 normalization. It brings the input to the target using
+// what MeasureLoudness found, with the same gain throughout if that stays under the true peak limit,
+// and writes it as 16-bit PCM WAV at the input's sample rate. It returns the loudness of the output.
+func (f *FFMpegService) NormalizeLoudness(ctx context.Context, inputFile, outputFile string, measured Loudness, target config.Loudness) (Loudness, error) {
+	// loudnorm works at 192kHz, so the output has to be brought back to the input's rate
+	rate, err := sampleRate(ctx, inputFile)
+	if err != nil {
+		return Loudness{}, fmt.Errorf("failed to read sample rate: %w", err)
+	}
+
+	filter := fmt.Sprintf("%s:measured_I=%g:measured_TP=%g:measured_LRA=%g:measured_thresh=%g:offset=%g:linear=true",
+		loudnormFilter(target), measured.Integrated, measured.TruePeak, measured.Range, measured.Threshold, measured.Offset)
+
+	stats, err := f.loudnorm(ctx, filter, inputFile,
+		"-ar", strconv.Itoa(rate),
+		"-c:a", "pcm_s16le",
+		"-y",
+		outputFile,
+	)
+	if err != nil {
+		return Loudness{}, err
+	}
+
+	return parseLoudness(stats.OutputI, stats.OutputTP, stats.OutputLRA, stats.OutputThresh, stats.TargetOffset, stats.NormalizationType)
+}
+
+func loudnormFilter(target config.Loudness) string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:print_format=json", target.Target, target.TruePeak, target.Range)
+}
+
+// loudnorm runs the input through the filter and returns the statistics it printed.
+func (f *FFMpegService) loudnorm(ctx context.Context, filter, inputFile string, output ...string) (loudnormStats, error) {
+	args := append([]string{"-hide_banner", "-nostats", "-i", inputFile, "-vn", "-af", filter}, output...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return loudnormStats{}, fmt.Errorf("ffmpeg failed: %v\nStderr: %s", err, stderr.String())
+	}
+
+	// The statistics are the last thing written, after the "[Parsed_loudnorm_0 @ ...]" line
+	out := stderr.String()
+	start := strings.LastIndex(out, "{")
+	end := strings.LastIndex(out, "}")
+	if start < 0 || end < start {
+		return loudnormStats{}, fmt.Errorf("loudnorm printed no statistics")
+	}
+
+	var stats loudnormStats
+	if err := json.Unmarshal([]byte(out[start:end+1]), &stats); err != nil {
+		return loudnormStats{}, fmt.Errorf("failed to parse loudnorm statistics: %w", err)
+	}
+	return stats, nil
+}
+
+func parseLoudness(integrated, truePeak, lra, threshold, offset, normalization string) (Loudness, error) {
+	l := Loudness{Normalization: normalization}
+	for _, v := range []struct {
+		name  string
+		value string
+		dst   *float64
+	}{
+		{"integrated loudness", integrated, &l.Integrated},
+		{"true peak", truePeak, &l.TruePeak},
+		{"loudness range", lra, &l.Range},
+		{"threshold", threshold, &l.Threshold},
+		{"offset", offset, &l.Offset},
+	} {
+		// Silence measures as -inf, which ParseFloat understands
+		n, err := strconv.ParseFloat(strings.TrimSpace(v.value), 64)
+		if err != nil {
+			return Loudness{}, fmt.Errorf("invalid %s %q", v.name, v.value)
+		}
+		*v.dst = n
+	}
+	return l, nil
+}
+
+// sampleRate returns the sample rate of the first audio stream.
+func sampleRate(ctx context.Context, path string) (int, error) {
+	out, err := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v",
+		"error",
+		"-select_streams",
+		"a:0",
+		"-show_entries",
+		"stream=sample_rate",
+		"-of",
+		"default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,39 @@
+package audioservice
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestLoudnormFilter(t *testing.T) {
+	got := loudnormFilter(config.Loudness{Target: -18, TruePeak: -1.5, Range: 11})
+	if want := "loudnorm=I=-18:TP=-1.5:LRA=11:print_format=json"; got != want {
+		t.Errorf("filter = %q, want %q", got, want)
+	}
+}
+
+func TestParseLoudness(t *testing.T) {
+	l, err := parseLoudness("-23.54", " -4.47", "2.10", "-33.85", "0.28", "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Loudness{Integrated: -23.54, TruePeak: -4.47, Range: 2.1, Threshold: -33.85, Offset: 0.28, Normalization: "linear"}
+	if l != want {
+		t.Errorf("loudness = %+v, want %+v", l, want)
+	}
+
+	// Silence measures as -inf
+	l, err = parseLoudness("-inf", "-inf", "0.00", "-70.00", "inf", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(l.Integrated, -1) || !math.IsInf(l.TruePeak, -1) || !math.IsInf(l.Offset, 1) {
+		t.Errorf("loudness = %+v, want infinite levels", l)
+	}
+
+	if _, err := parseLoudness("-23", "-4", "", "-33", "0", ""); err == nil || err.Error() != `invalid loudness range ""` {
+		t.Errorf("err = %v, want the missing range reported", err)
+	}
+}
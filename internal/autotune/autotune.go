@@ -0,0 +1,202 @@
+package autotune
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minSamples is the number of finished chunks needed before a window says anything useful.
+	minSamples = 3
+	// tolerance is how much throughput may drop before a change is considered worse.
+	tolerance = 0.05
+	// overloaded is the load per CPU above which workers are removed regardless of throughput.
+	overloaded = 1.5
+	// busy is the load per CPU above which no workers are added.
+	busy = 0.9
+
+	defaultInterval = 30 * time.Second
+)
+
+// Decision is a single adjustment, or a reason for holding steady, made by the tuner.
+type Decision struct {
+	Time       time.Time `json:"time"`
+	Workers    int       `json:"workers"`
+	Previous   int       `json:"previous"`
+	Throughput float64   `json:"throughput"`
+	Load       float64   `json:"load,omitempty"`
+	Reason     string    `json:"reason"`
+}
+
+// Tuner adjusts the number of workers within bounds by measuring throughput in characters per second.
+// It climbs one worker at a time while throughput improves and the system has room,
+// and steps back when throughput drops or the machine is overloaded.
+type Tuner struct {
+	min, max int
+	interval time.Duration
+	set      func(int)
+	// load reports the load per CPU, or a negative value when it isn't known.
+	load func() float64
+
+	mu      sync.Mutex
+	workers int
+	// ceiling is the highest worker count still worth trying. It drops when adding a worker made things worse.
+	ceiling    int
+	chars      int
+	samples    int
+	since      time.Time
+	previous   int
+	previousTP float64
+	decisions  []Decision
+}
+
+// New creates a tuner that starts at start workers and calls set whenever the count changes.
+func New(min, max, start int, interval time.Duration, set func(int)) *Tuner {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	start = clamp(start, min, max)
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Tuner{
+		min:      min,
+		max:      max,
+		interval: interval,
+		set:      set,
+		load:     loadPerCPU,
+		workers:  start,
+		ceiling:  max,
+		since:    time.Now(),
+	}
+}
+
+// Record adds a finished chunk to the current measurement window.
+func (t *Tuner) Record(chars int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.chars += chars
+	t.samples++
+}
+
+// Run evaluates throughput every interval until ctx is done.
+func (t *Tuner) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.evaluate()
+		}
+	}
+}
+
+func (t *Tuner) Workers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.workers
+}
+
+func (t *Tuner) Decisions() []Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Decision(nil), t.decisions...)
+}
+
+func (t *Tuner) evaluate() {
+	t.mu.Lock()
+
+	if t.samples < minSamples {
+		// Keep collecting, long chunks can take a while
+		t.mu.Unlock()
+		return
+	}
+
+	tp := float64(t.chars) / time.Since(t.since).Seconds()
+	load := t.load()
+	current := t.workers
+	next := current
+	var reason string
+
+	switch {
+	case load > overloaded && current > t.min:
+		next = current - 1
+		reason = fmt.Sprintf("system overloaded (load %.2f per CPU)", load)
+
+	case t.previousTP > 0 && tp < t.previousTP*(1-tolerance) && t.previous != current:
+		// The last change made things worse, so undo it and don't try it again
+		next = t.previous
+		if current > t.previous {
+			t.ceiling = t.previous
+		}
+		reason = fmt.Sprintf("throughput dropped from %.1f to %.1f chars/s", t.previousTP, tp)
+
+	case current < t.ceiling && (load < 0 || load < busy):
+		next = current + 1
+		reason = fmt.Sprintf("throughput %.1f chars/s, trying one more worker", tp)
+
+	default:
+		reason = fmt.Sprintf("holding at %.1f chars/s", tp)
+	}
+
+	t.decisions = append(t.decisions, Decision{
+		Time:       time.Now(),
+		Workers:    next,
+		Previous:   current,
+		Throughput: tp,
+		Load:       max(load, 0),
+		Reason:     reason,
+	})
+
+	t.previous = current
+	t.previousTP = tp
+	t.workers = next
+	t.chars = 0
+	t.samples = 0
+	t.since = time.Now()
+	t.mu.Unlock()
+
+	if next != current {
+		t.set(next)
+	}
+}
+
+// loadPerCPU returns the one minute load average divided by the number of CPUs, or -1 when it isn't available.
+func loadPerCPU() float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return -1
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return -1
+	}
+
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return -1
+	}
+
+	return load / float64(runtime.NumCPU())
+}
+
+func clamp(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
@@ -0,0 +1,129 @@
+package autotune
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestTuner returns a tuner on a fixed load that records every change it makes.
+func newTestTuner(min, max, start int, load float64) (*Tuner, *[]int) {
+	var changes []int
+	t := New(min, max, start, time.Hour, func(n int) { changes = append(changes, n) })
+	t.load = func() float64 { return load }
+	return t, &changes
+}
+
+// window records chunks as if they finished at the given throughput over the last ten seconds, then evaluates them.
+func window(t *Tuner, throughput float64) {
+	for range minSamples {
+		t.Record(int(throughput * 10 / minSamples))
+	}
+	t.mu.Lock()
+	t.since = time.Now().Add(-10 * time.Second)
+	t.mu.Unlock()
+
+	t.evaluate()
+}
+
+func TestNewBounds(t *testing.T) {
+	tests := []struct {
+		min, max, start int
+		want            int
+	}{
+		{1, 4, 2, 2},
+		{1, 4, 9, 4},
+		{3, 4, 1, 3},
+		// Nonsense bounds still give a usable tuner
+		{0, 0, 0, 1},
+		{5, 2, 1, 5},
+	}
+
+	for _, tt := range tests {
+		tuner := New(tt.min, tt.max, tt.start, 0, func(int) {})
+		if got := tuner.Workers(); got != tt.want {
+			t.Errorf("New(%d, %d, %d) starts at %d workers, want %d", tt.min, tt.max, tt.start, got, tt.want)
+		}
+		if tuner.interval != defaultInterval {
+			t.Errorf("interval = %v, want the default", tuner.interval)
+		}
+	}
+}
+
+func TestClimbsWhileThroughputImproves(t *testing.T) {
+	tuner, changes := newTestTuner(1, 3, 1, 0.2)
+
+	window(tuner, 10)
+	window(tuner, 20)
+	// At the maximum, more throughput doesn't add workers
+	window(tuner, 30)
+
+	if want := []int{2, 3}; !equal(*changes, want) {
+		t.Errorf("changes = %v, want %v", *changes, want)
+	}
+	if d := tuner.Decisions(); len(d) != 3 || d[2].Workers != 3 || d[2].Previous != 3 {
+		t.Errorf("decisions = %+v, want a hold at 3", d)
+	}
+}
+
+func TestStepsBackWhenThroughputDrops(t *testing.T) {
+	tuner, changes := newTestTuner(1, 4, 2, 0.2)
+
+	window(tuner, 20)
+	// The third worker made things worse, so it's removed and not tried again
+	window(tuner, 10)
+	window(tuner, 10)
+	window(tuner, 10)
+
+	if want := []int{3, 2}; !equal(*changes, want) {
+		t.Errorf("changes = %v, want %v", *changes, want)
+	}
+	if tuner.ceiling != 2 {
+		t.Errorf("ceiling = %d, want 2", tuner.ceiling)
+	}
+}
+
+func TestOverloaded(t *testing.T) {
+	tuner, changes := newTestTuner(2, 4, 3, overloaded+0.5)
+
+	window(tuner, 10)
+	window(tuner, 10)
+	// Never below the minimum
+	window(tuner, 10)
+
+	if want := []int{2}; !equal(*changes, want) {
+		t.Errorf("changes = %v, want %v", *changes, want)
+	}
+}
+
+func TestBusyHolds(t *testing.T) {
+	tuner, changes := newTestTuner(1, 4, 1, busy+0.1)
+
+	window(tuner, 10)
+
+	if len(*changes) != 0 {
+		t.Errorf("changes = %v, want none on a busy machine", *changes)
+	}
+}
+
+func TestWaitsForSamples(t *testing.T) {
+	tuner, changes := newTestTuner(1, 4, 1, 0)
+
+	tuner.Record(100)
+	tuner.evaluate()
+
+	if len(*changes) != 0 || len(tuner.Decisions()) != 0 {
+		t.Error("evaluated before enough chunks finished")
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/app"
+)
+
+const (
+	CommandCache = "cache"
+	CommandPrune = "prune"
+)
+
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s %s %s", os.Args[0], CommandCache, CommandPrune)
+	}
+
+	switch args[0] {
+	case CommandPrune:
+		return runPrune(args[1:])
+	default:
+		return fmt.Errorf("unknown %s command %q", CommandCache, args[0])
+	}
+}
+
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet(CommandCache+" "+CommandPrune, flag.ContinueOnError)
+
+	var (
+		size string
+		age  time.Duration
+	)
+	fs.StringVar(&size, "max-size", "", "Remove the least recently used audio until the cache is this big, e.g. 2G")
+	fs.DurationVar(&age, "max-age", 0, "Remove audio that hasn't been used for this long, e.g. 720h")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	maxSize, err := parseSize(size)
+	if err != nil {
+		return err
+	}
+	if maxSize == 0 && age == 0 {
+		return fmt.Errorf("%s %s needs --max-size, --max-age or both", CommandCache, CommandPrune)
+	}
+
+	a, err := app.New()
+	if err != nil {
+		return fmt.Errorf("error happened on create: %w", err)
+	}
+
+	pruned, err := a.PruneCache(maxSize, age)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d files, %.1f MB\n", pruned.Files, float64(pruned.Bytes)/(1<<20))
+	return nil
+}
+
+// parseSize reads a size in bytes, with an optional K, M, G or T suffix in powers of 1024.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	number := strings.ToUpper(strings.TrimSpace(s))
+	if i := strings.IndexAny(number, "KMGT"); i >= 0 && i == len(number)-1 {
+		unit = 1 << (10 * (strings.IndexByte("KMGT", number[i]) + 1))
+		number = number[:i]
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
@@ -2,28 +2,62 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/pixellini/go-audiobook/internal/app"
 	"github.com/pixellini/go-audiobook/internal/flags"
 )
 
+const (
+	ExitFailure = 1
+	// ExitResumable means the run was stopped early and running again will continue where it left off.
+	ExitResumable = 75
+)
+
 func Run() error {
-	return RunContext(context.Background())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Let a second signal kill the process straight away, in case stopping gets stuck.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	return RunContext(ctx)
 }
 
 func RunContext(ctx context.Context) error {
+	if len(os.Args) > 1 && os.Args[1] == CommandVoices {
+		return runVoices(ctx, os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == CommandCache {
+		return runCache(os.Args[2:])
+	}
+
 	f := flags.New()
 
 	app, err := app.NewWithFlags(f)
 	if err != nil {
-		return fmt.Errorf("error happened on create", err)
+		return fmt.Errorf("error happened on create: %w", err)
 	}
 
-	err = app.Run()
+	err = app.RunContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error happened on run", err)
+		return fmt.Errorf("error happened on run: %w", err)
 	}
 
 	return nil
 }
+
+// ExitCode returns the process exit code for an error returned by Run.
+func ExitCode(err error) int {
+	if errors.Is(err, app.ErrInterrupted) {
+		return ExitResumable
+	}
+	return ExitFailure
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/app"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), ExitFailure},
+		{app.ErrInterrupted, ExitResumable},
+		{fmt.Errorf("error happened on run: %w", app.ErrInterrupted), ExitResumable},
+	}
+
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestListFlag(t *testing.T) {
+	var l listFlag
+	for _, v := range []string{"p225, p226", "p227,,"} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !slices.Equal(l, listFlag{"p225", "p226", "p227"}) {
+		t.Errorf("list = %q, want every value from both flags", l)
+	}
+	if l.String() != "p225,p226,p227" {
+		t.Errorf("String() = %q", l.String())
+	}
+}
+
+func TestRunVoicesUsage(t *testing.T) {
+	if err := runVoices(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("err = %v, want the usage", err)
+	}
+	if err := runVoices(context.Background(), []string{"sing"}); err == nil || !strings.Contains(err.Error(), `unknown voices command "sing"`) {
+		t.Errorf("err = %v, want an unknown command", err)
+	}
+}
+
+func TestUnknownIfEmpty(t *testing.T) {
+	if got := unknownIfZero(0, "%d Hz"); got != "unknown" {
+		t.Errorf("unknownIfZero(0) = %q", got)
+	}
+	if got := unknownIfZero(24000, "%d Hz"); got != "24000 Hz" {
+		t.Errorf("unknownIfZero(24000) = %q", got)
+	}
+	if got := unknownIfEmpty(nil); got != "unknown" {
+		t.Errorf("unknownIfEmpty(nil) = %q", got)
+	}
+	if got := unknownIfEmpty([]string{"a", "b"}); got != "2\n  a\n  b" {
+		t.Errorf("unknownIfEmpty = %q, want the count and one per line", got)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"512K", 512 << 10, false},
+		{"1.5g", 3 << 29, false},
+		{"2T", 2 << 40, false},
+		{"G", 0, true},
+		{"-1M", 0, true},
+		{"10GB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRunCacheUsage(t *testing.T) {
+	if err := runCache(nil); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("err = %v, want the usage", err)
+	}
+	if err := runCache([]string{"clear"}); err == nil || !strings.Contains(err.Error(), `unknown cache command "clear"`) {
+		t.Errorf("err = %v, want an unknown command", err)
+	}
+	if err := runCache([]string{"prune"}); err == nil || !strings.Contains(err.Error(), "needs --max-size") {
+		t.Errorf("err = %v, want a limit to be asked for", err)
+	}
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pixellini/go-audiobook/internal/app"
+)
+
+const (
+	CommandVoices  = "voices"
+	CommandPreview = "preview"
+	CommandList    = "list"
+)
+
+// listFlag collects comma separated values, and can be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func runVoices(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s %s <%s|%s>", os.Args[0], CommandVoices, CommandList, CommandPreview)
+	}
+
+	switch args[0] {
+	case CommandList:
+		return runList(ctx, args[1:])
+	case CommandPreview:
+		return runPreview(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown %s command %q", CommandVoices, args[0])
+	}
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet(CommandVoices+" "+CommandList, flag.ContinueOnError)
+
+	var (
+		backend string
+		asJSON  bool
+	)
+	fs.StringVar(&backend, "backend", "", "Backend to list, instead of the configured one")
+	fs.BoolVar(&asJSON, "json", false, "Print the list as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := app.New()
+	if err != nil {
+		return fmt.Errorf("error happened on create: %w", err)
+	}
+
+	list, err := a.ListVoices(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+
+	fmt.Printf("Backend:          %s\n", list.Backend)
+	fmt.Printf("Sample rate:      %s\n", unknownIfZero(list.SampleRate, "%d Hz"))
+	fmt.Printf("Max input length: %s\n", unknownIfZero(list.MaxInputLength, "%d characters"))
+	fmt.Printf("Languages:        %s\n", unknownIfEmpty(list.Languages))
+	fmt.Printf("Speakers:         %s\n", unknownIfEmpty(list.Speakers))
+
+	return nil
+}
+
+func unknownIfZero(v int, format string) string {
+	if v == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf(format, v)
+}
+
+func unknownIfEmpty(list []string) string {
+	if len(list) == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d\n  %s", len(list), strings.Join(list, "\n  "))
+}
+
+func runPreview(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet(CommandVoices+" "+CommandPreview, flag.ContinueOnError)
+
+	var (
+		speakers, wavs, backends listFlag
+		opts                     app.PreviewOptions
+		textFile                 string
+	)
+	fs.StringVar(&opts.Text, "text", "", "Passage to read")
+	fs.StringVar(&textFile, "text-file", "", "File with the passage to read")
+	fs.IntVar(&opts.Chapter, "chapter", 1, "Chapter of the configured book to read, when no text is given")
+	fs.Var(&speakers, "speakers", "Speaker indices to audition, comma separated")
+	fs.Var(&wavs, "wavs", "Speaker reference clips to audition, comma separated")
+	fs.Var(&backends, "backends", "Backends to audition with the configured voice, comma separated")
+	fs.StringVar(&opts.OutputDir, "out", "./.dist/preview/", "Directory for the preview files")
+	fs.BoolVar(&opts.Announce, "announce", true, "Announce each voice in the comparison file")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if textFile != "" {
+		data, err := os.ReadFile(textFile)
+		if err != nil {
+			return err
+		}
+		opts.Text = string(data)
+	}
+
+	for _, s := range speakers {
+		opts.Voices = append(opts.Voices, app.PreviewVoice{SpeakerIdx: s})
+	}
+	for _, w := range wavs {
+		opts.Voices = append(opts.Voices, app.PreviewVoice{SpeakerWav: w})
+	}
+	for _, b := range backends {
+		opts.Voices = append(opts.Voices, app.PreviewVoice{Backend: b})
+	}
+
+	a, err := app.New()
+	if err != nil {
+		return fmt.Errorf("error happened on create: %w", err)
+	}
+
+	fmt.Printf("Rendering %d voices...\n", len(opts.Voices))
+	preview, err := a.PreviewVoices(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range preview.Files {
+		fmt.Printf("%2d. %s\n", i+1, f)
+	}
+	fmt.Printf("Comparison: %s\n", preview.Comparison)
+
+	return nil
+}
@@ -1,20 +1,46 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"maps"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/pixellini/go-coqui/model"
+	"github.com/pixellini/go-coqui/models/tts"
 	"github.com/pixellini/go-coqui/models/vocoder"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	VerboseLogs bool    `mapstructure:"verbose_logs"`
-	TestMode    bool    `mapstructure:"test_mode"`
-	Epub        Epub    `mapstructure:"epub"`
-	Output      Output  `mapstructure:"output"`
-	Model       Model   `mapstructure:"model"`
-	Vocoder     Vocoder `mapstructure:"vocoder"`
+	VerboseLogs bool `mapstructure:"verbose_logs"`
+	TestMode    bool `mapstructure:"test_mode"`
+	Epub        Epub `mapstructure:"epub"`
+	// Outputs are the files to create, all from the same synthesized chapters.
+	// The output section is either a single target or a list of them.
+	Outputs  []Output `mapstructure:"-"`
+	Model    Model    `mapstructure:"model"`
+	Vocoder  Vocoder  `mapstructure:"vocoder"`
+	HTTP     HTTP     `mapstructure:"http"`
+	Plugin   Plugin   `mapstructure:"plugin"`
+	Test     Test     `mapstructure:"test"`
+	QA       QA       `mapstructure:"qa"`
+	Fallback Fallback `mapstructure:"fallback"`
+	Speaker  Speaker  `mapstructure:"speaker"`
+	Joins    Joins    `mapstructure:"joins"`
+	Loudness Loudness `mapstructure:"loudness"`
+	// Prosody applies to the whole book. Voices and Chapters adjust it further.
+	Prosody  Prosody           `mapstructure:"prosody"`
+	Voices   []Voice           `mapstructure:"voices"`
+	Chapters []ChapterOverride `mapstructure:"chapters"`
+	// Filters post-process the audio of every chapter, before any filters for its voice.
+	Filters []Filter `mapstructure:"filters"`
 }
 
 type Epub struct {
@@ -29,18 +55,133 @@ type Epub struct {
 
 type Output struct {
 	Path string `mapstructure:"path"`
-	// Format   string `mapstructure:"format"`
-	Filename string `mapstructure:"filename"`
+	// Format is one of the Format constants. It's also the file extension.
+	Format string `mapstructure:"format"`
+	// Filename is the name of the file, or of the directory when Split is set, without the extension.
+	// It's a Go template with .Book and .Author, and is the book title when it's empty.
+	Filename string  `mapstructure:"filename"`
+	Encoder  Encoder `mapstructure:"encoder"`
+	// Split writes a file per chapter into a directory named after Filename, with a playlist and the cover,
+	// rather than a single file with chapter markers.
+	Split bool `mapstructure:"split"`
+	// Template names the chapter files when Split is set. It's a Go template, without the extension, with
+	// .Track as the zero-padded track number, .Title as the chapter title, .Book and .Author.
+	Template string `mapstructure:"template"`
 }
 
+// Encoder changes how a target's audio is encoded. Empty fields keep the format's defaults.
+type Encoder struct {
+	// Preset is one of EncoderPresets. It fills in the fields that aren't set.
+	Preset string `mapstructure:"preset"`
+	// Codec is the ffmpeg encoder. The best encoder the local ffmpeg has for the format is used when it's empty.
+	Codec string `mapstructure:"codec"`
+	// Bitrate is in ffmpeg's notation, for example "64k".
+	Bitrate string `mapstructure:"bitrate"`
+	// Quality turns on variable bitrate encoding, in the codec's own scale. It's used instead of Bitrate,
+	// apart from Opus which is always variable and aims for Bitrate.
+	Quality    string `mapstructure:"quality"`
+	SampleRate int    `mapstructure:"sample_rate"`
+	// Channels is "mono" or "stereo".
+	Channels string `mapstructure:"channels"`
+	// CoverCodec is "png" or "mjpeg".
+	CoverCodec string `mapstructure:"cover_codec"`
+	// CoverSize is the largest width or height of the cover in pixels. Larger covers are scaled down.
+	CoverSize int `mapstructure:"cover_size"`
+}
+
+// EncoderPresets are the named settings for encoder.preset.
+var EncoderPresets = map[string]Encoder{
+	"speech-low": {
+		Bitrate:    "32k",
+		SampleRate: 22050,
+		Channels:   "mono",
+		CoverCodec: "mjpeg",
+		CoverSize:  600,
+	},
+	"speech-high": {
+		Bitrate:    "96k",
+		SampleRate: 44100,
+		Channels:   "mono",
+		CoverCodec: "png",
+		CoverSize:  1400,
+	},
+}
+
+// withPreset returns e with the fields it doesn't set taken from its preset.
+func (e Encoder) withPreset() (Encoder, error) {
+	if e.Preset == "" {
+		return e, nil
+	}
+
+	p, ok := EncoderPresets[e.Preset]
+	if !ok {
+		return e, fmt.Errorf("unknown encoder preset %q", e.Preset)
+	}
+
+	e.Codec = cmp.Or(e.Codec, p.Codec)
+	e.Bitrate = cmp.Or(e.Bitrate, p.Bitrate)
+	e.Quality = cmp.Or(e.Quality, p.Quality)
+	e.SampleRate = cmp.Or(e.SampleRate, p.SampleRate)
+	e.Channels = cmp.Or(e.Channels, p.Channels)
+	e.CoverCodec = cmp.Or(e.CoverCodec, p.CoverCodec)
+	e.CoverSize = cmp.Or(e.CoverSize, p.CoverSize)
+	return e, nil
+}
+
+// Formats for output.format.
+const (
+	FormatM4B  = "m4b"
+	FormatM4A  = "m4a"
+	FormatMP3  = "mp3"
+	FormatOpus = "opus"
+	FormatFLAC = "flac"
+)
+
+// Formats are the supported output formats.
+var Formats = []string{FormatM4B, FormatM4A, FormatMP3, FormatOpus, FormatFLAC}
+
 type Model struct {
-	Name        string         `mapstructure:"name"`
-	Language    model.Language `mapstructure:"language"`
-	SpeakerWav  string         `mapstructure:"speaker_wav"`
-	SpeakerIdx  string         `mapstructure:"speaker_idx"`
-	Concurrency uint8          `mapstructure:"concurrency"`
-	MaxRetries  uint8          `mapstructure:"max_retries"`
-	Device      model.Device   `mapstructure:"device"`
+	Backend    string         `mapstructure:"backend"`
+	Name       string         `mapstructure:"name"`
+	Language   model.Language `mapstructure:"language"`
+	SpeakerWav string         `mapstructure:"speaker_wav"`
+	// SpeakerWavs are more reference clips of the same voice, used together with SpeakerWav.
+	SpeakerWavs []string `mapstructure:"speaker_wavs"`
+	SpeakerIdx  string   `mapstructure:"speaker_idx"`
+	Concurrency uint8    `mapstructure:"concurrency"`
+	// AutoConcurrency is set when concurrency is "auto". The worker count is then tuned
+	// during the run, between MinConcurrency and MaxConcurrency.
+	AutoConcurrency bool          `mapstructure:"-"`
+	MinConcurrency  uint8         `mapstructure:"min_concurrency"`
+	MaxConcurrency  uint8         `mapstructure:"max_concurrency"`
+	TuneInterval    time.Duration `mapstructure:"tune_interval"`
+	MaxRetries      uint8         `mapstructure:"max_retries"`
+	// Timeout plus TimeoutPerChar for every character of the text is how long one attempt at a chunk may take.
+	// Both zero means no limit.
+	Timeout        time.Duration `mapstructure:"timeout"`
+	TimeoutPerChar time.Duration `mapstructure:"timeout_per_char"`
+	Device         model.Device  `mapstructure:"device"`
+	// Persistent keeps Concurrency engine processes running with the model loaded,
+	// instead of starting the engine for every chunk.
+	Persistent bool   `mapstructure:"persistent"`
+	Python     string `mapstructure:"python"`
+}
+
+// SpeakerClips returns every reference clip for voice cloning.
+func (m Model) SpeakerClips() []string {
+	var clips []string
+	if m.SpeakerWav != "" {
+		clips = append(clips, m.SpeakerWav)
+	}
+	return append(clips, m.SpeakerWavs...)
+}
+
+// SetSpeakerClips replaces the reference clips for voice cloning.
+func (m *Model) SetSpeakerClips(clips []string) {
+	m.SpeakerWav, m.SpeakerWavs = "", nil
+	if len(clips) > 0 {
+		m.SpeakerWav, m.SpeakerWavs = clips[0], clips[1:]
+	}
 }
 
 type Vocoder struct {
@@ -48,6 +189,415 @@ type Vocoder struct {
 	Language model.Language `mapstructure:"language"`
 }
 
+// HTTP configures the remote TTS backend, used when model.backend is "http".
+type HTTP struct {
+	URL     string            `mapstructure:"url"`
+	Schema  string            `mapstructure:"schema"`
+	APIKey  string            `mapstructure:"api_key"`
+	Headers map[string]string `mapstructure:"headers"`
+	// Model and Voice are sent as-is to the server.
+	// When Voice is empty, model.speaker_idx is used instead.
+	Model   string        `mapstructure:"model"`
+	Voice   string        `mapstructure:"voice"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// RateLimit is the maximum number of requests per second. Zero means unlimited.
+	RateLimit float64 `mapstructure:"rate_limit"`
+}
+
+// Plugin configures an external TTS engine process, used when model.backend is "plugin".
+type Plugin struct {
+	Command string            `mapstructure:"command"`
+	Args    []string          `mapstructure:"args"`
+	Env     map[string]string `mapstructure:"env"`
+	// Instances is the number of plugin processes to keep running.
+	Instances int `mapstructure:"instances"`
+	// HandshakeTimeout is how long to wait for the plugin to announce itself.
+	HandshakeTimeout time.Duration `mapstructure:"handshake_timeout"`
+}
+
+// Test configures the fake TTS backend that replaces the real one when test_mode is enabled.
+type Test struct {
+	// Signal is what the fake audio contains: "silence", "tone" or "pattern".
+	// "pattern" prefixes the tone with beeps that encode the chunk ID.
+	Signal string `mapstructure:"signal"`
+	// CharsPerSecond controls how long each clip is relative to its text.
+	CharsPerSecond float64 `mapstructure:"chars_per_second"`
+	SampleRate     int     `mapstructure:"sample_rate"`
+}
+
+// QA configures the checks run on every synthesized clip, and how often a bad clip is synthesized again.
+type QA struct {
+	Enabled bool `mapstructure:"enabled"`
+	Retries int  `mapstructure:"retries"`
+	// CharsPerSecond is the expected speaking rate, used to estimate how long a clip should be.
+	CharsPerSecond   float64 `mapstructure:"chars_per_second"`
+	MinDurationRatio float64 `mapstructure:"min_duration_ratio"`
+	MaxDurationRatio float64 `mapstructure:"max_duration_ratio"`
+	// Levels are in dBFS.
+	MinLevel     float64       `mapstructure:"min_level"`
+	SilenceLevel float64       `mapstructure:"silence_level"`
+	MaxSilence   time.Duration `mapstructure:"max_silence"`
+	// MaxClipping is the fraction of samples allowed to be clipped.
+	MaxClipping float64 `mapstructure:"max_clipping"`
+}
+
+// Speaker configures how reference clips for voice cloning are checked, and prepared when Preprocess is set.
+type Speaker struct {
+	Preprocess bool `mapstructure:"preprocess"`
+	// Strict stops the run when a clip fails validation, rather than warning about it.
+	Strict      bool          `mapstructure:"strict"`
+	MinDuration time.Duration `mapstructure:"min_duration"`
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+	// MinSampleRate is the lowest sample rate accepted, SampleRate is what clips are resampled to.
+	MinSampleRate int `mapstructure:"min_sample_rate"`
+	SampleRate    int `mapstructure:"sample_rate"`
+	// Levels are in dBFS, apart from TargetLoudness which is in LUFS.
+	MinLevel       float64 `mapstructure:"min_level"`
+	MaxNoiseFloor  float64 `mapstructure:"max_noise_floor"`
+	TrimLevel      float64 `mapstructure:"trim_level"`
+	TargetLoudness float64 `mapstructure:"target_loudness"`
+	// MaxClipping is the fraction of samples allowed to be clipped.
+	MaxClipping float64 `mapstructure:"max_clipping"`
+}
+
+// Joins configures how paragraph clips are joined into a chapter. When it's enabled, the silence and padding
+// at both ends of every clip are trimmed and its edges faded, so the pauses are the only gaps between clips.
+// Otherwise clips are joined as the engine made them.
+type Joins struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TrimLevel is in dBFS, quieter audio at the ends of a clip is trimmed.
+	TrimLevel float64 `mapstructure:"trim_level"`
+	// Fade is how long every clip fades in and out, which stops clicks where clips meet.
+	Fade time.Duration `mapstructure:"fade"`
+	// The pauses follow the chapter title, every paragraph and the end of the chapter.
+	TitlePause     time.Duration `mapstructure:"title_pause"`
+	ParagraphPause time.Duration `mapstructure:"paragraph_pause"`
+	ChapterPause   time.Duration `mapstructure:"chapter_pause"`
+}
+
+// Loudness configures the two-pass EBU R128 normalization that brings every chapter to the same loudness.
+type Loudness struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Target is the integrated loudness in LUFS.
+	Target float64 `mapstructure:"target"`
+	// TruePeak is the highest the true peak may reach, in dBTP.
+	TruePeak float64 `mapstructure:"true_peak"`
+	// Range is the loudness range in LU, only used for chapters too dynamic to normalize linearly.
+	Range float64 `mapstructure:"range"`
+}
+
+// Prosody changes how the narration sounds without changing what is said.
+type Prosody struct {
+	// Rate is the speaking speed, where 1 is the engine's normal speed and 0 means unset.
+	Rate float64 `mapstructure:"rate"`
+	// Pitch is in semitones, without changing the speed.
+	Pitch float64 `mapstructure:"pitch"`
+	// Gain is in dB.
+	Gain float64 `mapstructure:"gain"`
+}
+
+// Then returns p adjusted by o. Rates multiply, pitch and gain add up.
+func (p Prosody) Then(o Prosody) Prosody {
+	return Prosody{
+		Rate:  p.Speed() * o.Speed(),
+		Pitch: p.Pitch + o.Pitch,
+		Gain:  p.Gain + o.Gain,
+	}
+}
+
+// Without returns what is left of p once o has been applied.
+func (p Prosody) Without(o Prosody) Prosody {
+	return Prosody{
+		Rate:  p.Speed() / o.Speed(),
+		Pitch: p.Pitch - o.Pitch,
+		Gain:  p.Gain - o.Gain,
+	}
+}
+
+// Speed is the rate, with unset meaning normal speed.
+func (p Prosody) Speed() float64 {
+	if p.Rate <= 0 {
+		return 1
+	}
+	return p.Rate
+}
+
+// IsNeutral reports whether p leaves the audio as it is.
+func (p Prosody) IsNeutral() bool {
+	return p.Speed() == 1 && p.Pitch == 0 && p.Gain == 0
+}
+
+// Voice holds settings for one voice.
+type Voice struct {
+	// Name is the speaker name, or the speaker wav path as it appears in the model section.
+	Name    string  `mapstructure:"name"`
+	Prosody Prosody `mapstructure:",squash"`
+	// Filters post-process the chapters spoken with the voice, after the book's filters.
+	Filters []Filter `mapstructure:"filters"`
+}
+
+// Filter is an ffmpeg audio filter, such as an equalizer, compressor or noise gate, with its options.
+type Filter struct {
+	Name string `mapstructure:"name"`
+	// Params are the filter's options by name. Names are read in lower case.
+	Params map[string]any `mapstructure:"params"`
+}
+
+// filterName is what ffmpeg filter names look like.
+var filterName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// filterEscaper escapes a value once for the filter's options and again for the filtergraph.
+var filterEscaper = strings.NewReplacer(
+	`\`, `\\\\`,
+	`'`, `\\\'`,
+	`:`, `\\:`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`,`, `\,`,
+	`;`, `\;`,
+)
+
+// String is the filter as it's written in an ffmpeg filtergraph, for example "highpass=f=80".
+// Options are sorted by name, so the same filter always gives the same string.
+func (f Filter) String() string {
+	if len(f.Params) == 0 {
+		return f.Name
+	}
+
+	names := slices.Sorted(maps.Keys(f.Params))
+	params := make([]string, len(names))
+	for i, name := range names {
+		params[i] = name + "=" + filterEscaper.Replace(fmt.Sprint(f.Params[name]))
+	}
+	return f.Name + "=" + strings.Join(params, ":")
+}
+
+// VoiceFilters returns the book's filters followed by the voice's.
+func (c Config) VoiceFilters(voice string) []Filter {
+	filters := slices.Clone(c.Filters)
+	for _, v := range c.Voices {
+		if v.Name == voice {
+			filters = append(filters, v.Filters...)
+		}
+	}
+	return filters
+}
+
+// ChapterOverride changes the settings for the chapters it selects.
+// Chapters are selected by Index, Title and Section. When more than one is given, a chapter has to match all of them.
+type ChapterOverride struct {
+	// Index is the chapter number. The introduction is chapter 0.
+	Index *int `mapstructure:"index"`
+	// Title is a regular expression matched against the chapter's title in the book.
+	Title string `mapstructure:"title"`
+	// Section is a regular expression matched against the table of contents.
+	// It selects every chapter under a matching entry, as well as the entry's own chapter.
+	Section string `mapstructure:"section"`
+
+	// Backend, Model, SpeakerWav and SpeakerIdx change the voice. Empty fields keep the model's settings.
+	Backend    string         `mapstructure:"backend"`
+	Model      string         `mapstructure:"model"`
+	SpeakerWav string         `mapstructure:"speaker_wav"`
+	SpeakerIdx string         `mapstructure:"speaker_idx"`
+	Language   model.Language `mapstructure:"language"`
+	Prosody    Prosody        `mapstructure:",squash"`
+
+	title   *regexp.Regexp
+	section *regexp.Regexp
+}
+
+// ChapterInfo is what chapter overrides are matched against.
+type ChapterInfo struct {
+	Index int
+	Title string
+	// Sections are the titles of the table of contents entries the chapter sits under, ending with its own.
+	Sections []string
+}
+
+// compile checks the override and compiles its patterns.
+func (o *ChapterOverride) compile() error {
+	if o.Index == nil && o.Title == "" && o.Section == "" {
+		return fmt.Errorf("needs an index, title or section")
+	}
+
+	var err error
+	if o.Title != "" {
+		if o.title, err = regexp.Compile(o.Title); err != nil {
+			return fmt.Errorf("invalid title: %w", err)
+		}
+	}
+	if o.Section != "" {
+		if o.section, err = regexp.Compile(o.Section); err != nil {
+			return fmt.Errorf("invalid section: %w", err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the override applies to the chapter.
+func (o ChapterOverride) Matches(ch ChapterInfo) bool {
+	if o.Index == nil && o.Title == "" && o.Section == "" {
+		return false
+	}
+	if o.Index != nil && *o.Index != ch.Index {
+		return false
+	}
+	if o.Title != "" && !matchPattern(o.title, o.Title, ch.Title) {
+		return false
+	}
+	if o.Section != "" && !slices.ContainsFunc(ch.Sections, func(s string) bool {
+		return matchPattern(o.section, o.Section, s)
+	}) {
+		return false
+	}
+	return true
+}
+
+// Engine is the voice the override speaks with.
+func (o ChapterOverride) Engine() Engine {
+	return Engine{
+		Backend:    o.Backend,
+		Name:       o.Model,
+		SpeakerWav: o.SpeakerWav,
+		SpeakerIdx: o.SpeakerIdx,
+	}
+}
+
+// matchPattern matches s against re, compiling pattern if re hasn't been.
+func matchPattern(re *regexp.Regexp, pattern, s string) bool {
+	if re == nil {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false
+		}
+	}
+	return re.MatchString(s)
+}
+
+// Voice is the name of the voice the main engine speaks with.
+func (c Config) Voice() string {
+	if c.Model.Backend == "http" && c.HTTP.Voice != "" {
+		return c.HTTP.Voice
+	}
+	if clips := c.Model.SpeakerClips(); len(clips) > 0 {
+		return clips[0]
+	}
+	return c.Model.SpeakerIdx
+}
+
+// ChapterConfig returns a copy of c with the voice and language of every override that matches the chapter.
+// Later overrides win.
+func (c Config) ChapterConfig(ch ChapterInfo) *Config {
+	for _, o := range c.Chapters {
+		if o.Matches(ch) {
+			c = *c.WithOverride(o)
+		}
+	}
+	return &c
+}
+
+// WithOverride returns a copy of c with the override's voice and language.
+func (c Config) WithOverride(o ChapterOverride) *Config {
+	c = *c.WithEngine(o.Engine())
+	if o.Language != "" {
+		c.Model.Language = o.Language
+	}
+	return &c
+}
+
+// ChapterProsody combines the book, voice and chapter prosody for the chapter.
+func (c Config) ChapterProsody(ch ChapterInfo) Prosody {
+	voice := c.ChapterConfig(ch).Voice()
+
+	p := c.Prosody
+	for _, v := range c.Voices {
+		if v.Name == voice {
+			p = p.Then(v.Prosody)
+		}
+	}
+	for _, o := range c.Chapters {
+		if o.Matches(ch) {
+			p = p.Then(o.Prosody)
+		}
+	}
+	return p
+}
+
+// Steps for fallback.steps.
+const (
+	// FallbackSplit synthesizes the chunk again in smaller pieces.
+	FallbackSplit = "split"
+	// FallbackSecondary synthesizes the chunk with the secondary engine.
+	FallbackSecondary = "secondary"
+	// FallbackSilence replaces the chunk with silence.
+	FallbackSilence = "silence"
+)
+
+// Fallback configures what happens to a chunk that still fails after model.max_retries.
+// Steps are tried in order until one works. If none do, the run stops.
+type Fallback struct {
+	Steps     []string `mapstructure:"steps"`
+	Secondary Engine   `mapstructure:"secondary"`
+	// Silence is how long the silence that replaces a chunk is.
+	Silence time.Duration `mapstructure:"silence"`
+}
+
+// Engine selects the engine and voice to speak with.
+// Empty fields are taken from the model section.
+type Engine struct {
+	Backend    string `mapstructure:"backend"`
+	Name       string `mapstructure:"name"`
+	SpeakerWav string `mapstructure:"speaker_wav"`
+	SpeakerIdx string `mapstructure:"speaker_idx"`
+}
+
+// IsSet reports whether anything differs from the main engine.
+func (e Engine) IsSet() bool {
+	return e != Engine{}
+}
+
+// WithEngine returns a copy of c that speaks with e.
+func (c Config) WithEngine(e Engine) *Config {
+	if e.Backend != "" {
+		c.Model.Backend = e.Backend
+	}
+	if e.Name != "" {
+		c.Model.Name = e.Name
+		if c.Model.Backend == "http" {
+			c.HTTP.Model = e.Name
+		}
+	}
+	if e.SpeakerWav != "" || e.SpeakerIdx != "" {
+		c.Model.SetSpeakerClips(nil)
+		c.Model.SpeakerWav = e.SpeakerWav
+		c.Model.SpeakerIdx = e.SpeakerIdx
+		// The HTTP backend can't clone a voice from a clip, so it keeps its own voice for one
+		if e.SpeakerIdx != "" {
+			c.HTTP.Voice = e.SpeakerIdx
+		}
+	}
+	return &c
+}
+
+// checkEngine checks that e only sets what its backend uses.
+func (c Config) checkEngine(e Engine) error {
+	// A plugin speaks with whatever model its command loads
+	if e.Name != "" && c.WithEngine(e).Model.Backend == "plugin" {
+		return fmt.Errorf("the plugin backend doesn't take a model name")
+	}
+	return nil
+}
+
+// SecondaryConfig returns a copy of c that uses the secondary engine.
+func (c Config) SecondaryConfig() *Config {
+	c = *c.WithEngine(c.Fallback.Secondary)
+	// Only chunks the main engine gave up on get here, so one process is enough.
+	c.Model.Concurrency = 1
+	c.Model.AutoConcurrency = false
+	c.Model.MaxConcurrency = 1
+	return &c
+}
+
 const defaultConcurrency = 4
 
 func Load() (*Config, error) {
@@ -60,45 +610,269 @@ func Load() (*Config, error) {
 	}
 
 	setDefaults()
+
+	// "auto" isn't a number, so start from the default and tune from there
+	autoConcurrency := strings.EqualFold(viper.GetString("model.concurrency"), "auto")
+	if autoConcurrency {
+		viper.Set("model.concurrency", defaultConcurrency)
+	}
+
 	config := &Config{}
 	if err := viper.Unmarshal(config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	// check if the end of output doesn't contain a slash, otherwise add it
-	if config.Output.Path != "" && config.Output.Path[len(config.Output.Path)-1] != '/' {
-		config.Output.Path += "/"
+	outputs, err := loadOutputs()
+	if err != nil {
+		return nil, err
 	}
+	config.Outputs = outputs
 
 	// we can only allow positive numbers for concurrency
 	if config.Model.Concurrency < 1 {
 		config.Model.Concurrency = defaultConcurrency
 	}
 
+	config.Model.AutoConcurrency = autoConcurrency
+	if config.Model.MinConcurrency < 1 {
+		config.Model.MinConcurrency = 1
+	}
+	if config.Model.MaxConcurrency < config.Model.MinConcurrency {
+		config.Model.MaxConcurrency = config.Model.MinConcurrency
+	}
+
+	if config.Prosody.Rate < 0 {
+		return nil, fmt.Errorf("prosody.rate must be positive")
+	}
+	for _, v := range config.Voices {
+		if v.Prosody.Rate < 0 {
+			return nil, fmt.Errorf("rate for voice %q must be positive", v.Name)
+		}
+		for _, f := range v.Filters {
+			if !filterName.MatchString(f.Name) {
+				return nil, fmt.Errorf("invalid filter name %q for voice %q", f.Name, v.Name)
+			}
+		}
+	}
+	for _, f := range config.Filters {
+		if !filterName.MatchString(f.Name) {
+			return nil, fmt.Errorf("invalid filter name %q", f.Name)
+		}
+	}
+	for i := range config.Chapters {
+		o := &config.Chapters[i]
+		if err := o.compile(); err != nil {
+			return nil, fmt.Errorf("chapters[%d]: %w", i, err)
+		}
+		if o.Prosody.Rate < 0 {
+			return nil, fmt.Errorf("chapters[%d] rate must be positive", i)
+		}
+		if err := config.checkEngine(o.Engine()); err != nil {
+			return nil, fmt.Errorf("chapters[%d]: %w", i, err)
+		}
+	}
+
+	if j := config.Joins; j.Fade < 0 || j.TitlePause < 0 || j.ParagraphPause < 0 || j.ChapterPause < 0 {
+		return nil, fmt.Errorf("joins durations can't be negative")
+	}
+
+	// These are the ranges ffmpeg's loudnorm filter accepts
+	if l := config.Loudness; l.Enabled {
+		switch {
+		case l.Target < -70 || l.Target > -5:
+			return nil, fmt.Errorf("loudness.target must be between -70 and -5 LUFS")
+		case l.TruePeak < -9 || l.TruePeak > 0:
+			return nil, fmt.Errorf("loudness.true_peak must be between -9 and 0 dBTP")
+		case l.Range < 1 || l.Range > 50:
+			return nil, fmt.Errorf("loudness.range must be between 1 and 50 LU")
+		}
+	}
+
+	if err := config.checkEngine(config.Fallback.Secondary); err != nil {
+		return nil, fmt.Errorf("fallback.secondary: %w", err)
+	}
+
+	for _, step := range config.Fallback.Steps {
+		switch step {
+		case FallbackSplit, FallbackSilence:
+		case FallbackSecondary:
+			if !config.Fallback.Secondary.IsSet() {
+				return nil, fmt.Errorf("fallback step %q needs fallback.secondary to be configured", step)
+			}
+		default:
+			return nil, fmt.Errorf("unknown fallback step %q", step)
+		}
+	}
+
 	return config, nil
 }
 
 func setDefaults() {
 	// Set default values if not present in config
-	viper.SetDefault("output.path", "./.dist/")
-	viper.SetDefault("output.format", "m4b")
 
 	// Model Defaults
-	viper.SetDefault("model.name", "tts_models/multilingual/multi-dataset/xtts_v2")
+	viper.SetDefault("model.backend", "coqui")
+	viper.SetDefault("model.name", tts.PresetVITSVCTK.Name())
 	viper.SetDefault("model.speaker_idx", "p286")
 	viper.SetDefault("model.language", model.English)
 	viper.SetDefault("model.concurrency", defaultConcurrency)
+	viper.SetDefault("model.min_concurrency", 1)
+	viper.SetDefault("model.max_concurrency", min(runtime.NumCPU(), 255))
+	viper.SetDefault("model.tune_interval", "30s")
 	viper.SetDefault("model.max_retries", 5)
+	viper.SetDefault("model.timeout", "2m")
+	viper.SetDefault("model.timeout_per_char", "500ms")
 	viper.SetDefault("model.device", model.DeviceCPU)
+	viper.SetDefault("model.python", "python3")
 
 	viper.SetDefault("vocoder.name", vocoder.PresetHifiganV2Blizzard2013.Name())
 	viper.SetDefault("vocoder.language", model.English)
+
+	// HTTP backend defaults
+	viper.SetDefault("http.schema", "openai")
+	viper.SetDefault("http.model", "tts-1")
+	viper.SetDefault("http.timeout", "60s")
+
+	// Plugin backend defaults
+	viper.SetDefault("plugin.handshake_timeout", "2m")
+	viper.SetDefault("plugin.instances", 1)
+
+	// Test mode defaults
+	viper.SetDefault("test.signal", "tone")
+	viper.SetDefault("test.chars_per_second", 15)
+	viper.SetDefault("test.sample_rate", 22050)
+
+	// QA defaults
+	viper.SetDefault("qa.enabled", false)
+	viper.SetDefault("qa.retries", 2)
+	viper.SetDefault("qa.chars_per_second", 14)
+	viper.SetDefault("qa.min_duration_ratio", 0.35)
+	viper.SetDefault("qa.max_duration_ratio", 2.5)
+	viper.SetDefault("qa.min_level", -40)
+	viper.SetDefault("qa.silence_level", -50)
+	viper.SetDefault("qa.max_silence", "2s")
+	viper.SetDefault("qa.max_clipping", 0.001)
+
+	// Speaker reference clip defaults
+	viper.SetDefault("speaker.preprocess", false)
+	viper.SetDefault("speaker.min_duration", "3s")
+	viper.SetDefault("speaker.max_duration", "30s")
+	viper.SetDefault("speaker.min_sample_rate", 16000)
+	viper.SetDefault("speaker.sample_rate", 22050)
+	viper.SetDefault("speaker.min_level", -35)
+	viper.SetDefault("speaker.max_noise_floor", -45)
+	viper.SetDefault("speaker.trim_level", -50)
+	viper.SetDefault("speaker.target_loudness", -20)
+	viper.SetDefault("speaker.max_clipping", 0.001)
+
+	// Join defaults
+	viper.SetDefault("joins.enabled", false)
+	viper.SetDefault("joins.trim_level", -50)
+	viper.SetDefault("joins.fade", "10ms")
+	viper.SetDefault("joins.title_pause", "1s")
+	viper.SetDefault("joins.paragraph_pause", "600ms")
+	viper.SetDefault("joins.chapter_pause", "2s")
+
+	// Loudness defaults
+	viper.SetDefault("loudness.enabled", false)
+	viper.SetDefault("loudness.target", -18)
+	viper.SetDefault("loudness.true_peak", -3)
+	viper.SetDefault("loudness.range", 11)
+
+	// Fallback defaults
+	viper.SetDefault("fallback.steps", []string{FallbackSplit, FallbackSilence})
+	viper.SetDefault("fallback.silence", "1s")
+}
+
+// defaultOutput is what a target is before the settings from the output section.
+func defaultOutput() Output {
+	return Output{
+		Path:     "./.dist/",
+		Format:   FormatM4B,
+		Template: "{{.Track}} - {{.Title}}",
+	}
+}
+
+// loadOutputs reads the output section, which is either a single target or a list of them.
+func loadOutputs() ([]Output, error) {
+	var sections []any
+	switch v := viper.Get("output").(type) {
+	case nil:
+		sections = []any{map[string]any{}}
+	case []any:
+		sections = v
+	default:
+		sections = []any{v}
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("output needs at least one target")
+	}
+
+	outputs := make([]Output, len(sections))
+	for i, section := range sections {
+		o := defaultOutput()
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:           &o,
+			WeaklyTypedInput: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := decoder.Decode(section); err != nil {
+			return nil, fmt.Errorf("output %d: %w", i+1, err)
+		}
+
+		// check if the end of output doesn't contain a slash, otherwise add it
+		if o.Path != "" && o.Path[len(o.Path)-1] != '/' {
+			o.Path += "/"
+		}
+
+		o.Format = strings.ToLower(o.Format)
+		if !slices.Contains(Formats, o.Format) {
+			return nil, fmt.Errorf("output %d: unknown format %q, supported formats are %s", i+1, o.Format, strings.Join(Formats, ", "))
+		}
+		if o.Encoder, err = o.Encoder.withPreset(); err != nil {
+			return nil, fmt.Errorf("output %d: %w", i+1, err)
+		}
+		switch o.Encoder.Channels {
+		case "", "mono", "stereo":
+		default:
+			return nil, fmt.Errorf("output %d: channels must be mono or stereo", i+1)
+		}
+		switch o.Encoder.CoverCodec {
+		case "", "png", "mjpeg":
+		default:
+			return nil, fmt.Errorf("output %d: cover_codec must be png or mjpeg", i+1)
+		}
+
+		if _, err := template.New("filename").Parse(o.Filename); err != nil {
+			return nil, fmt.Errorf("output %d: invalid filename: %w", i+1, err)
+		}
+		if _, err := template.New("template").Parse(o.Template); err != nil {
+			return nil, fmt.Errorf("output %d: invalid template: %w", i+1, err)
+		}
+
+		outputs[i] = o
+	}
+
+	return outputs, nil
 }
 
+// OutputFileName is the name of the audiobook file, or of the directory of chapter files when Split is set.
 func (o Output) OutputFileName() string {
-	return o.Filename + ".m4b"
+	if o.Split {
+		return o.Filename
+	}
+	return o.Filename + "." + o.Format
 }
 
 func (o Output) FullPath() string {
 	return o.Path + o.OutputFileName()
 }
+
+// Redacted returns a copy of the config without credentials, which is safe to write to disk.
+func (c Config) Redacted() Config {
+	c.HTTP.APIKey = ""
+	c.HTTP.Headers = nil
+	return c
+}
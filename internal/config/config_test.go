@@ -0,0 +1,450 @@
+package config
+
+import (
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// load writes contents to config.json in a new working directory and loads it.
+func load(t *testing.T, contents string) (*Config, error) {
+	t.Helper()
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("config.json", []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load()
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := load(t, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Model.Name != "tts_models/en/vctk/vits" {
+		t.Errorf("model.name = %q, want the VCTK model", c.Model.Name)
+	}
+	if c.Model.Backend != "coqui" || c.Model.Persistent {
+		t.Errorf("backend = %q, persistent %v, want coqui run per chunk", c.Model.Backend, c.Model.Persistent)
+	}
+	if c.Model.Python != "python3" {
+		t.Errorf("model.python = %q, want python3", c.Model.Python)
+	}
+	if c.Plugin.Instances != 1 || c.Plugin.HandshakeTimeout != 2*time.Minute {
+		t.Errorf("plugin = %+v, want one instance and a 2m handshake", c.Plugin)
+	}
+	if len(c.Outputs) != 1 || c.Outputs[0].Format != FormatM4B || c.Outputs[0].Path != "./.dist/" {
+		t.Errorf("outputs = %+v, want the default one", c.Outputs)
+	}
+	if c.QA.Enabled || c.QA.Retries != 2 {
+		t.Errorf("qa = %+v, want it off with 2 retries when enabled", c.QA)
+	}
+	if c.Model.Timeout != 2*time.Minute || c.Model.TimeoutPerChar != 500*time.Millisecond {
+		t.Errorf("timeout = %v + %v per character, want 2m + 500ms", c.Model.Timeout, c.Model.TimeoutPerChar)
+	}
+	if c.Speaker.Preprocess || c.Speaker.Strict || c.Speaker.MinSampleRate != 16000 || c.Speaker.MaxDuration != 30*time.Second {
+		t.Errorf("speaker = %+v, want checks only, against the defaults", c.Speaker)
+	}
+	if !slices.Equal(c.Fallback.Steps, []string{FallbackSplit, FallbackSilence}) || c.Fallback.Silence != time.Second {
+		t.Errorf("fallback = %+v, want split then a second of silence", c.Fallback)
+	}
+	if c.Joins.Enabled || c.Joins.Fade != 10*time.Millisecond || c.Joins.ChapterPause != 2*time.Second {
+		t.Errorf("joins = %+v, want them off with a 10ms fade and 2s between chapters", c.Joins)
+	}
+	if want := (Loudness{Target: -18, TruePeak: -3, Range: 11}); c.Loudness != want {
+		t.Errorf("loudness = %+v, want %+v", c.Loudness, want)
+	}
+}
+
+func TestLoadPersistent(t *testing.T) {
+	c, err := load(t, `{"model": {"persistent": true, "python": "/opt/tts/bin/python", "concurrency": 3}, "plugin": {"instances": 4}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Model.Persistent || c.Model.Python != "/opt/tts/bin/python" || c.Model.Concurrency != 3 {
+		t.Errorf("model = %+v", c.Model)
+	}
+	if c.Plugin.Instances != 4 {
+		t.Errorf("plugin.instances = %d, want 4", c.Plugin.Instances)
+	}
+}
+
+func TestLoadDisabledLoudness(t *testing.T) {
+	// The loudness targets are only checked when normalization is on
+	if _, err := load(t, `{"loudness": {"target": -2}}`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+
+	if _, err := Load(); err == nil {
+		t.Error("expected an error without a config file")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Config{}
+	c.HTTP.APIKey = "secret"
+	c.HTTP.Headers = map[string]string{"Authorization": "Bearer secret"}
+	c.HTTP.Model = "tts-1"
+
+	r := c.Redacted()
+	if r.HTTP.APIKey != "" || r.HTTP.Headers != nil {
+		t.Errorf("redacted http = %+v, want no credentials", r.HTTP)
+	}
+	if r.HTTP.Model != "tts-1" {
+		t.Error("redacting removed other settings")
+	}
+	if c.HTTP.APIKey != "secret" {
+		t.Error("redacting changed the original config")
+	}
+}
+
+func TestLoadAutoConcurrency(t *testing.T) {
+	c, err := load(t, `{"model": {"concurrency": "auto", "min_concurrency": 3, "max_concurrency": 2}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Model.AutoConcurrency || c.Model.Concurrency != defaultConcurrency {
+		t.Errorf("concurrency = %d, auto %v, want %d, auto", c.Model.Concurrency, c.Model.AutoConcurrency, defaultConcurrency)
+	}
+	if c.Model.MaxConcurrency != 3 {
+		t.Errorf("max_concurrency = %d, want it raised to min_concurrency", c.Model.MaxConcurrency)
+	}
+
+	c, err = load(t, `{"model": {"concurrency": 3}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Model.AutoConcurrency || c.Model.Concurrency != 3 {
+		t.Errorf("concurrency = %d, auto %v, want a fixed 3", c.Model.Concurrency, c.Model.AutoConcurrency)
+	}
+	if c.Model.MinConcurrency != 1 || c.Model.TuneInterval != 30*time.Second {
+		t.Errorf("min_concurrency = %d, tune_interval %v, want the defaults", c.Model.MinConcurrency, c.Model.TuneInterval)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"invalid json", `{"model": `, "error reading config file"},
+		{"negative rate", `{"prosody": {"rate": -1}}`, "prosody.rate must be positive"},
+		{"negative voice rate", `{"voices": [{"name": "narrator", "rate": -0.5}]}`, `rate for voice "narrator"`},
+		{"override without match", `{"chapters": [{"rate": 1.1}]}`, "chapters[0]: needs an index, title or section"},
+		{"invalid override title", `{"chapters": [{"title": "/(/"}]}`, "chapters[0]: invalid title"},
+		{"invalid override section", `{"chapters": [{"section": "[a-"}]}`, "chapters[0]: invalid section"},
+		{"negative override rate", `{"chapters": [{"index": 2, "rate": -1}]}`, "chapters[0] rate must be positive"},
+		{"unknown format", `{"output": {"format": "ogg"}}`, `output 1: unknown format "ogg"`},
+		{"invalid template", `{"output": [{}, {"template": "{{.Track"}]}`, "output 2: invalid template"},
+		{"invalid filename", `{"output": {"filename": "{{.Book"}}`, "output 1: invalid filename"},
+		{"unknown preset", `{"output": {"encoder": {"preset": "music"}}}`, `output 1: unknown encoder preset "music"`},
+		{"invalid channels", `{"output": {"encoder": {"channels": "5.1"}}}`, "output 1: channels must be mono or stereo"},
+		{"invalid cover codec", `{"output": {"encoder": {"cover_codec": "webp"}}}`, "output 1: cover_codec must be png or mjpeg"},
+		{"invalid filter", `{"filters": [{"name": "volume;rm"}]}`, `invalid filter name "volume;rm"`},
+		{"invalid voice filter", `{"voices": [{"name": "narrator", "filters": [{"name": "Bass"}]}]}`, `invalid filter name "Bass" for voice "narrator"`},
+		{"negative pause", `{"joins": {"paragraph_pause": "-1s"}}`, "joins durations can't be negative"},
+		{"loudness target", `{"loudness": {"enabled": true, "target": -2}}`, "loudness.target"},
+		{"loudness true peak", `{"loudness": {"enabled": true, "true_peak": 1}}`, "loudness.true_peak"},
+		{"loudness range", `{"loudness": {"enabled": true, "range": 60}}`, "loudness.range"},
+		{"no outputs", `{"output": []}`, "output needs at least one target"},
+		{"unknown fallback", `{"fallback": {"steps": ["retry"]}}`, `unknown fallback step "retry"`},
+		{"secondary without engine", `{"fallback": {"steps": ["secondary"]}}`, "needs fallback.secondary"},
+		{"plugin secondary model", `{"fallback": {"secondary": {"backend": "plugin", "name": "vits"}}}`, "fallback.secondary: the plugin backend doesn't take a model name"},
+		{"plugin override model", `{"model": {"backend": "plugin"}, "chapters": [{"index": 1, "model": "vits"}]}`, "chapters[0]: the plugin backend doesn't take a model name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.config)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSecondaryConfig(t *testing.T) {
+	c, err := load(t, `{"model": {"backend": "http", "speaker_idx": "p225", "concurrency": "auto"},
+		"fallback": {"steps": ["secondary"], "secondary": {"backend": "coqui", "speaker_wav": "voice.wav"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := c.SecondaryConfig()
+	if s.Model.Backend != "coqui" || s.Model.SpeakerWav != "voice.wav" || s.Model.SpeakerIdx != "" {
+		t.Errorf("secondary model = %+v, want coqui with its own speaker", s.Model)
+	}
+	if s.Model.Concurrency != 1 || s.Model.AutoConcurrency || s.Model.MaxConcurrency != 1 {
+		t.Errorf("secondary concurrency = %d, auto %v, want a single process", s.Model.Concurrency, s.Model.AutoConcurrency)
+	}
+	if c.Model.Backend != "http" || !c.Model.AutoConcurrency {
+		t.Error("SecondaryConfig changed the main config")
+	}
+}
+
+func TestProsody(t *testing.T) {
+	book := Prosody{Rate: 1.2, Gain: -2}
+	voice := Prosody{Rate: 0.5, Pitch: 1}
+
+	got := book.Then(voice)
+	if got != (Prosody{Rate: 0.6, Pitch: 1, Gain: -2}) {
+		t.Errorf("Then = %+v", got)
+	}
+	if back := got.Without(voice); math.Abs(back.Rate-1.2) > 1e-9 || back.Pitch != 0 || back.Gain != -2 {
+		t.Errorf("Without = %+v, want the book prosody back", back)
+	}
+
+	// An unset rate is normal speed
+	if !(Prosody{}).IsNeutral() || (Prosody{}).Speed() != 1 {
+		t.Error("zero prosody isn't neutral")
+	}
+	if (Prosody{Rate: 1, Gain: 0.5}).IsNeutral() {
+		t.Error("a gain change is neutral")
+	}
+}
+
+func TestChapterProsody(t *testing.T) {
+	c, err := load(t, `{"model": {"speaker_idx": "p225"}, "prosody": {"rate": 1.1},
+		"voices": [{"name": "p225", "gain": 3}, {"name": "p226", "gain": -3}],
+		"chapters": [{"index": 2, "rate": 2, "pitch": -1}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.ChapterProsody(ChapterInfo{Index: 1}); got != (Prosody{Rate: 1.1, Gain: 3}) {
+		t.Errorf("chapter 1 = %+v, want the book and voice prosody", got)
+	}
+	if got := c.ChapterProsody(ChapterInfo{Index: 2}); got != (Prosody{Rate: 2.2, Pitch: -1, Gain: 3}) {
+		t.Errorf("chapter 2 = %+v, want the chapter override on top", got)
+	}
+}
+
+func TestSpeakerClips(t *testing.T) {
+	m := Model{SpeakerWav: "a.wav", SpeakerWavs: []string{"b.wav", "c.wav"}}
+	if got := m.SpeakerClips(); !slices.Equal(got, []string{"a.wav", "b.wav", "c.wav"}) {
+		t.Errorf("SpeakerClips = %q", got)
+	}
+
+	m.SetSpeakerClips([]string{"x.wav", "y.wav"})
+	if m.SpeakerWav != "x.wav" || !slices.Equal(m.SpeakerWavs, []string{"y.wav"}) {
+		t.Errorf("after SetSpeakerClips, speaker_wav = %q, speaker_wavs = %q", m.SpeakerWav, m.SpeakerWavs)
+	}
+
+	m.SetSpeakerClips(nil)
+	if len(m.SpeakerClips()) != 0 {
+		t.Errorf("clips = %q, want none", m.SpeakerClips())
+	}
+}
+
+func TestChapterOverrideMatches(t *testing.T) {
+	c, err := load(t, `{"chapters": [
+		{"index": 3},
+		{"title": "^Interlude"},
+		{"section": "Part Two"},
+		{"index": 4, "title": "Letter"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		chapter ChapterInfo
+		want    []bool
+	}{
+		{ChapterInfo{Index: 3, Title: "Chapter 3"}, []bool{true, false, false, false}},
+		{ChapterInfo{Index: 5, Title: "Interlude: Rain"}, []bool{false, true, false, false}},
+		{ChapterInfo{Index: 6, Title: "Chapter 6", Sections: []string{"Part Two", "Chapter 6"}}, []bool{false, false, true, false}},
+		// Every selector has to match
+		{ChapterInfo{Index: 4, Title: "A Letter Home"}, []bool{false, false, false, true}},
+		{ChapterInfo{Index: 7, Title: "A Letter Home"}, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		for i, o := range c.Chapters {
+			if got := o.Matches(tt.chapter); got != tt.want[i] {
+				t.Errorf("chapters[%d] matches %+v = %v, want %v", i, tt.chapter, got, tt.want[i])
+			}
+		}
+	}
+
+	if (ChapterOverride{}).Matches(ChapterInfo{}) {
+		t.Error("an override without selectors matches")
+	}
+}
+
+func TestChapterConfig(t *testing.T) {
+	c, err := load(t, `{"model": {"speaker_idx": "p225", "language": "en"}, "voices": [{"name": "p226", "rate": 0.5}], "chapters": [
+		{"section": "Letters", "speaker_idx": "p226", "language": "fr"},
+		{"title": "Postscript", "speaker_wav": "/voices/writer.wav", "rate": 2}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letter := ChapterInfo{Index: 2, Title: "First Letter", Sections: []string{"Letters", "First Letter"}}
+	got := c.ChapterConfig(letter)
+	if got.Voice() != "p226" || got.Model.Language != "fr" {
+		t.Errorf("letter voice = %q in %q, want p226 in fr", got.Voice(), got.Model.Language)
+	}
+	// The voice's prosody follows it to the chapter
+	if p := c.ChapterProsody(letter); p.Speed() != 0.5 {
+		t.Errorf("letter rate = %g, want p226's 0.5", p.Speed())
+	}
+
+	// Later overrides win, earlier ones still set what the later ones don't
+	ps := ChapterInfo{Index: 3, Title: "Postscript", Sections: []string{"Letters", "Postscript"}}
+	got = c.ChapterConfig(ps)
+	if got.Voice() != "/voices/writer.wav" || got.Model.SpeakerIdx != "" || got.Model.Language != "fr" {
+		t.Errorf("postscript model = %+v, want the cloned voice in fr", got.Model)
+	}
+	if p := c.ChapterProsody(ps); p.Speed() != 2 {
+		t.Errorf("postscript rate = %g, want 2", p.Speed())
+	}
+
+	if got := c.ChapterConfig(ChapterInfo{Index: 1, Title: "Chapter 1"}); got.Voice() != "p225" || got.Model.Language != "en" {
+		t.Errorf("chapter 1 voice = %q in %q, want the model's", got.Voice(), got.Model.Language)
+	}
+}
+
+func TestWithEngine(t *testing.T) {
+	c := Config{}
+	c.Model.Backend = "coqui"
+	c.Model.SpeakerWav = "/voices/narrator.wav"
+	c.Model.SpeakerWavs = []string{"/voices/narrator-2.wav"}
+
+	got := c.WithEngine(Engine{Backend: "http", SpeakerIdx: "alloy"})
+	if got.Model.Backend != "http" || got.Voice() != "alloy" || len(got.Model.SpeakerClips()) != 0 {
+		t.Errorf("model = %+v, want http with alloy", got.Model)
+	}
+
+	// The HTTP backend takes its model from the http section
+	got = c.WithEngine(Engine{Backend: "http", Name: "tts-1-hd"})
+	if got.HTTP.Model != "tts-1-hd" {
+		t.Errorf("http.model = %q, want tts-1-hd", got.HTTP.Model)
+	}
+	if got := c.WithEngine(Engine{Name: "tts_models/en/ljspeech/vits"}); got.Model.Name != "tts_models/en/ljspeech/vits" || got.HTTP.Model != "" {
+		t.Errorf("model = %+v, http.model %q, want the coqui model set", got.Model, got.HTTP.Model)
+	}
+
+	// An empty engine changes nothing
+	if got := c.WithEngine(Engine{}); got.Model.Backend != "coqui" || len(got.Model.SpeakerClips()) != 2 {
+		t.Errorf("model = %+v, want it unchanged", got.Model)
+	}
+}
+
+func TestWithOverride(t *testing.T) {
+	c := Config{}
+	c.Model.SpeakerIdx = "p225"
+	c.Model.Language = "en"
+
+	got := c.WithOverride(ChapterOverride{SpeakerIdx: "p226", Language: "fr"})
+	if got.Voice() != "p226" || got.Model.Language != "fr" {
+		t.Errorf("model = %+v, want p226 in fr", got.Model)
+	}
+	if got := c.WithOverride(ChapterOverride{SpeakerIdx: "p226"}); got.Model.Language != "en" {
+		t.Errorf("language = %q, want the book's", got.Model.Language)
+	}
+}
+
+func TestLoadOutputs(t *testing.T) {
+	c, err := load(t, `{"output": [
+		{"filename": "book", "format": "MP3", "path": "out", "encoder": {"bitrate": "96k"}},
+		{"filename": "book", "split": true}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Outputs) != 2 {
+		t.Fatalf("got %d outputs, want 2", len(c.Outputs))
+	}
+
+	mp3 := c.Outputs[0]
+	if mp3.Format != FormatMP3 || mp3.FullPath() != "out/book.mp3" || mp3.Encoder.Bitrate != "96k" {
+		t.Errorf("output 1 = %+v, want out/book.mp3 at 96k", mp3)
+	}
+
+	split := c.Outputs[1]
+	if split.Format != FormatM4B || split.FullPath() != "./.dist/book" {
+		t.Errorf("output 2 = %+v, want a ./.dist/book directory", split)
+	}
+	if split.Template != "{{.Track}} - {{.Title}}" {
+		t.Errorf("template = %q", split.Template)
+	}
+}
+
+func TestEncoderPreset(t *testing.T) {
+	c, err := load(t, `{"output": {"encoder": {"preset": "speech-low", "bitrate": "48k", "cover_codec": "png"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Encoder{
+		Preset:     "speech-low",
+		Bitrate:    "48k",
+		SampleRate: 22050,
+		Channels:   "mono",
+		CoverCodec: "png",
+		CoverSize:  600,
+	}
+	if got := c.Outputs[0].Encoder; got != want {
+		t.Errorf("encoder = %+v, want %+v", got, want)
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	for _, tt := range []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{Name: "anull"}, "anull"},
+		{Filter{Name: "highpass", Params: map[string]any{"f": 80, "poles": 2}}, "highpass=f=80:poles=2"},
+		{Filter{Name: "equalizer", Params: map[string]any{"g": -2.5}}, "equalizer=g=-2.5"},
+		// Values are escaped for the filter, then for the filtergraph
+		{Filter{Name: "afftdn", Params: map[string]any{"bn": "1:2,3"}}, `afftdn=bn=1\\:2\,3`},
+	} {
+		if got := tt.filter.String(); got != tt.want {
+			t.Errorf("%+v = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestVoiceFilters(t *testing.T) {
+	c, err := load(t, `{
+		"filters": [{"name": "highpass", "params": {"F": 80}}],
+		"voices": [{"name": "p226", "filters": [{"name": "acompressor"}]}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range c.VoiceFilters("p226") {
+		got = append(got, f.String())
+	}
+	if want := []string{"highpass=f=80", "acompressor"}; !slices.Equal(got, want) {
+		t.Errorf("p226 filters = %q, want %q", got, want)
+	}
+	if got := c.VoiceFilters("p225"); len(got) != 1 {
+		t.Errorf("p225 filters = %+v, want only the book's", got)
+	}
+}
@@ -57,6 +57,8 @@ type EpubChapter struct {
 	Content string
 
 	Path string
+	// Voice is the voice the chapter was read with.
+	Voice string
 }
 
 func NewChapter(id, title, content string) (*EpubChapter, error) {
@@ -17,6 +17,7 @@ type EpubReader interface {
 	GetCoverImage() string
 	GetChapter(index int) (*EpubReaderChapter, error)
 	GetChapters() ([]*EpubReaderChapter, error)
+	GetToc() ([]*TocEntry, error)
 	Close() error
 }
 
@@ -26,7 +27,7 @@ type EpubReaderChapter struct {
 	Title string
 	// Content is the raw, unedited HTML chapter content.
 	Content string
-
+	// Path is the chapter's file, relative to the package document.
 	Path string
 }
 
@@ -99,6 +100,7 @@ func (g *GoEpubReaderService) GetChapter(index int) (*EpubReaderChapter, error)
 		Id:      item.ID,
 		Title:   title,
 		Content: contentStr,
+		Path:    resolveHref("", item.HREF),
 	}, nil
 }
 
@@ -0,0 +1,168 @@
+package epubreader
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	epubReader "github.com/taylorskalyo/goreader/epub"
+)
+
+const mediaTypeNCX = "application/x-dtbncx+xml"
+
+// TocEntry is an entry in the book's table of contents.
+type TocEntry struct {
+	Title string
+	// Path is the file the entry points to, relative to the package document like chapter paths.
+	Path     string
+	Children []*TocEntry
+}
+
+// Sections maps each chapter path in the table of contents to the titles of the entries it sits under,
+// outermost first and ending with its own entry.
+func Sections(toc []*TocEntry) map[string][]string {
+	sections := map[string][]string{}
+
+	var walk func(entries []*TocEntry, parents []string)
+	walk = func(entries []*TocEntry, parents []string) {
+		for _, e := range entries {
+			titles := append(parents[:len(parents):len(parents)], e.Title)
+			// A file can appear more than once, the first entry is the one that introduces it.
+			if _, ok := sections[e.Path]; !ok && e.Path != "" {
+				sections[e.Path] = titles
+			}
+			walk(e.Children, titles)
+		}
+	}
+	walk(toc, nil)
+
+	return sections
+}
+
+// GetToc reads the table of contents from the EPUB 2 NCX file, or the EPUB 3 navigation document.
+// A book without either has an empty table of contents.
+func (g *GoEpubReaderService) GetToc() ([]*TocEntry, error) {
+	for _, item := range g.r.Manifest.Items {
+		if item.MediaType == mediaTypeNCX {
+			return readNCX(item)
+		}
+	}
+
+	for _, item := range g.r.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		toc, ok, err := readNav(item)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return toc, nil
+		}
+	}
+
+	return nil, nil
+}
+
+type ncxPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Points []ncxPoint `xml:"navPoint"`
+}
+
+func readNCX(item epubReader.Item) ([]*TocEntry, error) {
+	content, err := readItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var ncx struct {
+		Points []ncxPoint `xml:"navMap>navPoint"`
+	}
+	if err := xml.Unmarshal(content, &ncx); err != nil {
+		return nil, fmt.Errorf("failed to parse table of contents %s: %w", item.HREF, err)
+	}
+
+	var convert func(points []ncxPoint) []*TocEntry
+	convert = func(points []ncxPoint) []*TocEntry {
+		entries := make([]*TocEntry, 0, len(points))
+		for _, p := range points {
+			entries = append(entries, &TocEntry{
+				Title:    strings.TrimSpace(p.Label),
+				Path:     resolveHref(item.HREF, p.Content.Src),
+				Children: convert(p.Points),
+			})
+		}
+		return entries
+	}
+
+	return convert(ncx.Points), nil
+}
+
+// readNav reads the table of contents from item, and reports whether item is the navigation document.
+func readNav(item epubReader.Item) ([]*TocEntry, bool, error) {
+	content, err := readItem(item)
+	if err != nil {
+		return nil, false, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(content)))
+	if err != nil {
+		return nil, false, nil
+	}
+
+	nav := doc.Find(`nav[epub\:type~="toc"]`).First()
+	if nav.Length() == 0 {
+		return nil, false, nil
+	}
+
+	var convert func(list *goquery.Selection) []*TocEntry
+	convert = func(list *goquery.Selection) []*TocEntry {
+		var entries []*TocEntry
+		list.ChildrenFiltered("li").Each(func(_ int, li *goquery.Selection) {
+			// Headings without a link still group the entries under them
+			label := li.ChildrenFiltered("a, span").First()
+			href, _ := label.Attr("href")
+			entries = append(entries, &TocEntry{
+				Title:    strings.Join(strings.Fields(label.Text()), " "),
+				Path:     resolveHref(item.HREF, href),
+				Children: convert(li.ChildrenFiltered("ol")),
+			})
+		})
+		return entries
+	}
+
+	return convert(nav.ChildrenFiltered("ol").First()), true, nil
+}
+
+func readItem(item epubReader.Item) ([]byte, error) {
+	r, err := item.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open item %s: %w", item.ID, err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read item %s: %w", item.ID, err)
+	}
+	return content, nil
+}
+
+// resolveHref makes href, which is relative to the file base, relative to the package document instead, and drops the fragment.
+func resolveHref(base, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if href == "" {
+		return ""
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(path.Dir(base), href)
+}
@@ -0,0 +1,186 @@
+package epubreader
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testPackage = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Book</dc:title></metadata>
+  <manifest>
+    %s
+    <item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="text/ch 2.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/><itemref idref="ch2"/></spine>
+</package>`
+
+const testNCX = `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint><navLabel><text> Part One </text></navLabel><content src="text/ch1.xhtml"/>
+      <navPoint><navLabel><text>Chapter 1</text></navLabel><content src="text/ch1.xhtml#start"/></navPoint>
+      <navPoint><navLabel><text>Chapter 2</text></navLabel><content src="text/ch%202.xhtml"/></navPoint>
+    </navPoint>
+  </navMap>
+</ncx>`
+
+const testNav = `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+  <nav epub:type="landmarks"><ol><li><a href="text/ch1.xhtml">Start</a></li></ol></nav>
+  <nav epub:type="toc"><ol>
+    <li><span>Part One</span><ol>
+      <li><a href="text/ch1.xhtml">Chapter
+        1</a></li>
+      <li><a href="text/ch%202.xhtml#top">Chapter 2</a></li>
+    </ol></li>
+  </ol></nav>
+</body></html>`
+
+// writeEpub writes a book with two chapters and the given table of contents item and files.
+func writeEpub(t *testing.T, tocItem string, files map[string]string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "book.epub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	all := map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      fmt.Sprintf(testPackage, tocItem),
+		"OEBPS/text/ch1.xhtml":   "<html><body><h1>One</h1></body></html>",
+		"OEBPS/text/ch 2.xhtml":  "<html><body><h1>Two</h1></body></html>",
+	}
+	for name, contents := range files {
+		all[name] = contents
+	}
+	for name, contents := range all {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readToc(t *testing.T, path string) ([]*TocEntry, []*EpubReaderChapter) {
+	t.Helper()
+
+	r, err := NewGoEpubReaderService(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	toc, err := r.GetToc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapters, err := r.GetChapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return toc, chapters
+}
+
+func checkToc(t *testing.T, toc []*TocEntry, chapters []*EpubReaderChapter, first []string) {
+	t.Helper()
+
+	if len(toc) != 1 || toc[0].Title != "Part One" || len(toc[0].Children) != 2 {
+		t.Fatalf("toc = %+v, want one part with two chapters", toc)
+	}
+
+	// The sections are found by the chapters' own paths
+	sections := Sections(toc)
+	want := map[string][]string{
+		"text/ch1.xhtml":  first,
+		"text/ch 2.xhtml": {"Part One", "Chapter 2"},
+	}
+	for _, ch := range chapters {
+		w, ok := want[ch.Path]
+		if !ok {
+			continue
+		}
+		delete(want, ch.Path)
+		if got := sections[ch.Path]; !slices.Equal(got, w) {
+			t.Errorf("sections of %s = %q, want %q", ch.Path, got, w)
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("no chapters at %q", want)
+	}
+}
+
+func TestGetTocNCX(t *testing.T) {
+	path := writeEpub(t, `<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`, map[string]string{
+		"OEBPS/toc.ncx": testNCX,
+	})
+	toc, chapters := readToc(t, path)
+	// The part starts with the first chapter, so that's the entry that introduces it
+	checkToc(t, toc, chapters, []string{"Part One"})
+}
+
+func TestGetTocNav(t *testing.T) {
+	path := writeEpub(t, `<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`, map[string]string{
+		"OEBPS/nav.xhtml": testNav,
+	})
+	toc, chapters := readToc(t, path)
+	checkToc(t, toc, chapters, []string{"Part One", "Chapter 1"})
+
+	if toc[0].Path != "" {
+		t.Errorf("heading without a link has path %q", toc[0].Path)
+	}
+}
+
+func TestGetTocMissing(t *testing.T) {
+	toc, _ := readToc(t, writeEpub(t, "", nil))
+	if len(toc) != 0 {
+		t.Errorf("toc = %+v, want none", toc)
+	}
+}
+
+func TestSectionsFirstEntryWins(t *testing.T) {
+	toc := []*TocEntry{
+		{Title: "Chapter 1", Path: "ch1.xhtml", Children: []*TocEntry{{Title: "Scene 2", Path: "ch1.xhtml"}}},
+		{Title: "Appendix", Path: "ch1.xhtml"},
+	}
+	if got := Sections(toc)["ch1.xhtml"]; !slices.Equal(got, []string{"Chapter 1"}) {
+		t.Errorf("sections = %q, want the entry that introduces the file", got)
+	}
+}
+
+func TestResolveHref(t *testing.T) {
+	tests := []struct {
+		base, href, want string
+	}{
+		{"toc.ncx", "text/ch1.xhtml#p1", "text/ch1.xhtml"},
+		{"nav/toc.xhtml", "../text/ch%202.xhtml", "text/ch 2.xhtml"},
+		{"", "text/ch1.xhtml", "text/ch1.xhtml"},
+		{"toc.ncx", "#top", ""},
+	}
+	for _, tt := range tests {
+		if got := resolveHref(tt.base, tt.href); got != tt.want {
+			t.Errorf("resolveHref(%q, %q) = %q, want %q", tt.base, tt.href, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	blockStreamInfo = 0
+	blockCueSheet   = 5
+
+	// leadOutTrack is the number of the lead-out track in a cue sheet that isn't for a CD.
+	leadOutTrack = 255
+	// maxTracks leaves room for the lead-out track in the one byte track count.
+	maxTracks = 254
+)
+
+var magic = []byte("fLaC")
+
+// Track is a track in a cue sheet, starting at Start from the beginning of the file.
+type Track struct {
+	Start time.Duration
+}
+
+type block struct {
+	last bool
+	kind byte
+	data []byte
+}
+
+// EmbedCueSheet adds a CUESHEET metadata block with the tracks to the FLAC file at path,
+// replacing any the file already has.
+func EmbedCueSheet(path string, tracks []Track) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	if len(tracks) > maxTracks {
+		return fmt.Errorf("a cue sheet can't have more than %d tracks", maxTracks)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	blocks, err := readMetadata(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	info := blocks[0].data
+	sampleRate := int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+	totalSamples := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || totalSamples == 0 {
+		return fmt.Errorf("%s has no sample rate or length", path)
+	}
+
+	// The cue sheet goes straight after STREAMINFO, which has to come first
+	kept := []block{blocks[0], {kind: blockCueSheet, data: cueSheet(tracks, sampleRate, totalSamples)}}
+	for _, b := range blocks[1:] {
+		if b.kind != blockCueSheet {
+			kept = append(kept, b)
+		}
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), ".flac-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	if _, err := w.Write(magic); err != nil {
+		return err
+	}
+	for i, b := range kept {
+		if err := writeBlock(w, b, i == len(kept)-1); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), path)
+}
+
+// readMetadata reads the metadata blocks, leaving r at the first audio frame.
+func readMetadata(r io.Reader) ([]block, error) {
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, magic) {
+		return nil, fmt.Errorf("not a FLAC file")
+	}
+
+	var blocks []block
+	for {
+		var h [4]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, err
+		}
+
+		b := block{
+			last: h[0]&0x80 != 0,
+			kind: h[0] & 0x7F,
+			data: make([]byte, int(h[1])<<16|int(h[2])<<8|int(h[3])),
+		}
+		if _, err := io.ReadFull(r, b.data); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+
+		if b.last {
+			break
+		}
+	}
+
+	if blocks[0].kind != blockStreamInfo || len(blocks[0].data) < 34 {
+		return nil, fmt.Errorf("missing STREAMINFO block")
+	}
+	return blocks, nil
+}
+
+func writeBlock(w io.Writer, b block, last bool) error {
+	kind := b.kind
+	if last {
+		kind |= 0x80
+	}
+
+	size := len(b.data)
+	if _, err := w.Write([]byte{kind, byte(size >> 16), byte(size >> 8), byte(size)}); err != nil {
+		return err
+	}
+	_, err := w.Write(b.data)
+	return err
+}
+
+// cueSheet encodes the CUESHEET block for a file that isn't a CD image.
+// Every track has a single index point at its start, and the lead-out track is at the end of the audio.
+func cueSheet(tracks []Track, sampleRate, totalSamples int64) []byte {
+	var buf bytes.Buffer
+
+	buf.Write(make([]byte, 128))                    // media catalog number
+	binary.Write(&buf, binary.BigEndian, uint64(0)) // lead-in samples
+	buf.Write(make([]byte, 259))                    // not a CD, reserved
+	buf.WriteByte(byte(len(tracks) + 1))
+
+	for i, t := range tracks {
+		offset := int64(t.Start.Seconds() * float64(sampleRate))
+		binary.Write(&buf, binary.BigEndian, uint64(offset))
+		buf.WriteByte(byte(i + 1))
+		buf.Write(make([]byte, 12)) // ISRC
+		buf.Write(make([]byte, 14)) // audio track, no pre-emphasis, reserved
+		buf.WriteByte(1)
+
+		// The index point is relative to the track
+		binary.Write(&buf, binary.BigEndian, uint64(0))
+		buf.WriteByte(1)
+		buf.Write(make([]byte, 3))
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint64(totalSamples))
+	buf.WriteByte(leadOutTrack)
+	buf.Write(make([]byte, 12+14))
+	buf.WriteByte(0)
+
+	return buf.Bytes()
+}
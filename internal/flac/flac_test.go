@@ -0,0 +1,153 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestFile writes a FLAC file with only a STREAMINFO block, a PADDING block and some stand-in audio.
+func writeTestFile(t *testing.T, sampleRate, totalSamples int64, audio []byte) string {
+	t.Helper()
+
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate << 4)
+	info[13] = byte(totalSamples>>32) & 0x0F
+	binary.BigEndian.PutUint32(info[14:18], uint32(totalSamples))
+
+	var buf bytes.Buffer
+	buf.Write(magic)
+	writeBlock(&buf, block{kind: blockStreamInfo, data: info}, false)
+	writeBlock(&buf, block{kind: 1, data: make([]byte, 16)}, true)
+	buf.Write(audio)
+
+	path := filepath.Join(t.TempDir(), "book.flac")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type cueTrack struct {
+	offset uint64
+	number byte
+}
+
+// readCueSheet returns the tracks in the file's cue sheet, with the lead-out last, and the audio after the metadata.
+func readCueSheet(t *testing.T, path string) ([]block, []cueTrack, []byte) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	blocks, err := readMetadata(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheets []block
+	for _, b := range blocks {
+		if b.kind == blockCueSheet {
+			sheets = append(sheets, b)
+		}
+	}
+	if len(sheets) != 1 {
+		t.Fatalf("found %d cue sheets, want 1", len(sheets))
+	}
+
+	data := sheets[0].data
+	if isCD := data[136] & 0x80; isCD != 0 {
+		t.Error("cue sheet is marked as a CD")
+	}
+
+	count := int(data[395])
+	tracks := make([]cueTrack, count)
+	pos := 396
+	for i := range tracks {
+		tracks[i] = cueTrack{offset: binary.BigEndian.Uint64(data[pos:]), number: data[pos+8]}
+		indexes := int(data[pos+35])
+		pos += 36 + indexes*12
+	}
+	if pos != len(data) {
+		t.Errorf("cue sheet is %d bytes, tracks end at %d", len(data), pos)
+	}
+
+	return blocks, tracks, audio
+}
+
+func TestEmbedCueSheet(t *testing.T) {
+	audio := []byte{0xFF, 0xF8, 1, 2, 3, 4, 5}
+	path := writeTestFile(t, 44100, 44100*90, audio)
+
+	err := EmbedCueSheet(path, []Track{{Start: 0}, {Start: 30 * time.Second}, {Start: 61500 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, tracks, rest := readCueSheet(t, path)
+	if blocks[0].kind != blockStreamInfo || blocks[1].kind != blockCueSheet {
+		t.Errorf("blocks start with kinds %d, %d, want STREAMINFO then CUESHEET", blocks[0].kind, blocks[1].kind)
+	}
+	if !blocks[len(blocks)-1].last {
+		t.Error("last block isn't marked as last")
+	}
+	if !bytes.Equal(rest, audio) {
+		t.Errorf("audio = %v, want %v", rest, audio)
+	}
+
+	want := []cueTrack{{0, 1}, {44100 * 30, 2}, {44100 * 61.5, 3}, {44100 * 90, leadOutTrack}}
+	if len(tracks) != len(want) {
+		t.Fatalf("cue sheet has %d tracks, want %d", len(tracks), len(want))
+	}
+	for i, w := range want {
+		if tracks[i] != w {
+			t.Errorf("track %d = %+v, want %+v", i, tracks[i], w)
+		}
+	}
+
+	// Embedding again replaces the cue sheet rather than adding another
+	if err := EmbedCueSheet(path, []Track{{Start: 0}, {Start: time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+	_, tracks, rest = readCueSheet(t, path)
+	if len(tracks) != 3 || tracks[1].offset != 44100*60 {
+		t.Errorf("tracks after replacing = %+v", tracks)
+	}
+	if !bytes.Equal(rest, audio) {
+		t.Errorf("audio after replacing = %v, want %v", rest, audio)
+	}
+}
+
+func TestEmbedCueSheetErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.flac")
+	if err := os.WriteFile(path, []byte("RIFF....WAVE"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedCueSheet(path, []Track{{}}); err == nil {
+		t.Error("expected an error for a file that isn't FLAC")
+	}
+
+	path = writeTestFile(t, 44100, 0, nil)
+	if err := EmbedCueSheet(path, []Track{{}}); err == nil {
+		t.Error("expected an error for a file without a length")
+	}
+
+	if err := EmbedCueSheet(path, make([]Track, maxTracks+1)); err == nil {
+		t.Error("expected an error for too many tracks")
+	}
+}
@@ -5,18 +5,21 @@ import "flag"
 type Flags struct {
 	ResetProgress   bool
 	FinishAudiobook bool
+	Patch           bool
 	Parsed          bool
 }
 
 const (
 	FlagReset    = "reset"
 	FlagComplete = "finish"
+	FlagPatch    = "patch"
 )
 
 func New() *Flags {
 	f := &Flags{}
 	flag.BoolVar(&f.ResetProgress, FlagReset, false, "Reset the audiobook generation process")
 	flag.BoolVar(&f.FinishAudiobook, FlagComplete, false, "Finish audiobook generation with currently processed chapters")
+	flag.BoolVar(&f.Patch, FlagPatch, false, "Synthesize chunks that fell back in an earlier run again and rebuild the audiobook")
 	flag.Parse()
 	f.Parsed = true
 
@@ -0,0 +1,561 @@
+package job
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A job directory holds everything needed to resume one book:
+//
+//	<root>/<book id>/
+//	    manifest.json   the state of every chapter and chunk, rewritten atomically
+//	    journal.jsonl   events since the manifest was last written, synced on every append
+//	    chapters/       finished chapter audio
+//	    speakers/       prepared speaker reference clips
+//	    tmp/            scratch files for the current run
+//
+// The journal is replayed on top of the manifest when the job is opened,
+// so progress survives the process being killed at any point.
+const (
+	manifestFile = "manifest.json"
+	journalFile  = "journal.jsonl"
+	chaptersDir  = "chapters"
+	speakersDir  = "speakers"
+	workDir      = "tmp"
+
+	manifestVersion = 1
+)
+
+type State string
+
+const (
+	StatePending State = "pending"
+	StateRunning State = "running"
+	StateDone    State = "done"
+	StateFailed  State = "failed"
+	// StateFallback is a chunk whose audio came from a fallback step rather than the engine.
+	StateFallback State = "fallback"
+)
+
+type Book struct {
+	Id     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Path   string `json:"path"`
+}
+
+type Manifest struct {
+	Version  int             `json:"version"`
+	Book     Book            `json:"book"`
+	State    State           `json:"state"`
+	Config   json.RawMessage `json:"config,omitempty"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+	Runs     int             `json:"runs"`
+	Chapters []*Chapter      `json:"chapters"`
+}
+
+type Chapter struct {
+	Index    int       `json:"index"`
+	Title    string    `json:"title"`
+	Voice    string    `json:"voice,omitempty"`
+	State    State     `json:"state"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Chunks   []Chunk   `json:"chunks,omitempty"`
+	// Fingerprint identifies the chapter's text and everything that changes how it sounds.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type Chunk struct {
+	Key     string        `json:"key,omitempty"`
+	State   State         `json:"state"`
+	Elapsed time.Duration `json:"elapsed,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	// Fallback is the fallback step that produced the chunk's audio.
+	Fallback string `json:"fallback,omitempty"`
+}
+
+type eventType string
+
+const (
+	eventChapterStarted  eventType = "chapter_started"
+	eventChapterFinished eventType = "chapter_finished"
+	eventChunkDone       eventType = "chunk_done"
+	eventChunkFailed     eventType = "chunk_failed"
+	eventChunkFallback   eventType = "chunk_fallback"
+)
+
+// event is a single journal line.
+type event struct {
+	Time     time.Time     `json:"time"`
+	Type     eventType     `json:"type"`
+	Chapter  int           `json:"chapter"`
+	Chunk    int           `json:"chunk,omitempty"`
+	Chunks   int           `json:"chunks,omitempty"`
+	Title    string        `json:"title,omitempty"`
+	Voice    string        `json:"voice,omitempty"`
+	Key      string        `json:"key,omitempty"`
+	Elapsed  time.Duration `json:"elapsed,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Fallback string        `json:"fallback,omitempty"`
+	// Fingerprint is the chapter's, for chapter_started.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type Job struct {
+	dir      string
+	mu       sync.Mutex
+	manifest *Manifest
+	journal  *os.File
+}
+
+var unsafeIdChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Id identifies a book by its title and the contents of its file.
+// A new edition of the same book is a different job, but can still reuse cached audio.
+func Id(epubPath, title string) (string, error) {
+	f, err := os.Open(epubPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))[:16]
+
+	slug := strings.Trim(unsafeIdChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > 40 {
+		slug = strings.TrimRight(slug[:40], "-")
+	}
+	if slug == "" {
+		return sum, nil
+	}
+
+	return slug + "-" + sum, nil
+}
+
+// Open loads the job for book from root, creating it if it doesn't exist.
+// config is a snapshot of the settings used for this run.
+func Open(root string, book Book, config any) (*Job, error) {
+	dir := filepath.Join(root, book.Id)
+	for _, d := range []string{dir, filepath.Join(dir, chaptersDir), filepath.Join(dir, workDir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create job directory: %w", err)
+		}
+	}
+
+	j := &Job{dir: dir}
+
+	m, err := readManifest(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = &Manifest{
+			Version: manifestVersion,
+			State:   StatePending,
+			Created: time.Now(),
+		}
+	}
+	j.manifest = m
+
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot config: %w", err)
+	}
+
+	m.Book = book
+	m.Config = snapshot
+	m.Runs++
+	if m.State == StateDone {
+		// The output is being made again, so start over from the chapter audio
+		m.State = StatePending
+	}
+
+	// Fold the replayed events into the manifest and start a fresh journal.
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Remove deletes the job for the book id, if there is one.
+func Remove(root, id string) error {
+	if id == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(root, id))
+}
+
+func (j *Job) Dir() string {
+	return j.dir
+}
+
+// WorkDir is a scratch directory for files that are only needed during a run.
+func (j *Job) WorkDir() string {
+	return filepath.Join(j.dir, workDir) + "/"
+}
+
+// SpeakerDir holds speaker reference clips prepared for this book. They're kept when the job finishes.
+func (j *Job) SpeakerDir() string {
+	return filepath.Join(j.dir, speakersDir)
+}
+
+// ChapterPath is where the audio for the chapter is stored.
+func (j *Job) ChapterPath(index int) string {
+	return filepath.Join(j.dir, chaptersDir, fmt.Sprintf("chapter-%d.wav", index))
+}
+
+// ChapterDone reports whether the chapter was finished with the same fingerprint and its audio is still there.
+func (j *Job) ChapterDone(index int, fingerprint string) bool {
+	j.mu.Lock()
+	ch := j.chapter(index, false)
+	done := ch != nil && ch.State == StateDone && ch.Fingerprint == fingerprint
+	j.mu.Unlock()
+
+	if !done {
+		return false
+	}
+
+	_, err := os.Stat(j.ChapterPath(index))
+	return err == nil
+}
+
+// ChunkDone reports whether the chunk was finished with the same key.
+func (j *Job) ChunkDone(chapter, chunk int, key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ch := j.chapter(chapter, false)
+	if ch == nil || chunk >= len(ch.Chunks) {
+		return false
+	}
+
+	c := ch.Chunks[chunk]
+	return c.State == StateDone && c.Key == key
+}
+
+func (j *Job) StartChapter(index int, title, voice, fingerprint string, chunks int) error {
+	return j.record(event{Type: eventChapterStarted, Chapter: index, Title: title, Voice: voice, Fingerprint: fingerprint, Chunks: chunks})
+}
+
+func (j *Job) CompleteChunk(chapter, chunk int, key string, elapsed time.Duration) error {
+	return j.record(event{Type: eventChunkDone, Chapter: chapter, Chunk: chunk, Key: key, Elapsed: elapsed})
+}
+
+func (j *Job) FailChunk(chapter, chunk int, key string, err error) error {
+	return j.record(event{Type: eventChunkFailed, Chapter: chapter, Chunk: chunk, Key: key, Error: err.Error()})
+}
+
+// FallbackChunk records that the chunk failed with err and a fallback step produced its audio instead.
+func (j *Job) FallbackChunk(chapter, chunk int, key, step string, err error) error {
+	return j.record(event{Type: eventChunkFallback, Chapter: chapter, Chunk: chunk, Key: key, Fallback: step, Error: err.Error()})
+}
+
+// Fallbacks returns the number of chunks whose audio came from a fallback step.
+func (j *Job) Fallbacks() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	n := 0
+	for _, ch := range j.manifest.Chapters {
+		for _, c := range ch.Chunks {
+			if c.State == StateFallback {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Patch marks every chapter with a fallback chunk as pending, so the next run synthesizes those chunks again.
+// It returns the number of chunks to be patched.
+func (j *Job) Patch() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	n := 0
+	for _, ch := range j.manifest.Chapters {
+		patch := false
+		for _, c := range ch.Chunks {
+			if c.State == StateFallback {
+				patch = true
+				n++
+			}
+		}
+		if patch {
+			ch.State = StatePending
+			ch.Finished = time.Time{}
+		}
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+	return n, j.compact()
+}
+
+// FinishChapter records that the chapter's audio is complete and folds the journal into the manifest.
+func (j *Job) FinishChapter(index int) error {
+	if err := j.record(event{Type: eventChapterFinished, Chapter: index}); err != nil {
+		return err
+	}
+	return j.Flush()
+}
+
+// Finish marks the whole book as done and removes the chapter audio, which is no longer needed.
+func (j *Job) Finish() error {
+	j.mu.Lock()
+	j.manifest.State = StateDone
+	j.mu.Unlock()
+
+	if err := j.Flush(); err != nil {
+		return err
+	}
+
+	return errors.Join(
+		os.RemoveAll(filepath.Join(j.dir, chaptersDir)),
+		os.RemoveAll(filepath.Join(j.dir, workDir)),
+	)
+}
+
+// Manifest returns a copy of the job's current state.
+func (j *Job) Manifest() Manifest {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	m := *j.manifest
+	m.Chapters = make([]*Chapter, len(j.manifest.Chapters))
+	for i, ch := range j.manifest.Chapters {
+		c := *ch
+		c.Chunks = append([]Chunk(nil), ch.Chunks...)
+		m.Chapters[i] = &c
+	}
+
+	return m
+}
+
+// Flush writes the manifest and empties the journal.
+func (j *Job) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.compact()
+}
+
+func (j *Job) Close() error {
+	err := j.Flush()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.journal != nil {
+		err = errors.Join(err, j.journal.Close())
+		j.journal = nil
+	}
+
+	return err
+}
+
+// record appends the event to the journal, waits for it to reach the disk, and applies it.
+func (j *Job) record(e event) error {
+	e.Time = time.Now()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.journal == nil {
+		return fmt.Errorf("job is closed")
+	}
+
+	if _, err := j.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write job journal: %w", err)
+	}
+	if err := j.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync job journal: %w", err)
+	}
+
+	j.apply(e)
+	return nil
+}
+
+func (j *Job) apply(e event) {
+	ch := j.chapter(e.Chapter, true)
+	j.manifest.Updated = e.Time
+
+	switch e.Type {
+	case eventChapterStarted:
+		ch.Title = e.Title
+		ch.Voice = e.Voice
+		// A finished chapter that sounds different now has to be made again
+		if ch.State != StateDone || ch.Fingerprint != e.Fingerprint {
+			ch.State = StateRunning
+			ch.Finished = time.Time{}
+		}
+		ch.Fingerprint = e.Fingerprint
+		if ch.Started.IsZero() {
+			ch.Started = e.Time
+		}
+		if len(ch.Chunks) != e.Chunks {
+			// The chapter text changed, so the old chunk states no longer line up.
+			ch.Chunks = make([]Chunk, e.Chunks)
+			for i := range ch.Chunks {
+				ch.Chunks[i].State = StatePending
+			}
+		}
+		if j.manifest.State == StatePending {
+			j.manifest.State = StateRunning
+		}
+
+	case eventChapterFinished:
+		ch.State = StateDone
+		ch.Finished = e.Time
+
+	case eventChunkDone, eventChunkFailed, eventChunkFallback:
+		if e.Chunk >= len(ch.Chunks) {
+			grown := make([]Chunk, e.Chunk+1)
+			copy(grown, ch.Chunks)
+			ch.Chunks = grown
+		}
+
+		state := StateDone
+		switch e.Type {
+		case eventChunkFailed:
+			state = StateFailed
+		case eventChunkFallback:
+			state = StateFallback
+		}
+		ch.Chunks[e.Chunk] = Chunk{Key: e.Key, State: state, Elapsed: e.Elapsed, Error: e.Error, Fallback: e.Fallback}
+	}
+}
+
+// chapter returns the chapter with the given index, adding it if create is set.
+func (j *Job) chapter(index int, create bool) *Chapter {
+	for _, ch := range j.manifest.Chapters {
+		if ch.Index == index {
+			return ch
+		}
+	}
+
+	if !create {
+		return nil
+	}
+
+	ch := &Chapter{Index: index, State: StatePending}
+	j.manifest.Chapters = append(j.manifest.Chapters, ch)
+	return ch
+}
+
+// replay applies the journal left behind by an earlier run.
+// A torn last line from a crash is ignored.
+func (j *Job) replay() error {
+	data, err := os.ReadFile(filepath.Join(j.dir, journalFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read job journal: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		j.apply(e)
+	}
+
+	return nil
+}
+
+// compact writes the manifest atomically and starts an empty journal.
+// If the process dies in between, replaying the old journal again is harmless.
+func (j *Job) compact() error {
+	data, err := json.MarshalIndent(j.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(j.dir, manifestFile), data); err != nil {
+		return fmt.Errorf("failed to write job manifest: %w", err)
+	}
+
+	if j.journal != nil {
+		j.journal.Close()
+	}
+
+	j.journal, err = os.OpenFile(filepath.Join(j.dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open job journal: %w", err)
+	}
+
+	return nil
+}
+
+func readManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job manifest: %w", err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("job manifest %s is corrupt: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// writeFileAtomic replaces path with data so that readers see either the old or the new contents, never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
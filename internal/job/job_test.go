@@ -0,0 +1,340 @@
+package job
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayTornJournal(t *testing.T) {
+	root := t.TempDir()
+	book := Book{Id: "book", Title: "Book"}
+
+	j, err := Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.StartChapter(1, "Chapter 1", "p225", "", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.CompleteChunk(1, 0, "key0", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.FailChunk(1, 1, "key1", errors.New("out of memory")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process dies halfway through writing the next event
+	journal, err := os.OpenFile(filepath.Join(root, book.Id, journalFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := journal.WriteString(`{"time":"2024-01-01T00:00:00Z","type":"chunk_do`); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	j, err = Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	m := j.Manifest()
+	if m.Runs != 2 {
+		t.Errorf("runs = %d, want 2", m.Runs)
+	}
+	if len(m.Chapters) != 1 {
+		t.Fatalf("manifest has %d chapters, want 1", len(m.Chapters))
+	}
+	ch := m.Chapters[0]
+	if ch.Title != "Chapter 1" || ch.Voice != "p225" || ch.State != StateRunning {
+		t.Errorf("chapter = %q by %q %s, want the replayed start", ch.Title, ch.Voice, ch.State)
+	}
+	want := []Chunk{
+		{Key: "key0", State: StateDone, Elapsed: time.Second},
+		{Key: "key1", State: StateFailed, Error: "out of memory"},
+		{State: StatePending},
+	}
+	if len(ch.Chunks) != len(want) {
+		t.Fatalf("chapter has %d chunks, want %d", len(ch.Chunks), len(want))
+	}
+	for i, w := range want {
+		if ch.Chunks[i] != w {
+			t.Errorf("chunk %d = %+v, want %+v", i, ch.Chunks[i], w)
+		}
+	}
+	if !j.ChunkDone(1, 0, "key0") || j.ChunkDone(1, 0, "other") || j.ChunkDone(1, 1, "key1") {
+		t.Error("ChunkDone doesn't match the replayed chunks")
+	}
+
+	// Opening folds the journal into the manifest and starts a new one
+	if info, err := os.Stat(filepath.Join(root, book.Id, journalFile)); err != nil || info.Size() != 0 {
+		t.Errorf("journal after opening: %v, %v, want an empty file", info, err)
+	}
+	stored, err := readManifest(filepath.Join(root, book.Id, manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Chapters) != 1 || len(stored.Chapters[0].Chunks) != 3 {
+		t.Errorf("stored manifest = %+v, want the replayed chapter", stored)
+	}
+}
+
+func TestChapterDone(t *testing.T) {
+	root := t.TempDir()
+	book := Book{Id: "book"}
+
+	j, err := Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.StartChapter(0, "Introduction", "", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.FinishChapter(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A finished chapter still needs its audio
+	if j.ChapterDone(0, "") {
+		t.Error("chapter is done without its audio")
+	}
+	if err := os.WriteFile(j.ChapterPath(0), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !j.ChapterDone(0, "") {
+		t.Error("finished chapter isn't done")
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if !j.ChapterDone(0, "") {
+		t.Error("finished chapter isn't done after reopening")
+	}
+	if j.ChapterDone(1, "") {
+		t.Error("a chapter that never started is done")
+	}
+}
+
+func TestChapterDoneFingerprint(t *testing.T) {
+	j, err := Open(t.TempDir(), Book{Id: "book"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if err := j.StartChapter(0, "Introduction", "", "before", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.FinishChapter(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(j.ChapterPath(0), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !j.ChapterDone(0, "before") || j.ChapterDone(0, "after") {
+		t.Error("only the fingerprint the chapter was made with should match")
+	}
+
+	// Until it's finished again, the chapter isn't done with either
+	if err := j.StartChapter(0, "Introduction", "", "after", 1); err != nil {
+		t.Fatal(err)
+	}
+	if j.ChapterDone(0, "before") || j.ChapterDone(0, "after") {
+		t.Error("a chapter being made again is done")
+	}
+	if err := j.FinishChapter(0); err != nil {
+		t.Fatal(err)
+	}
+	if !j.ChapterDone(0, "after") {
+		t.Error("chapter isn't done with its new fingerprint")
+	}
+}
+
+func TestChapterTextChanged(t *testing.T) {
+	j, err := Open(t.TempDir(), Book{Id: "book"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if err := j.StartChapter(0, "Introduction", "", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.CompleteChunk(0, 0, "key0", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarting with the same chunks keeps their progress
+	if err := j.StartChapter(0, "Introduction", "", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !j.ChunkDone(0, 0, "key0") {
+		t.Error("restarting the chapter lost its progress")
+	}
+
+	// A different number of chunks means the text changed
+	if err := j.StartChapter(0, "Introduction", "", "", 3); err != nil {
+		t.Fatal(err)
+	}
+	if j.ChunkDone(0, 0, "key0") {
+		t.Error("chunk is still done after the chapter text changed")
+	}
+	if n := len(j.Manifest().Chapters[0].Chunks); n != 3 {
+		t.Errorf("chapter has %d chunks, want 3", n)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	root := t.TempDir()
+	book := Book{Id: "book"}
+
+	j, err := Open(root, book, map[string]string{"setting": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(j.ChapterPath(0), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(j.ChapterPath(0)); !os.IsNotExist(err) {
+		t.Errorf("chapter audio is still there after finishing: %v", err)
+	}
+	if _, err := os.Stat(j.WorkDir()); !os.IsNotExist(err) {
+		t.Errorf("work directory is still there after finishing: %v", err)
+	}
+	j.Close()
+
+	// Making the output again starts over
+	j, err = Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if m := j.Manifest(); m.State != StatePending || m.Runs != 2 {
+		t.Errorf("reopened job is %s after %d runs, want pending after 2", m.State, m.Runs)
+	}
+
+	if err := Remove(root, book.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(j.Dir()); !os.IsNotExist(err) {
+		t.Errorf("job directory is still there after removing it: %v", err)
+	}
+}
+
+func TestId(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := write("first.epub", "first edition")
+	second := write("second.epub", "second edition")
+
+	id, err := Id(first, "The Book: A Story!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(id, "the-book-a-story-") || len(id) != len("the-book-a-story-")+16 {
+		t.Errorf("id = %q, want the title slug and a short hash", id)
+	}
+	if again, _ := Id(first, "The Book: A Story!"); again != id {
+		t.Errorf("id changed between calls: %q, %q", id, again)
+	}
+	if other, _ := Id(second, "The Book: A Story!"); other == id {
+		t.Error("another edition has the same id")
+	}
+	if bare, _ := Id(first, "???"); len(bare) != 16 {
+		t.Errorf("id = %q, want just the hash without a usable title", bare)
+	}
+	if _, err := Id(filepath.Join(dir, "missing.epub"), "Book"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestFallbackAndPatch(t *testing.T) {
+	root := t.TempDir()
+	book := Book{Id: "book"}
+
+	j, err := Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.StartChapter(1, "Chapter 1", "", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.CompleteChunk(1, 0, "key0", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.FallbackChunk(1, 1, "key1", "silence", errors.New("engine crashed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(j.ChapterPath(1), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.FinishChapter(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = Open(root, book, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	want := Chunk{Key: "key1", State: StateFallback, Error: "engine crashed", Fallback: "silence"}
+	if got := j.Manifest().Chapters[0].Chunks[1]; got != want {
+		t.Errorf("replayed chunk = %+v, want %+v", got, want)
+	}
+	if j.Fallbacks() != 1 {
+		t.Errorf("fallbacks = %d, want 1", j.Fallbacks())
+	}
+	// A fallback chunk has audio, but not the engine's
+	if j.ChunkDone(1, 1, "key1") {
+		t.Error("fallback chunk is done")
+	}
+	if !j.ChapterDone(1, "") {
+		t.Fatal("chapter isn't done before patching")
+	}
+
+	n, err := j.Patch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("patching %d chunks, want 1", n)
+	}
+	if j.ChapterDone(1, "") {
+		t.Error("patched chapter is still done")
+	}
+	if !j.ChunkDone(1, 0, "key0") {
+		t.Error("patching lost the chunks that worked")
+	}
+
+	// Nothing is left to patch once the chunk is synthesized again
+	if err := j.CompleteChunk(1, 1, "key1", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := j.Patch(); err != nil || n != 0 {
+		t.Errorf("patch = %d, %v, want nothing to do", n, err)
+	}
+}
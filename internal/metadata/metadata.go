@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/pixellini/go-audiobook/internal/config"
 	"github.com/pixellini/go-audiobook/internal/epub"
 )
 
@@ -33,7 +37,15 @@ func New(dir string) (*Metadata, error) {
 	}, nil
 }
 
-func (m *Metadata) AddDetails(md *epub.EpubMetadata) error {
+// AlbumTags reports whether files in the format are tagged with an album and genre. Music players group files
+// by album, and MP3, Opus and FLAC players don't know what an audiobook is otherwise.
+// Audiobook players already do, and some list M4B files with an album as music.
+func AlbumTags(format string) bool {
+	return slices.Contains([]string{config.FormatMP3, config.FormatOpus, config.FormatFLAC}, format)
+}
+
+// AddDetails writes the book's tags for a file in the given format.
+func (m *Metadata) AddDetails(md *epub.EpubMetadata, format string) error {
 	var errs []error
 
 	if err := m.writeProperty("title", md.Title); err != nil {
@@ -44,6 +56,16 @@ func (m *Metadata) AddDetails(md *epub.EpubMetadata) error {
 		errs = append(errs, err)
 	}
 
+	if AlbumTags(format) {
+		if err := m.writeProperty("album", md.Title); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := m.writeProperty("genre", "Audiobook"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	if err := m.writeProperty("description", md.Description); err != nil {
 		errs = append(errs, err)
 	}
@@ -55,18 +77,61 @@ func (m *Metadata) AddDetails(md *epub.EpubMetadata) error {
 	return errors.Join(errs...)
 }
 
-func (m *Metadata) AddChapter(title string, start, end int) error {
+// AddTrack writes the tags for a file that holds one chapter of the book.
+func (m *Metadata) AddTrack(md *epub.EpubMetadata, title string, track, tracks int) error {
+	var errs []error
+
+	for _, tag := range [][2]string{
+		{"title", title},
+		{"artist", md.Author},
+		{"album_artist", md.Author},
+		{"album", md.Title},
+		{"publisher", md.Publisher},
+		{"genre", "Audiobook"},
+		{"track", fmt.Sprintf("%d/%d", track, tracks)},
+		{"disc", "1/1"},
+	} {
+		if err := m.writeProperty(tag[0], tag[1]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// AddChapter writes a chapter. The voice it was read with is kept as a chapter tag, if it's known.
+// A speaker clip is tagged by its file name, so the tag doesn't give away where it's kept.
+func (m *Metadata) AddChapter(title, voice string, start, end int) error {
 	_, err := fmt.Fprintf(m.bw,
-		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n\n",
-		start, end, title)
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+		start, end, Escape(title))
+	if err != nil {
+		return err
+	}
+
+	if voice != "" {
+		if _, err := fmt.Fprintf(m.bw, "voice=%s\n", Escape(filepath.Base(voice))); err != nil {
+			return err
+		}
+	}
+
+	_, err = m.bw.WriteString("\n")
 	return err
 }
 
+// Escape quotes the characters that have a meaning in a metadata file.
+func Escape(val string) string {
+	return metadataEscaper.Replace(val)
+}
+
+var metadataEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+// writeProperty writes a global tag, escaping the value.
 func (m *Metadata) writeProperty(key, val string) error {
 	if val == "" {
 		return nil
 	}
-	_, err := fmt.Fprintf(m.bw, "%s=%s\n", key, val)
+	_, err := fmt.Fprintf(m.bw, "%s=%s\n", key, Escape(val))
 	return err
 }
 
@@ -0,0 +1,94 @@
+package metadata
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/epub"
+)
+
+// write runs fn on new metadata and returns the file it wrote.
+func write(t *testing.T, fn func(m *Metadata) error) string {
+	t.Helper()
+
+	m, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(m.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAddDetails(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{config.FormatM4B, ";FFMETADATA1\ntitle=The Book\nartist=A. Writer\n"},
+		{config.FormatMP3, ";FFMETADATA1\ntitle=The Book\nartist=A. Writer\nalbum=The Book\ngenre=Audiobook\n"},
+		{config.FormatFLAC, ";FFMETADATA1\ntitle=The Book\nartist=A. Writer\nalbum=The Book\ngenre=Audiobook\n"},
+	}
+
+	for _, tt := range tests {
+		got := write(t, func(m *Metadata) error {
+			return m.AddDetails(&epub.EpubMetadata{Title: "The Book", Author: "A. Writer"}, tt.format)
+		})
+		if got != tt.want {
+			t.Errorf("%s metadata = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestAddChapter(t *testing.T) {
+	got := write(t, func(m *Metadata) error {
+		if err := m.AddChapter("One; or, the Start", "", 0, 1500); err != nil {
+			return err
+		}
+		if err := m.AddChapter("Two", "p225", 1500, 3000); err != nil {
+			return err
+		}
+		return m.AddChapter("Three", "/home/reader/voices/narrator.wav", 3000, 4500)
+	})
+
+	want := ";FFMETADATA1\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=1500\ntitle=One\\; or, the Start\n\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=1500\nEND=3000\ntitle=Two\nvoice=p225\n\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=3000\nEND=4500\ntitle=Three\nvoice=narrator.wav\n\n"
+	if got != want {
+		t.Errorf("metadata = %q, want %q", got, want)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	got := Escape("a=b;c#d\\e\nf")
+	if want := `a\=b\;c\#d\\e` + "\\\nf"; got != want {
+		t.Errorf("escape = %q, want %q", got, want)
+	}
+	if strings.Contains(Escape("plain title"), `\`) {
+		t.Error("escaped a title with nothing to escape")
+	}
+}
+
+func TestAddTrack(t *testing.T) {
+	got := write(t, func(m *Metadata) error {
+		return m.AddTrack(&epub.EpubMetadata{Title: "The Book", Author: "A. Writer"}, "One=1", 3, 12)
+	})
+
+	want := ";FFMETADATA1\ntitle=One\\=1\nartist=A. Writer\nalbum_artist=A. Writer\nalbum=The Book\n" +
+		"genre=Audiobook\ntrack=3/12\ndisc=1/1\n"
+	if got != want {
+		t.Errorf("metadata = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/audioqa"
+	"github.com/pixellini/go-audiobook/internal/autotune"
+)
+
+// Report collects what happened during a run. It's written to the job directory when the run ends.
+type Report struct {
+	mu sync.Mutex
+
+	Book     string        `json:"book"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"duration"`
+	Result   string        `json:"result"`
+
+	Chunks      int `json:"chunks"`
+	Cached      int `json:"cached"`
+	Synthesized int `json:"synthesized"`
+	Characters  int `json:"characters"`
+	// SynthesisTime is the total time spent in the TTS engine, summed over all workers.
+	SynthesisTime time.Duration `json:"synthesis_time"`
+
+	Concurrency Concurrency `json:"concurrency"`
+
+	// Retried is the number of chunks synthesized again because QA flagged the first attempt.
+	Retried int `json:"retried"`
+	// Review lists the chunks that still looked wrong after every attempt, so they can be checked by ear.
+	Review []Review `json:"review,omitempty"`
+	// Fallbacks lists the chunks the engine failed on, and what replaced them.
+	// Running with --patch synthesizes them again.
+	Fallbacks []Fallback `json:"fallbacks,omitempty"`
+	// Outputs lists every file the run tried to create, and whether it worked.
+	Outputs []Output `json:"outputs,omitempty"`
+	// Loudness is how the chapters were normalized, if they were.
+	Loudness *Loudness `json:"loudness,omitempty"`
+}
+
+// Review is a chunk that QA couldn't fix.
+type Review struct {
+	Chapter  int              `json:"chapter"`
+	Part     int              `json:"part"`
+	Key      string           `json:"key"`
+	Text     string           `json:"text"`
+	Attempts int              `json:"attempts"`
+	Analysis audioqa.Analysis `json:"analysis"`
+}
+
+type Concurrency struct {
+	Auto        bool                `json:"auto"`
+	Min         int                 `json:"min,omitempty"`
+	Max         int                 `json:"max,omitempty"`
+	Initial     int                 `json:"initial"`
+	Final       int                 `json:"final"`
+	Adjustments []autotune.Decision `json:"adjustments,omitempty"`
+}
+
+func New(book string) *Report {
+	return &Report{
+		Book:    book,
+		Started: time.Now(),
+	}
+}
+
+// AddChunk records a finished chunk. Cached chunks didn't need the engine.
+func (r *Report) AddChunk(chars int, cached bool, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Chunks++
+	if cached {
+		r.Cached++
+		return
+	}
+
+	r.Synthesized++
+	r.Characters += chars
+	r.SynthesisTime += elapsed
+}
+
+// AddRetry records a chunk that was synthesized again after failing QA.
+func (r *Report) AddRetry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Retried++
+}
+
+// AddReview records a chunk that needs checking by a person.
+func (r *Report) AddReview(review Review) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Review = append(r.Review, review)
+}
+
+// Fallback is a chunk whose audio came from a fallback step.
+type Fallback struct {
+	Chapter int    `json:"chapter"`
+	Part    int    `json:"part"`
+	Key     string `json:"key"`
+	Text    string `json:"text"`
+	Step    string `json:"step"`
+	Error   string `json:"error"`
+}
+
+func (r *Report) AddFallback(f Fallback) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Fallbacks = append(r.Fallbacks, f)
+}
+
+// Output is an audiobook file or directory created from the chapters.
+type Output struct {
+	Path    string        `json:"path"`
+	Format  string        `json:"format"`
+	Split   bool          `json:"split,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Error is empty if the output was created.
+	Error string `json:"error,omitempty"`
+}
+
+func (r *Report) AddOutput(o Output) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Outputs = append(r.Outputs, o)
+}
+
+// Loudness is the target every chapter was normalized to, and what each one measured before and after.
+// Levels are in LUFS, peaks in dBTP and ranges in LU.
+type Loudness struct {
+	Target   float64           `json:"target"`
+	TruePeak float64           `json:"true_peak"`
+	Range    float64           `json:"range"`
+	Chapters []ChapterLoudness `json:"chapters"`
+}
+
+type ChapterLoudness struct {
+	Chapter int    `json:"chapter"`
+	Title   string `json:"title"`
+	// Measured is empty for a silent chapter, which is left as it is.
+	Measured *Level `json:"measured,omitempty"`
+	Output   *Level `json:"output,omitempty"`
+	// Normalization is "linear", or "dynamic" when the chapter couldn't reach the target with a single gain.
+	Normalization string `json:"normalization,omitempty"`
+}
+
+type Level struct {
+	Integrated float64 `json:"integrated"`
+	TruePeak   float64 `json:"true_peak"`
+	Range      float64 `json:"range"`
+}
+
+// SetLoudness records the loudness normalization of the chapters.
+func (r *Report) SetLoudness(l Loudness) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Loudness = &l
+}
+
+// SetConcurrency records how many workers were used and how the count was chosen.
+func (r *Report) SetConcurrency(c Concurrency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Concurrency = c
+}
+
+// Finish stamps the end of the run with its result, for example "completed" or an error message.
+func (r *Report) Finish(result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Finished = time.Now()
+	r.Duration = r.Finished.Sub(r.Started).Truncate(time.Millisecond)
+	r.Result = result
+
+	// Chunks finish out of order, the review list reads better in book order.
+	slices.SortFunc(r.Review, func(a, b Review) int {
+		if a.Chapter != b.Chapter {
+			return a.Chapter - b.Chapter
+		}
+		return a.Part - b.Part
+	})
+	slices.SortFunc(r.Fallbacks, func(a, b Fallback) int {
+		if a.Chapter != b.Chapter {
+			return a.Chapter - b.Chapter
+		}
+		return a.Part - b.Part
+	})
+	slices.SortFunc(r.Outputs, func(a, b Output) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+}
+
+// Throughput is the number of synthesized characters per second of wall time.
+func (r *Report) Throughput() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	secs := r.Finished.Sub(r.Started).Seconds()
+	if secs <= 0 {
+		return 0
+	}
+	return float64(r.Characters) / secs
+}
+
+func (r *Report) Save(path string) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
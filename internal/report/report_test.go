@@ -0,0 +1,92 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAddChunk(t *testing.T) {
+	r := New("Book")
+
+	r.AddChunk(100, false, 2*time.Second)
+	r.AddChunk(50, false, time.Second)
+	// Cached chunks didn't use the engine, so they don't count towards throughput
+	r.AddChunk(80, true, 0)
+
+	if r.Chunks != 3 || r.Cached != 1 || r.Synthesized != 2 {
+		t.Errorf("chunks = %d, cached %d, synthesized %d, want 3, 1, 2", r.Chunks, r.Cached, r.Synthesized)
+	}
+	if r.Characters != 150 || r.SynthesisTime != 3*time.Second {
+		t.Errorf("characters = %d in %v, want 150 in 3s", r.Characters, r.SynthesisTime)
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	r := New("Book")
+	if r.Throughput() != 0 {
+		t.Error("unfinished run has a throughput")
+	}
+
+	r.AddChunk(300, false, time.Second)
+	r.Started = time.Now().Add(-10 * time.Second)
+	r.Finish("completed")
+
+	if tp := r.Throughput(); tp < 29 || tp > 30.1 {
+		t.Errorf("throughput = %.2f, want about 30 chars/s", tp)
+	}
+}
+
+func TestSave(t *testing.T) {
+	r := New("Book")
+	r.AddChunk(10, false, time.Second)
+	r.SetConcurrency(Concurrency{Auto: true, Min: 1, Max: 4, Initial: 2, Final: 3})
+	r.Finish("completed")
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := r.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Report
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Book != "Book" || saved.Result != "completed" || saved.Synthesized != 1 || saved.Concurrency.Final != 3 {
+		t.Errorf("saved report for %q is %q with %d synthesized and %d final workers", saved.Book, saved.Result, saved.Synthesized, saved.Concurrency.Final)
+	}
+}
+
+func TestFinishSortsFallbacks(t *testing.T) {
+	r := New("Book")
+	r.AddFallback(Fallback{Chapter: 2, Part: 1, Step: "silence"})
+	r.AddFallback(Fallback{Chapter: 1, Part: 3, Step: "split"})
+	r.AddFallback(Fallback{Chapter: 1, Part: 2, Step: "split"})
+	r.Finish("completed")
+
+	var got []int
+	for _, f := range r.Fallbacks {
+		got = append(got, f.Chapter*10+f.Part)
+	}
+	if !slices.Equal(got, []int{12, 13, 21}) {
+		t.Errorf("fallbacks in chapter/part order %v, want [12 13 21]", got)
+	}
+}
+
+func TestFinishSortsOutputs(t *testing.T) {
+	r := New("Book")
+	r.AddOutput(Output{Path: "out/book.mp3"})
+	r.AddOutput(Output{Path: "out/book.m4b", Error: "failed"})
+	r.Finish("failed")
+
+	if r.Outputs[0].Path != "out/book.m4b" || r.Outputs[1].Path != "out/book.mp3" {
+		t.Errorf("outputs = %+v, want them in path order", r.Outputs)
+	}
+}
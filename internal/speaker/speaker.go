@@ -0,0 +1,138 @@
+package speaker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pixellini/go-audiobook/internal/audioqa"
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/fsutils"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// truePeak is the ceiling used when normalizing, in dBTP.
+const truePeak = -1.5
+
+// Check is what was measured for a reference clip, and anything that makes it a poor one to clone from.
+type Check struct {
+	Path       string   `json:"path"`
+	Duration   float64  `json:"duration"`
+	SampleRate int      `json:"sample_rate"`
+	Channels   int      `json:"channels"`
+	Level      float64  `json:"level"`
+	NoiseFloor float64  `json:"noise_floor"`
+	Clipped    float64  `json:"clipped"`
+	Issues     []string `json:"issues,omitempty"`
+}
+
+// Ok reports whether nothing wrong was found.
+func (c Check) Ok() bool {
+	return len(c.Issues) == 0
+}
+
+// Validate checks the reference clip at path. An error means it couldn't be read as a WAV file at all.
+func Validate(path string, c config.Speaker) (Check, error) {
+	audio, err := wav.ReadFile(path)
+	if err != nil {
+		return Check{Path: path}, fmt.Errorf("speaker clip %s is not a readable WAV file: %w", path, err)
+	}
+
+	mono := audioqa.Mono(audio)
+	check := Check{
+		Path:       path,
+		Duration:   audio.Duration(),
+		SampleRate: audio.SampleRate,
+		Channels:   audio.Channels,
+		Level:      audioqa.Level(mono),
+		NoiseFloor: audioqa.NoiseFloor(mono, audio.SampleRate),
+		Clipped:    audioqa.ClippedRatio(mono),
+	}
+
+	if audio.SampleRate < c.MinSampleRate {
+		check.Issues = append(check.Issues, fmt.Sprintf("sample rate of %d Hz is below %d Hz", audio.SampleRate, c.MinSampleRate))
+	}
+
+	switch {
+	case check.Duration < c.MinDuration.Seconds():
+		check.Issues = append(check.Issues, fmt.Sprintf("%.1fs long, at least %v is needed", check.Duration, c.MinDuration))
+	case c.MaxDuration > 0 && check.Duration > c.MaxDuration.Seconds():
+		check.Issues = append(check.Issues, fmt.Sprintf("%.1fs long, at most %v is used well", check.Duration, c.MaxDuration))
+	}
+
+	if check.Level < c.MinLevel {
+		check.Issues = append(check.Issues, fmt.Sprintf("too quiet: %.1f dBFS", check.Level))
+	}
+
+	// Pauses in clean speech are close to silent, background noise or music keeps them loud.
+	if check.NoiseFloor > c.MaxNoiseFloor {
+		check.Issues = append(check.Issues, fmt.Sprintf("noisy background: %.1f dBFS between words", check.NoiseFloor))
+	}
+
+	if check.Clipped > c.MaxClipping {
+		check.Issues = append(check.Issues, fmt.Sprintf("clipping in %.2f%% of samples", check.Clipped*100))
+	}
+
+	return check, nil
+}
+
+// Prepare preprocesses the clip at path into dir: silence is trimmed from both ends, it is cut to the maximum duration,
+// mixed down to mono, normalized and resampled. Any format ffmpeg can read is accepted.
+// The result is named after the clip's contents and the settings, so each clip is only prepared once.
+func Prepare(ctx context.Context, audio audioservice.AudioService, path, dir string, c config.Speaker) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read speaker clip: %w", err)
+	}
+
+	filters := Filters(c)
+
+	h := sha256.New()
+	h.Write(data)
+	h.Write([]byte(strings.Join(filters, ",")))
+	output := filepath.Join(dir, hex.EncodeToString(h.Sum(nil))[:16]+".wav")
+
+	if fsutils.FileExists(output) {
+		return output, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Write next to the result and rename, so an interrupted run never leaves a partial clip behind.
+	tmp := strings.TrimSuffix(output, ".wav") + ".tmp.wav"
+	defer os.Remove(tmp)
+
+	if err := audio.ApplyFilters(ctx, path, tmp, filters); err != nil {
+		return "", fmt.Errorf("failed to prepare speaker clip %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp, output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// Filters is the ffmpeg filter chain Prepare applies.
+func Filters(c config.Speaker) []string {
+	// silenceremove only trims the start, so trim the reversed clip for the end.
+	trim := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%gdB", c.TrimLevel)
+	filters := []string{trim, "areverse", trim, "areverse"}
+
+	if c.MaxDuration > 0 {
+		filters = append(filters, fmt.Sprintf("atrim=duration=%g", c.MaxDuration.Seconds()))
+	}
+
+	// loudnorm works at a high sample rate, so resample after it.
+	return append(filters,
+		fmt.Sprintf("loudnorm=I=%g:TP=%g", c.TargetLoudness, truePeak),
+		fmt.Sprintf("aresample=%d", c.SampleRate),
+		"aformat=sample_fmts=s16:channel_layouts=mono",
+	)
+}
@@ -0,0 +1,168 @@
+package speaker
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/audioservice"
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+var speakerConfig = config.Speaker{
+	MinDuration:    3 * time.Second,
+	MaxDuration:    30 * time.Second,
+	MinSampleRate:  16000,
+	SampleRate:     22050,
+	MinLevel:       -35,
+	MaxNoiseFloor:  -45,
+	TrimLevel:      -50,
+	TargetLoudness: -20,
+	MaxClipping:    0.001,
+}
+
+// writeSpeech writes words of tone at amplitude, separated by pauses filled with noise at the given amplitude.
+func writeSpeech(t *testing.T, sampleRate, words int, amplitude, noise float64) string {
+	t.Helper()
+
+	var samples []int16
+	sample := func(v float64) {
+		samples = append(samples, int16(max(-32768, min(32767, v*32768))))
+	}
+	for range words {
+		for i := range sampleRate / 2 {
+			sample(amplitude * math.Sin(2*math.Pi*220*float64(i)/float64(sampleRate)))
+		}
+		for i := range sampleRate / 5 {
+			sample(noise * math.Sin(2*math.Pi*3000*float64(i)/float64(sampleRate)))
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "speaker.wav")
+	if err := wav.WriteFile(path, sampleRate, samples); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		words      int
+		amplitude  float64
+		noise      float64
+		issue      string
+	}{
+		{"good", 22050, 8, 0.3, 0, ""},
+		{"short", 22050, 2, 0.3, 0, "at least 3s is needed"},
+		{"long", 22050, 50, 0.3, 0, "at most 30s is used well"},
+		{"low sample rate", 8000, 8, 0.3, 0, "sample rate of 8000 Hz"},
+		{"quiet", 22050, 8, 0.01, 0, "too quiet"},
+		{"noisy", 22050, 8, 0.3, 0.05, "noisy background"},
+		{"clipped", 22050, 8, 2, 0, "clipping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, err := Validate(writeSpeech(t, tt.sampleRate, tt.words, tt.amplitude, tt.noise), speakerConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.issue == "" {
+				if !check.Ok() {
+					t.Errorf("issues = %q, want none", check.Issues)
+				}
+				return
+			}
+			if len(check.Issues) != 1 || !strings.Contains(check.Issues[0], tt.issue) {
+				t.Errorf("issues = %q, want just %q", check.Issues, tt.issue)
+			}
+		})
+	}
+}
+
+func TestValidateNotWAV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speaker.mp3")
+	if err := os.WriteFile(path, []byte("ID3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Validate(path, speakerConfig); err == nil {
+		t.Error("expected an error for a clip that isn't WAV")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	want := []string{
+		"silenceremove=start_periods=1:start_threshold=-50dB", "areverse",
+		"silenceremove=start_periods=1:start_threshold=-50dB", "areverse",
+		"atrim=duration=30",
+		"loudnorm=I=-20:TP=-1.5",
+		"aresample=22050",
+		"aformat=sample_fmts=s16:channel_layouts=mono",
+	}
+	if got := Filters(speakerConfig); !slices.Equal(got, want) {
+		t.Errorf("Filters = %q, want %q", got, want)
+	}
+}
+
+// copyAudio applies filters by copying the input, and counts how often it's asked to.
+type copyAudio struct {
+	audioservice.AudioService
+	calls int
+}
+
+func (c *copyAudio) ApplyFilters(ctx context.Context, inputFile, outputFile string, filters []string) error {
+	c.calls++
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFile, data, 0644)
+}
+
+func TestPrepare(t *testing.T) {
+	clip := writeSpeech(t, 22050, 8, 0.3, 0)
+	dir := filepath.Join(t.TempDir(), "speakers")
+	audio := &copyAudio{}
+
+	path, err := Prepare(context.Background(), audio, clip, dir, speakerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != dir || filepath.Ext(path) != ".wav" {
+		t.Errorf("prepared clip is %s, want a WAV file in %s", path, dir)
+	}
+
+	// The same clip and settings are only prepared once
+	again, err := Prepare(context.Background(), audio, clip, dir, speakerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path || audio.calls != 1 {
+		t.Errorf("second prepare gave %s after %d runs, want %s after 1", again, audio.calls, path)
+	}
+
+	// Other settings are another clip
+	louder := speakerConfig
+	louder.TargetLoudness = -16
+	other, err := Prepare(context.Background(), audio, clip, dir, louder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == path {
+		t.Error("changing the settings reused the prepared clip")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("speaker dir has %d files, want just the 2 prepared clips", len(entries))
+	}
+}
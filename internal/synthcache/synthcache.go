@@ -0,0 +1,177 @@
+package synthcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// version is part of every key. Bump it when the way audio is produced changes,
+// so older cached audio is no longer used.
+const version = "1"
+
+const tempDir = "tmp"
+
+// Cache stores synthesized audio under a hash of the text and everything else that affects how it sounds.
+// The same chunk of text is only synthesized once, no matter which chapter, book or run it came from.
+type Cache struct {
+	dir string
+	// tmp is where new audio is written before it's committed.
+	tmp     string
+	counter atomic.Uint64
+}
+
+func New(dir string) (*Cache, error) {
+	tmp := filepath.Join(dir, tempDir)
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create synthesis cache: %w", err)
+	}
+
+	return &Cache{dir: dir, tmp: tmp}, nil
+}
+
+// WithTempDir returns a cache that shares c's audio but writes new audio to tmp first,
+// so each job keeps its unfinished files to itself. tmp must be on the same file system as the cache.
+func (c *Cache) WithTempDir(tmp string) (*Cache, error) {
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create synthesis directory: %w", err)
+	}
+
+	return &Cache{dir: c.dir, tmp: tmp}, nil
+}
+
+// TempDir is where new audio is written before it's committed. TTS services should write their output relative to it.
+func (c *Cache) TempDir() string {
+	return c.tmp
+}
+
+// Key returns the cache key for text synthesized with the given fingerprint.
+// The fingerprint should describe the backend, model, voice, language and any synthesis settings.
+func Key(text, fingerprint string) string {
+	h := sha256.New()
+	h.Write([]byte(version))
+	h.Write([]byte{0})
+	h.Write([]byte(fingerprint))
+	h.Write([]byte{0})
+	h.Write([]byte(Normalize(text)))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Normalize collapses whitespace so formatting-only changes don't cause new synthesis.
+func Normalize(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Path is where the audio for key is stored once it's in the cache.
+func (c *Cache) Path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".wav")
+}
+
+// Lookup returns the path of the cached audio for key, if it exists.
+// The audio is marked as used, so Prune keeps it over audio nothing has asked for in a while.
+func (c *Cache) Lookup(key string) (string, bool) {
+	path := c.Path(key)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+// TempName returns a unique name, relative to TempDir, for new audio to be written to before it's committed.
+// Audio is only moved into place when it's complete, so an interrupted run never leaves a broken entry.
+func (c *Cache) TempName(key string) string {
+	return fmt.Sprintf("%s-%d-%d.wav", key, os.Getpid(), c.counter.Add(1))
+}
+
+// Commit moves the audio written to tempName into the cache and returns its final path.
+func (c *Cache) Commit(key, tempName string) (string, error) {
+	path := c.Path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(filepath.Join(c.tmp, tempName), path); err != nil {
+		return "", fmt.Errorf("failed to commit %s to cache: %w", key, err)
+	}
+
+	return path, nil
+}
+
+// Discard removes audio that was written to tempName but won't be committed.
+func (c *Cache) Discard(tempName string) {
+	os.Remove(filepath.Join(c.tmp, tempName))
+}
+
+// Pruned is what Prune removed.
+type Pruned struct {
+	Files int
+	Bytes int64
+}
+
+// Prune removes audio that hasn't been used for longer than maxAge, then the least recently used audio
+// until the cache is no bigger than maxSize. A zero limit isn't applied.
+func (c *Cache) Prune(maxSize int64, maxAge time.Duration) (Pruned, error) {
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+
+	var (
+		entries []entry
+		total   int64
+	)
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == filepath.Join(c.dir, tempDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".wav" {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: path, size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return Pruned{}, fmt.Errorf("failed to read synthesis cache: %w", err)
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int { return a.used.Compare(b.used) })
+
+	var pruned Pruned
+	for _, e := range entries {
+		stale := maxAge > 0 && time.Since(e.used) > maxAge
+		if !stale && (maxSize <= 0 || total <= maxSize) {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			return pruned, err
+		}
+		total -= e.size
+		pruned.Files++
+		pruned.Bytes += e.size
+	}
+
+	return pruned, nil
+}
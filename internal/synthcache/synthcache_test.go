@@ -0,0 +1,186 @@
+package synthcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	key := Key("It was a bright cold day in April.", "coqui|tts_models/en/vctk/vits|p225")
+
+	// Keys are stored on disk, so they mustn't change between versions of the program
+	const want = "0f1e2f96a29dc22a36145286ebb2aa2a01031a42334d6cf49d5403ea906abdd0"
+	if key != want {
+		t.Errorf("key = %s, want %s", key, want)
+	}
+	if again := Key("It was a bright cold day in April.", "coqui|tts_models/en/vctk/vits|p225"); again != key {
+		t.Errorf("key changed between calls: %s, %s", key, again)
+	}
+	if got := Key("  It was a bright\ncold day\tin April. ", "coqui|tts_models/en/vctk/vits|p225"); got != key {
+		t.Errorf("whitespace changed the key: %s, want %s", got, key)
+	}
+
+	for name, other := range map[string]string{
+		"text":        Key("It was a bright cold day in May.", "coqui|tts_models/en/vctk/vits|p225"),
+		"fingerprint": Key("It was a bright cold day in April.", "coqui|tts_models/en/vctk/vits|p226"),
+		// The separator keeps text from running into the fingerprint
+		"boundary": Key("p225It was a bright cold day in April.", "coqui|tts_models/en/vctk/vits|"),
+	} {
+		if other == key {
+			t.Errorf("a different %s gave the same key", name)
+		}
+	}
+}
+
+func TestCommit(t *testing.T) {
+	c, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := Key("text", "fake")
+	if _, ok := c.Lookup(key); ok {
+		t.Fatal("found audio in an empty cache")
+	}
+
+	temp := c.TempName(key)
+	if temp == c.TempName(key) {
+		t.Error("temporary names aren't unique")
+	}
+	if err := os.WriteFile(filepath.Join(c.TempDir(), temp), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := c.Commit(key, temp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != c.Path(key) {
+		t.Errorf("committed to %s, want %s", path, c.Path(key))
+	}
+	if found, ok := c.Lookup(key); !ok || found != path {
+		t.Errorf("Lookup = %s, %v, want %s", found, ok, path)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	c, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := Key("text", "fake")
+	temp := c.TempName(key)
+	if err := os.WriteFile(filepath.Join(c.TempDir(), temp), []byte("half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Discard(temp)
+	if _, err := os.Stat(filepath.Join(c.TempDir(), temp)); !os.IsNotExist(err) {
+		t.Errorf("temporary audio is still there: %v", err)
+	}
+	if _, ok := c.Lookup(key); ok {
+		t.Error("discarded audio is in the cache")
+	}
+}
+
+func TestWithTempDir(t *testing.T) {
+	shared, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(t.TempDir(), "synth")
+	c, err := shared.WithTempDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TempDir() != tmp {
+		t.Errorf("temp dir = %s, want %s", c.TempDir(), tmp)
+	}
+
+	key := Key("text", "fake")
+	temp := c.TempName(key)
+	if err := os.WriteFile(filepath.Join(tmp, temp), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Commit(key, temp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Committed audio is shared
+	if _, ok := shared.Lookup(key); !ok {
+		t.Error("audio committed from the temp dir isn't in the shared cache")
+	}
+}
+
+// put commits audio of the given size for text, last used age ago.
+func put(t *testing.T, c *Cache, text string, size int, age time.Duration) string {
+	t.Helper()
+
+	key := Key(text, "fake")
+	temp := c.TempName(key)
+	if err := os.WriteFile(filepath.Join(c.TempDir(), temp), make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := c.Commit(key, temp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := time.Now().Add(-age)
+	if err := os.Chtimes(path, used, used); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPrune(t *testing.T) {
+	c, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := put(t, c, "old", 100, 48*time.Hour)
+	older := put(t, c, "older", 100, 72*time.Hour)
+	recent := put(t, c, "recent", 100, time.Hour)
+	unfinished := filepath.Join(c.TempDir(), c.TempName("x"))
+	if err := os.WriteFile(unfinished, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is over the limits
+	if p, err := c.Prune(0, 0); err != nil || p.Files != 0 {
+		t.Errorf("Prune without limits = %+v, %v, want nothing removed", p, err)
+	}
+
+	// The least recently used goes first
+	p, err := c.Prune(250, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Files != 1 || p.Bytes != 100 {
+		t.Errorf("pruned %+v, want one file of 100 bytes", p)
+	}
+	if _, ok := c.Lookup(older); ok {
+		t.Error("the least recently used audio was kept")
+	}
+
+	// Looking audio up marks it as used
+	c.Lookup(old)
+	stale := put(t, c, "stale", 100, 48*time.Hour)
+	if p, err := c.Prune(0, 24*time.Hour); err != nil || p.Files != 1 {
+		t.Errorf("Prune by age = %+v, %v, want only the stale audio removed", p, err)
+	}
+	if _, ok := c.Lookup(stale); ok {
+		t.Error("stale audio was kept")
+	}
+	if _, ok := c.Lookup(old); !ok {
+		t.Error("audio that was just used was pruned")
+	}
+	if _, ok := c.Lookup(recent); !ok {
+		t.Error("recent audio was pruned")
+	}
+	if _, err := os.Stat(unfinished); err != nil {
+		t.Errorf("unfinished audio was pruned: %v", err)
+	}
+}
@@ -93,6 +93,43 @@ func SplitIntoParagraphs(text string) []string {
 	return result
 }
 
+// SplitSentences splits text on sentence boundaries.
+func SplitSentences(text string) []string {
+	formattedText := sentenceRegex.ReplaceAllString(text, "$1"+textDelimiter+"$2")
+
+	var result []string
+	for _, sentence := range strings.Split(formattedText, textDelimiter) {
+		if sentence = strings.TrimSpace(sentence); sentence != "" {
+			result = append(result, sentence)
+		}
+	}
+
+	return result
+}
+
+// SplitClauses splits text into sentences, and each sentence at commas, semicolons and colons.
+// The pieces are as short as they can sensibly be, for text the TTS engine keeps failing on.
+func SplitClauses(text string) []string {
+	var result []string
+	for _, sentence := range SplitSentences(text) {
+		start := 0
+		for i, r := range sentence {
+			if r != ',' && r != ';' && r != ':' {
+				continue
+			}
+			if clause := strings.TrimSpace(sentence[start : i+1]); clause != "" {
+				result = append(result, clause)
+			}
+			start = i + 1
+		}
+		if clause := strings.TrimSpace(sentence[start:]); clause != "" {
+			result = append(result, clause)
+		}
+	}
+
+	return result
+}
+
 // splitLongText splits long text on sentences, and if still too long, on commas
 func splitLongText(text string) []string {
 	// Try splitting on sentence boundaries first.
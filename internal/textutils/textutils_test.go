@@ -0,0 +1,33 @@
+package textutils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"One sentence.", []string{"One sentence."}},
+		{"First one. Second one! Third one? Fourth.", []string{"First one.", "Second one!", "Third one?", "Fourth."}},
+		// Only a capital letter starts a new sentence
+		{"It cost 3.50 in total. e.g. this stays together.", []string{"It cost 3.50 in total. e.g. this stays together."}},
+		{"  ", nil},
+	}
+
+	for _, tt := range tests {
+		if got := SplitSentences(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("SplitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitClauses(t *testing.T) {
+	got := SplitClauses("Well, this is awkward; it keeps failing: badly. Fine.")
+	want := []string{"Well,", "this is awkward;", "it keeps failing:", "badly.", "Fine."}
+	if !slices.Equal(got, want) {
+		t.Errorf("SplitClauses = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,160 @@
+package ttsservice
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// Capabilities describes what a TTS engine supports.
+type Capabilities struct {
+	Speakers       []string `json:"speakers,omitempty"`
+	Languages      []string `json:"languages,omitempty"`
+	SampleRate     int      `json:"sample_rate,omitempty"`
+	MaxInputLength int      `json:"max_input_length,omitempty"`
+	// Concurrency is the number of requests the engine can work on at once.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Controls are the prosody settings the engine applies itself, see ControlRate and friends.
+	Controls []string `json:"controls,omitempty"`
+}
+
+// The voices and limits of OpenAI's own speech API. Other servers with the same API may differ.
+const (
+	openAIHost           = "api.openai.com"
+	openAISampleRate     = 24000
+	openAIMaxInputLength = 4096
+)
+
+var openAIVoices = []string{"alloy", "ash", "ballad", "coral", "echo", "fable", "nova", "onyx", "sage", "shimmer", "verse"}
+
+// quotedRegex finds the quoted names in the lists the Coqui command line tool prints.
+var quotedRegex = regexp.MustCompile(`'([^']+)'`)
+
+// CheckVoice returns an error if the engine lists its speakers or languages and the configured ones aren't among them.
+func CheckVoice(caps Capabilities, c *config.Config) error {
+	if len(c.Model.SpeakerClips()) == 0 && len(caps.Speakers) > 0 {
+		voice := c.Voice()
+		if !slices.Contains(caps.Speakers, voice) {
+			return fmt.Errorf("the model has no speaker %q, run \"voices list\" to see the speakers it has", voice)
+		}
+	}
+
+	if language := string(c.Model.Language); language != "" && len(caps.Languages) > 0 && !slices.Contains(caps.Languages, language) {
+		return fmt.Errorf("the model doesn't support language %q, it supports %s", language, strings.Join(caps.Languages, ", "))
+	}
+
+	return nil
+}
+
+// CheckConfigVoice checks the configured speaker and language before an engine is started, for the backends that
+// can tell without one. Plugins only say what they support once they run, so they're checked when they start,
+// and so is a Coqui model that can't be asked now.
+func CheckConfigVoice(ctx context.Context, c *config.Config) error {
+	var caps Capabilities
+	switch {
+	case c.TestMode:
+		return nil
+	case c.Model.Backend == BackendCoqui || c.Model.Backend == "":
+		if _, err := exec.LookPath(coquiExecutable); err != nil {
+			return nil
+		}
+		var err error
+		if caps, err = coquiCapabilities(ctx, coquiModel(c)); err != nil {
+			return nil
+		}
+	case c.Model.Backend == BackendHTTP:
+		h, err := NewHTTPService(c, "")
+		if err != nil {
+			return err
+		}
+		if caps, err = h.Capabilities(ctx); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	return CheckVoice(caps, c)
+}
+
+func (c *CoquiTTSService) Capabilities(ctx context.Context) (Capabilities, error) {
+	return coquiCapabilities(ctx, c.model)
+}
+
+// coquiModels keeps what the Coqui command line tool listed for each model, since every listing loads the model.
+var coquiModels = struct {
+	mu   sync.Mutex
+	caps map[string]Capabilities
+}{caps: map[string]Capabilities{}}
+
+// coquiCapabilities returns the model's speakers and languages, asking the Coqui command line tool the first time.
+// A listing that fails is tried again next time.
+func coquiCapabilities(ctx context.Context, model string) (Capabilities, error) {
+	coquiModels.mu.Lock()
+	defer coquiModels.mu.Unlock()
+
+	if caps, ok := coquiModels.caps[model]; ok {
+		return caps, nil
+	}
+
+	speakers, err := listCoqui(ctx, model, "--list_speaker_idxs")
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	languages, err := listCoqui(ctx, model, "--list_language_idxs")
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	caps := Capabilities{Speakers: speakers, Languages: languages}
+	coquiModels.caps[model] = caps
+	return caps, nil
+}
+
+// listCoqui runs the Coqui command line tool with a listing flag and returns the names it prints.
+// Models without speakers or languages print nothing to list.
+func listCoqui(ctx context.Context, model, flag string) ([]string, error) {
+	out, err := exec.CommandContext(ctx, coquiExecutable, "--model_name", model, flag).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("coqui %s failed: %w\n%s", flag, err, out)
+	}
+
+	text := string(out)
+	i := strings.Index(text, "Available")
+	if i < 0 {
+		return nil, nil
+	}
+
+	var names []string
+	for _, m := range quotedRegex.FindAllStringSubmatch(text[i:], -1) {
+		names = append(names, m[1])
+	}
+	return names, nil
+}
+
+func (h *HTTPTTSService) Capabilities(ctx context.Context) (Capabilities, error) {
+	if h.config.Schema == SchemaCoqui {
+		return Capabilities{}, nil
+	}
+
+	caps := Capabilities{Controls: []string{ControlRate}}
+	if u, err := url.Parse(h.endpoint); err == nil && u.Hostname() == openAIHost {
+		caps.Speakers = openAIVoices
+		caps.SampleRate = openAISampleRate
+		caps.MaxInputLength = openAIMaxInputLength
+	}
+
+	return caps, nil
+}
+
+func (f *FakeTTSService) Capabilities(ctx context.Context) (Capabilities, error) {
+	return Capabilities{SampleRate: f.sampleRate}, nil
+}
@@ -0,0 +1,161 @@
+package ttsservice
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestCheckVoice(t *testing.T) {
+	caps := Capabilities{Speakers: []string{"p225", "p226"}, Languages: []string{"en", "fr"}}
+
+	tests := []struct {
+		name      string
+		configure func(c *config.Config)
+		err       string
+	}{
+		{"known speaker", func(c *config.Config) { c.Model.SpeakerIdx = "p225" }, ""},
+		{"unknown speaker", func(c *config.Config) { c.Model.SpeakerIdx = "p999" }, `no speaker "p999"`},
+		// Cloned voices aren't in the list
+		{"speaker wav", func(c *config.Config) { c.Model.SpeakerWav = "voice.wav" }, ""},
+		{"known language", func(c *config.Config) { c.Model.SpeakerIdx = "p226"; c.Model.Language = "fr" }, ""},
+		{"unknown language", func(c *config.Config) { c.Model.SpeakerIdx = "p226"; c.Model.Language = "de" }, `language "de"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config.Config{}
+			tt.configure(c)
+
+			err := CheckVoice(caps, c)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("err = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+
+	// Engines that can't list anything accept every voice
+	c := &config.Config{}
+	c.Model.SpeakerIdx = "anyone"
+	c.Model.Language = "xx"
+	if err := CheckVoice(Capabilities{}, c); err != nil {
+		t.Errorf("unexpected error without lists: %v", err)
+	}
+}
+
+// resetCoquiModels forgets the Coqui model listings, so a test sees its own fake tool.
+func resetCoquiModels(t *testing.T) {
+	coquiModels.caps = map[string]Capabilities{}
+	t.Cleanup(func() { coquiModels.caps = map[string]Capabilities{} })
+}
+
+func TestCoquiCapabilities(t *testing.T) {
+	resetCoquiModels(t)
+	s, _ := newTestCoqui(t, &config.Config{})
+
+	caps, err := s.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(caps.Speakers, []string{"p225", "p226"}) || len(caps.Languages) != 0 {
+		t.Errorf("speakers = %q, languages = %q, want two speakers and no languages", caps.Speakers, caps.Languages)
+	}
+}
+
+func TestCoquiCapabilitiesCached(t *testing.T) {
+	resetCoquiModels(t)
+	s, _ := newTestCoqui(t, &config.Config{})
+	if _, err := s.Capabilities(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listing loads the model, so it isn't asked again
+	t.Setenv("PATH", t.TempDir())
+	caps, err := s.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(caps.Speakers, []string{"p225", "p226"}) {
+		t.Errorf("speakers = %q, want the ones listed before", caps.Speakers)
+	}
+}
+
+func TestCheckConfigVoice(t *testing.T) {
+	resetCoquiModels(t)
+	newTestCoqui(t, &config.Config{})
+	ctx := context.Background()
+
+	c := &config.Config{}
+	c.Model.SpeakerIdx = "p999"
+	if err := CheckConfigVoice(ctx, c); err == nil || !strings.Contains(err.Error(), `no speaker "p999"`) {
+		t.Errorf("err = %v, want the unknown speaker reported", err)
+	}
+
+	c.Model.SpeakerIdx = "p226"
+	if err := CheckConfigVoice(ctx, c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Plugins and the test engine are checked once they run
+	for _, configure := range []func(c *config.Config){
+		func(c *config.Config) { c.TestMode = true },
+		func(c *config.Config) { c.Model.Backend = BackendPlugin },
+	} {
+		c := &config.Config{}
+		c.Model.SpeakerIdx = "p999"
+		configure(c)
+		if err := CheckConfigVoice(ctx, c); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// Without the Coqui tool there's nothing to ask
+	resetCoquiModels(t)
+	t.Setenv("PATH", t.TempDir())
+	c.Model.SpeakerIdx = "p999"
+	if err := CheckConfigVoice(ctx, c); err != nil {
+		t.Errorf("unexpected error without coqui: %v", err)
+	}
+}
+
+func TestHTTPCapabilities(t *testing.T) {
+	h := newTestService(t, nil, nil)
+	caps, err := h.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another server with OpenAI's API may have any voices
+	if len(caps.Speakers) != 0 || !slices.Equal(caps.Controls, []string{ControlRate}) {
+		t.Errorf("capabilities = %+v, want only the rate control", caps)
+	}
+
+	openAI := newTestService(t, nil, func(c *config.Config) { c.HTTP.URL = "https://api.openai.com" })
+	caps, err = openAI.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(caps.Speakers, "alloy") || caps.SampleRate != openAISampleRate || caps.MaxInputLength != openAIMaxInputLength {
+		t.Errorf("capabilities = %+v, want OpenAI's voices and limits", caps)
+	}
+}
+
+func TestPluginCapabilities(t *testing.T) {
+	p, err := newTestPlugin(t, "prosody", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caps, err := p.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(caps.Speakers, []string{"a", "b"}) || !slices.Equal(caps.Controls, []string{ControlRate, ControlGain}) {
+		t.Errorf("capabilities = %+v, want the ones from the hello message", caps)
+	}
+}
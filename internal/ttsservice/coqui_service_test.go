@@ -0,0 +1,259 @@
+package ttsservice
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// fakeCoqui is a stand-in for the Coqui command line tool.
+// It logs its arguments, prints some output and writes the text to --out_path.
+// Listing speakers prints two, and listing languages prints none since the model only has one.
+// It fails while $FAKE_TTS_FAILURES is more than the number of calls so far.
+const fakeCoqui = `#!/bin/sh
+case "$*" in
+*--list_speaker_idxs*)
+	echo " > Available speaker ids: (Set --speaker_idx flag to one of these values to use the multi-speaker model."
+	echo "{'p225': 0, 'p226': 1}"
+	exit 0 ;;
+*--list_language_idxs*)
+	exit 0 ;;
+esac
+echo "$@" >> "$FAKE_TTS_DIR/args"
+calls=$(wc -l < "$FAKE_TTS_DIR/args")
+printf ' > Processing\r > Done\n'
+if [ "$calls" -le "${FAKE_TTS_FAILURES:-0}" ]; then
+	echo "model exploded" >&2
+	exit 1
+fi
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--text) text=$2 ;;
+	--out_path) out=$2 ;;
+	esac
+	shift
+done
+printf '%s' "$text" > "$out"
+`
+
+// newTestCoqui puts the fake Coqui tool on the PATH and returns a service using it.
+func newTestCoqui(t *testing.T, c *config.Config) (*CoquiTTSService, string) {
+	t.Helper()
+
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, coquiExecutable), []byte(fakeCoqui), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_TTS_DIR", bin)
+
+	outputDir := t.TempDir()
+	s, err := NewCoquiService(c, outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, bin
+}
+
+func coquiCalls(t *testing.T, dir string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestCoquiSynthesize(t *testing.T) {
+	c := &config.Config{}
+	c.Model.SpeakerIdx = "p225"
+	c.Model.Device = "cpu"
+	s, dir := newTestCoqui(t, c)
+
+	data, err := s.Synthesize("Hello there.", "part-0-1.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello there." {
+		t.Errorf("got %q, want the text back", data)
+	}
+	if _, err := os.Stat(filepath.Join(s.outputDir, "part-0-1.wav")); err != nil {
+		t.Errorf("output wasn't written to the output directory: %v", err)
+	}
+
+	calls := coquiCalls(t, dir)
+	if len(calls) != 1 {
+		t.Fatalf("coqui ran %d times, want 1", len(calls))
+	}
+	for _, arg := range []string{"--model_name tts_models/en/vctk/vits", "--speaker_idx p225", "--device cpu", "--text Hello there."} {
+		if !strings.Contains(calls[0], arg) {
+			t.Errorf("args %q are missing %q", calls[0], arg)
+		}
+	}
+}
+
+func TestCoquiSpeakerWav(t *testing.T) {
+	c := &config.Config{}
+	c.Model.SpeakerIdx = "p225"
+	c.Model.SpeakerWav = "/voices/narrator.wav"
+	s, dir := newTestCoqui(t, c)
+
+	if _, err := s.Synthesize("Hi.", "part-0-1.wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	args := coquiCalls(t, dir)[0]
+	if !strings.Contains(args, "--speaker_wav /voices/narrator.wav") || strings.Contains(args, "--speaker_idx") {
+		t.Errorf("args %q should use the speaker wav instead of the speaker index", args)
+	}
+}
+
+func TestCoquiSpeakerWavs(t *testing.T) {
+	c := &config.Config{}
+	c.Model.SpeakerWav = "/voices/one.wav"
+	c.Model.SpeakerWavs = []string{"/voices/two.wav"}
+	s, dir := newTestCoqui(t, c)
+
+	if _, err := s.Synthesize("Hi.", "part-0-1.wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	if args := coquiCalls(t, dir)[0]; !strings.Contains(args, "--speaker_wav /voices/one.wav /voices/two.wav") {
+		t.Errorf("args %q should pass every clip", args)
+	}
+}
+
+func TestCoquiRetry(t *testing.T) {
+	c := &config.Config{}
+	c.Model.MaxRetries = 1
+	s, dir := newTestCoqui(t, c)
+	t.Setenv("FAKE_TTS_FAILURES", "1")
+
+	if _, err := s.Synthesize("Again.", "part-0-1.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := coquiCalls(t, dir); len(calls) != 2 {
+		t.Errorf("coqui ran %d times, want 2", len(calls))
+	}
+}
+
+func TestCoquiError(t *testing.T) {
+	s, _ := newTestCoqui(t, &config.Config{})
+	t.Setenv("FAKE_TTS_FAILURES", "1")
+
+	_, err := s.Synthesize("Nope.", "part-0-1.wav")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// The tail of the engine output explains what went wrong
+	if !strings.Contains(err.Error(), "model exploded") {
+		t.Errorf("error %q doesn't include the engine output", err)
+	}
+}
+
+func TestCoquiLogs(t *testing.T) {
+	c := &config.Config{VerboseLogs: true}
+	s, _ := newTestCoqui(t, c)
+	var logs bytes.Buffer
+	s.logs = &logs
+
+	if _, err := s.Synthesize("Hi.", "part-3-7.wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"[part-3-7]  > Processing\n", "[part-3-7]  > Done\n"} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("logs %q are missing %q", logs.String(), line)
+		}
+	}
+}
+
+func TestCoquiCancel(t *testing.T) {
+	s, _ := newTestCoqui(t, &config.Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.SynthesizeContext(ctx, "Hi.", "part-0-1.wav"); err == nil {
+		t.Error("expected an error after cancelling")
+	}
+}
+
+func TestCoquiModelName(t *testing.T) {
+	resetCoquiModels(t)
+	c := &config.Config{}
+	c.Model.Name = "tts_models/en/ljspeech/vits"
+	s, dir := newTestCoqui(t, c)
+
+	if _, err := s.Synthesize("Hi.", "part-0-1.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Capabilities(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range coquiCalls(t, dir) {
+		if !strings.Contains(args, "--model_name tts_models/en/ljspeech/vits") {
+			t.Errorf("args %q should use the configured model", args)
+		}
+	}
+}
+
+func TestCoquiVocoder(t *testing.T) {
+	c := &config.Config{}
+	c.Vocoder.Name = "vocoder"
+	c.Vocoder.Language = "de"
+	s, dir := newTestCoqui(t, c)
+
+	if _, err := s.Synthesize("Hallo.", "part-0-1.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if args := coquiCalls(t, dir)[0]; !strings.Contains(args, "--vocoder_name vocoder_models/de/thorsten/hifigan_v1") {
+		t.Errorf("args %q should use the German vocoder", args)
+	}
+
+	c.Vocoder.Language = "fr"
+	if _, err := NewCoquiService(c, t.TempDir()); err == nil {
+		t.Error("expected an error for a language without a vocoder")
+	}
+}
+
+func TestCoquiMissing(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	if _, err := NewCoquiService(&config.Config{}, t.TempDir()); err == nil {
+		t.Error("expected an error when the tts command isn't installed")
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "[a] ")
+
+	for _, s := range []string{"one\ntw", "o\r\n", "\n   \n", "three\rfour"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Blank lines are dropped and the unfinished line is held back
+	if want := "[a] one\n[a] two\n[a] three\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{limit: 8}
+	tail.Write([]byte("first line\n"))
+	tail.Write([]byte("last\n"))
+
+	if got := tail.String(); got != "ne\nlast" {
+		t.Errorf("got %q, want the last 8 bytes trimmed", got)
+	}
+}
@@ -0,0 +1,60 @@
+package ttsservice
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// coquiWorkerScript is a small Python program that keeps a Coqui model loaded and speaks the plugin protocol.
+//
+//go:embed coqui_worker.py
+var coquiWorkerScript []byte
+
+// NewCoquiWorkerService starts persistent Coqui workers, one for each concurrent chunk.
+// The model is loaded once per worker rather than once per chunk, and the model's default vocoder is used.
+func NewCoquiWorkerService(c *config.Config, outputDir string) (*PluginTTSService, error) {
+	python, err := exec.LookPath(c.Model.Python)
+	if err != nil {
+		return nil, fmt.Errorf("python interpreter %q not found: %w", c.Model.Python, err)
+	}
+
+	script := filepath.Join(outputDir, "coqui_worker.py")
+	if err := os.WriteFile(script, coquiWorkerScript, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write coqui worker script: %w", err)
+	}
+
+	args := []string{
+		script,
+		"--model_name", coquiModel(c),
+		"--language", string(c.Model.Language),
+	}
+	if clips := c.Model.SpeakerClips(); len(clips) > 0 {
+		args = append(args, "--speaker_wav")
+		args = append(args, clips...)
+	} else {
+		args = append(args, "--speaker_idx", c.Model.SpeakerIdx)
+	}
+	if c.Model.Device != "" {
+		args = append(args, "--device", string(c.Model.Device))
+	}
+
+	spec := config.Plugin{
+		Command:          python,
+		Args:             args,
+		HandshakeTimeout: c.Plugin.HandshakeTimeout,
+	}
+
+	// With auto concurrency, more workers are loaded as the tuner asks for them
+	size := int(c.Model.Concurrency)
+	limit := size
+	if c.Model.AutoConcurrency {
+		limit = int(c.Model.MaxConcurrency)
+	}
+
+	return newPluginService(c, spec, size, limit, "coqui", outputDir)
+}
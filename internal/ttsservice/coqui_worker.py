@@ -0,0 +1,87 @@
+"""Persistent Coqui TTS worker for go-audiobook.
+
+Loads the model once and then serves synthesis requests using the
+go-audiobook plugin protocol (JSON lines over stdin/stdout).
+"""
+
+import argparse
+import json
+import os
+import random
+import sys
+import wave
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--model_name", required=True)
+    parser.add_argument("--speaker_idx")
+    parser.add_argument("--speaker_wav", nargs="+")
+    parser.add_argument("--language")
+    parser.add_argument("--device", default="cpu")
+    args = parser.parse_args()
+
+    # Keep a private handle on stdout for the protocol, and send everything
+    # else (including output from native libraries) to stderr.
+    protocol = os.fdopen(os.dup(sys.stdout.fileno()), "w", buffering=1)
+    os.dup2(sys.stderr.fileno(), sys.stdout.fileno())
+
+    from TTS.api import TTS
+
+    tts = TTS(model_name=args.model_name, progress_bar=False).to(args.device)
+
+    def send(message):
+        protocol.write(json.dumps(message) + "\n")
+        protocol.flush()
+
+    send({
+        "type": "hello",
+        "protocol": 1,
+        "name": "coqui",
+        "capabilities": {
+            "speakers": tts.speakers or [],
+            "languages": tts.languages or [],
+            "sample_rate": tts.synthesizer.output_sample_rate,
+            "concurrency": 1,
+        },
+    })
+
+    for line in sys.stdin:
+        line = line.strip()
+        if not line:
+            continue
+
+        request = json.loads(line)
+        try:
+            if request.get("seed") is not None:
+                seed(request["seed"])
+
+            kwargs = {"text": request["text"], "file_path": request["output"]}
+            if args.speaker_wav:
+                kwargs["speaker_wav"] = args.speaker_wav
+            elif tts.is_multi_speaker:
+                kwargs["speaker"] = request.get("voice") or args.speaker_idx
+            if tts.is_multi_lingual:
+                kwargs["language"] = request.get("language") or args.language
+
+            tts.tts_to_file(**kwargs)
+
+            with wave.open(request["output"], "rb") as w:
+                duration = w.getnframes() / float(w.getframerate())
+
+            send({"id": request["id"], "status": "ok", "duration": duration})
+        except Exception as e:
+            send({"id": request["id"], "status": "error", "error": str(e)})
+
+
+def seed(value):
+    import numpy
+    import torch
+
+    random.seed(value)
+    numpy.random.seed(value)
+    torch.manual_seed(value)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,77 @@
+package ttsservice
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestCoquiWorkerService(t *testing.T) {
+	// The fake interpreter logs what it was asked to run, then acts as a plugin
+	bin := t.TempDir()
+	python := filepath.Join(bin, "python3")
+	script := "#!/bin/sh\necho \"$@\" >> \"" + filepath.Join(bin, "args") + "\"\nexec \"" + testPluginCommand + "\" -test.run='^TestPluginProcess$'\n"
+	if err := os.WriteFile(python, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(pluginModeEnv, "ok")
+
+	c := &config.Config{}
+	c.Model.Python = python
+	c.Model.Concurrency = 2
+	// Further workers only start when the tuner asks for them
+	c.Model.AutoConcurrency = true
+	c.Model.MaxConcurrency = 8
+	c.Model.Language = "en"
+	c.Model.SpeakerIdx = "p225"
+	c.Model.Device = "cuda"
+	c.Model.Name = "tts_models/en/ljspeech/vits"
+
+	outputDir := t.TempDir()
+	s, err := NewCoquiWorkerService(c, outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	data, err := s.SynthesizeContext(context.Background(), "Hello.", "part-0-1.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req pluginRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Text != "Hello." || req.Voice != "p225" {
+		t.Errorf("request = %+v", req)
+	}
+
+	logged, err := os.ReadFile(filepath.Join(bin, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts := strings.Split(strings.TrimSpace(string(logged)), "\n")
+	if len(starts) != 2 {
+		t.Fatalf("started %d workers, want model.concurrency", len(starts))
+	}
+	wantScript := filepath.Join(outputDir, "coqui_worker.py")
+	for _, arg := range []string{wantScript, "--model_name tts_models/en/ljspeech/vits", "--language en", "--speaker_idx p225", "--device cuda"} {
+		if !strings.Contains(starts[0], arg) {
+			t.Errorf("args %q are missing %q", starts[0], arg)
+		}
+	}
+	if written, err := os.ReadFile(wantScript); err != nil || string(written) != string(coquiWorkerScript) {
+		t.Errorf("worker script wasn't written to the output directory: %v", err)
+	}
+}
+
+func TestCoquiWorkerMissingPython(t *testing.T) {
+	c := &config.Config{}
+	c.Model.Python = filepath.Join(t.TempDir(), "python3")
+
+	if _, err := NewCoquiWorkerService(c, t.TempDir()); err == nil {
+		t.Error("expected an error when python isn't installed")
+	}
+}
@@ -0,0 +1,82 @@
+package ttsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// ErrTimeout is returned when the engine took longer than a chunk's deadline on every attempt.
+var ErrTimeout = errors.New("synthesis timed out")
+
+// errEngineKilled is returned for requests that were in flight on an engine process the watchdog killed.
+var errEngineKilled = errors.New("engine process killed after a request timed out")
+
+// deadlineService gives every synthesis attempt a deadline scaled by the length of the text.
+// The deadline reaches the engine through the context, so a hung process is killed and replaced,
+// and the chunk is tried again like any other failure.
+type deadlineService struct {
+	TTSservice
+	base       time.Duration
+	perChar    time.Duration
+	maxRetries int
+}
+
+// withDeadline wraps service with the timeouts from the model config. It returns service as is if they're disabled.
+func withDeadline(service TTSservice, m config.Model) TTSservice {
+	if m.Timeout <= 0 && m.TimeoutPerChar <= 0 {
+		return service
+	}
+
+	return &deadlineService{
+		TTSservice: service,
+		base:       m.Timeout,
+		perChar:    m.TimeoutPerChar,
+		maxRetries: int(m.MaxRetries),
+	}
+}
+
+// Timeout is how long one attempt at synthesizing text may take.
+func (d *deadlineService) Timeout(text string) time.Duration {
+	return d.base + d.perChar*time.Duration(utf8.RuneCountInString(text))
+}
+
+func (d *deadlineService) Synthesize(text, output string) ([]byte, error) {
+	return d.SynthesizeContext(context.Background(), text, output)
+}
+
+func (d *deadlineService) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	timeout := d.Timeout(text)
+
+	var err error
+	for attempt := 0; attempt <= d.maxRetries; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		var audio []byte
+		audio, err = d.TTSservice.SynthesizeContext(attemptCtx, text, output)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if err == nil {
+			return audio, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		switch {
+		case timedOut:
+			err = fmt.Errorf("%w after %v", ErrTimeout, timeout)
+		case errors.Is(err, errEngineKilled):
+			// Another chunk's timeout took this one down with it
+		default:
+			// The backend has already retried everything else
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,123 @@
+package ttsservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// stubTTS answers each attempt with the next function in attempts, and fails once they run out.
+type stubTTS struct {
+	TTSservice
+	attempts []func(ctx context.Context) error
+	calls    int
+}
+
+func (s *stubTTS) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	s.calls++
+	if s.calls > len(s.attempts) {
+		return nil, errors.New("no more attempts")
+	}
+	if err := s.attempts[s.calls-1](ctx); err != nil {
+		return nil, err
+	}
+	return []byte("audio"), nil
+}
+
+// hang waits for the attempt's deadline, like a stuck engine process.
+func hang(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func succeed(context.Context) error { return nil }
+
+func newTestDeadline(stub *stubTTS, retries uint8) *deadlineService {
+	return withDeadline(stub, config.Model{Timeout: 20 * time.Millisecond, TimeoutPerChar: time.Millisecond, MaxRetries: retries}).(*deadlineService)
+}
+
+func TestDeadlineTimeout(t *testing.T) {
+	d := newTestDeadline(&stubTTS{}, 0)
+	if got := d.Timeout("Hello."); got != 26*time.Millisecond {
+		t.Errorf("timeout = %v, want 26ms", got)
+	}
+	// Characters, not bytes
+	if got := d.Timeout("héllo"); got != 25*time.Millisecond {
+		t.Errorf("timeout = %v, want 25ms", got)
+	}
+
+	if s := withDeadline(&stubTTS{}, config.Model{MaxRetries: 3}); s == nil {
+		t.Fatal("no service without timeouts")
+	} else if _, ok := s.(*deadlineService); ok {
+		t.Error("wrapped the service with the timeouts disabled")
+	}
+}
+
+func TestDeadlineRetriesTimeouts(t *testing.T) {
+	stub := &stubTTS{attempts: []func(context.Context) error{hang, hang, succeed}}
+	d := newTestDeadline(stub, 2)
+
+	audio, err := d.SynthesizeContext(context.Background(), "Hello.", "chunk.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(audio) != "audio" || stub.calls != 3 {
+		t.Errorf("got %q after %d attempts, want audio after 3", audio, stub.calls)
+	}
+}
+
+func TestDeadlineGivesUp(t *testing.T) {
+	stub := &stubTTS{attempts: []func(context.Context) error{hang, hang}}
+	d := newTestDeadline(stub, 1)
+
+	_, err := d.SynthesizeContext(context.Background(), "Hello.", "chunk.wav")
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if stub.calls != 2 {
+		t.Errorf("%d attempts, want 2", stub.calls)
+	}
+}
+
+func TestDeadlineRetriesKilledEngine(t *testing.T) {
+	killed := func(context.Context) error { return errEngineKilled }
+	stub := &stubTTS{attempts: []func(context.Context) error{killed, succeed}}
+
+	if _, err := newTestDeadline(stub, 1).SynthesizeContext(context.Background(), "Hello.", "chunk.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if stub.calls != 2 {
+		t.Errorf("%d attempts, want 2", stub.calls)
+	}
+}
+
+func TestDeadlineOtherErrors(t *testing.T) {
+	// The backend already retried these
+	failed := func(context.Context) error { return errors.New("no such voice") }
+	stub := &stubTTS{attempts: []func(context.Context) error{failed, succeed}}
+
+	if _, err := newTestDeadline(stub, 3).SynthesizeContext(context.Background(), "Hello.", "chunk.wav"); err == nil || err.Error() != "no such voice" {
+		t.Errorf("err = %v, want the backend's error", err)
+	}
+	if stub.calls != 1 {
+		t.Errorf("%d attempts, want 1", stub.calls)
+	}
+}
+
+func TestDeadlineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stub := &stubTTS{attempts: []func(context.Context) error{func(ctx context.Context) error {
+		cancel()
+		return hang(ctx)
+	}, succeed}}
+
+	if _, err := newTestDeadline(stub, 3).SynthesizeContext(ctx, "Hello.", "chunk.wav"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want it cancelled", err)
+	}
+	if stub.calls != 1 {
+		t.Errorf("%d attempts, want 1", stub.calls)
+	}
+}
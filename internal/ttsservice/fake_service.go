@@ -0,0 +1,152 @@
+package ttsservice
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-audiobook/internal/wav"
+)
+
+// Signals the fake service can generate.
+const (
+	SignalSilence = "silence"
+	SignalTone    = "tone"
+	SignalPattern = "pattern"
+)
+
+const (
+	fakeToneHz      = 440
+	fakeBitZeroHz   = 660
+	fakeBitOneHz    = 990
+	fakeAmplitude   = 0.2 * math.MaxInt16
+	fakeMinDuration = 0.25 // seconds
+
+	// Each ID bit is a short beep followed by a gap.
+	fakeIdBits    = 16
+	fakeBitLength = 0.05
+	fakeBitGap    = 0.025
+	fakeFade      = 0.005
+)
+
+// FakeTTSService writes deterministic WAV files without running a real engine.
+// The clip length is proportional to the text length, so chapter timings behave like a real run.
+type FakeTTSService struct {
+	outputDir      string
+	signal         string
+	charsPerSecond float64
+	sampleRate     int
+}
+
+func NewFakeService(c *config.Config, outputDir string) (*FakeTTSService, error) {
+	switch c.Test.Signal {
+	case SignalSilence, SignalTone, SignalPattern:
+	case "":
+		c.Test.Signal = SignalTone
+	default:
+		return nil, fmt.Errorf("unknown test signal %q", c.Test.Signal)
+	}
+
+	f := &FakeTTSService{
+		outputDir:      outputDir,
+		signal:         c.Test.Signal,
+		charsPerSecond: c.Test.CharsPerSecond,
+		sampleRate:     c.Test.SampleRate,
+	}
+	if f.charsPerSecond <= 0 {
+		f.charsPerSecond = 15
+	}
+	if f.sampleRate <= 0 {
+		f.sampleRate = 22050
+	}
+
+	return f, nil
+}
+
+func (f *FakeTTSService) Synthesize(text, output string) ([]byte, error) {
+	return f.SynthesizeContext(context.Background(), text, output)
+}
+
+func (f *FakeTTSService) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	seconds := float64(utf8.RuneCountInString(text)) / f.charsPerSecond
+	if seconds < fakeMinDuration {
+		seconds = fakeMinDuration
+	}
+
+	var samples []int16
+	switch f.signal {
+	case SignalSilence:
+		samples = make([]int16, int(seconds*float64(f.sampleRate)))
+	case SignalTone:
+		samples = f.tone(fakeToneHz, seconds)
+	case SignalPattern:
+		samples = append(f.idPattern(output), f.tone(fakeToneHz, seconds)...)
+	}
+
+	data, err := wav.EncodeBytes(f.sampleRate, samples)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(f.outputDir, output), data, 0644); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (f *FakeTTSService) Close() error {
+	return nil
+}
+
+// idPattern encodes a 16-bit ID derived from the output name as beeps, most significant bit first.
+// A low beep is a 0 and a high beep is a 1.
+func (f *FakeTTSService) idPattern(output string) []int16 {
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Base(output)))
+	id := uint16(h.Sum32())
+
+	var samples []int16
+	gap := make([]int16, int(fakeBitGap*float64(f.sampleRate)))
+
+	for bit := fakeIdBits - 1; bit >= 0; bit-- {
+		hz := float64(fakeBitZeroHz)
+		if id&(1<<bit) != 0 {
+			hz = fakeBitOneHz
+		}
+		samples = append(samples, f.tone(hz, fakeBitLength)...)
+		samples = append(samples, gap...)
+	}
+
+	return samples
+}
+
+// tone generates a sine wave with short fades to avoid clicks at the joins.
+func (f *FakeTTSService) tone(hz, seconds float64) []int16 {
+	n := int(seconds * float64(f.sampleRate))
+	fade := int(fakeFade * float64(f.sampleRate))
+	samples := make([]int16, n)
+
+	for i := range samples {
+		gain := 1.0
+		if i < fade {
+			gain = float64(i) / float64(fade)
+		} else if n-i < fade {
+			gain = float64(n-i) / float64(fade)
+		}
+
+		t := float64(i) / float64(f.sampleRate)
+		samples[i] = int16(fakeAmplitude * gain * math.Sin(2*math.Pi*hz*t))
+	}
+
+	return samples
+}
@@ -0,0 +1,131 @@
+package ttsservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func newTestFake(t *testing.T, signal string) *FakeTTSService {
+	t.Helper()
+
+	c := &config.Config{TestMode: true}
+	c.Test = config.Test{Signal: signal, CharsPerSecond: 10, SampleRate: 8000}
+
+	f, err := NewFakeService(c, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// samples returns the 16-bit samples of a WAV file written by the fake service.
+func samples(t *testing.T, data []byte) []int16 {
+	t.Helper()
+
+	if len(data) < 44 || string(data[0:4]) != "RIFF" || string(data[36:40]) != "data" {
+		t.Fatalf("not a WAV file: %q", data[:min(len(data), 44)])
+	}
+	s := make([]int16, (len(data)-44)/2)
+	binary.Read(bytes.NewReader(data[44:]), binary.LittleEndian, s)
+	return s
+}
+
+func TestFakeDuration(t *testing.T) {
+	f := newTestFake(t, SignalTone)
+
+	for _, tt := range []struct {
+		text    string
+		samples int
+	}{
+		// Ten characters a second at 8kHz
+		{strings.Repeat("a", 20), 16000},
+		{strings.Repeat("é", 20), 16000},
+		// Short text still gets a clip that can be heard
+		{"a", 2000},
+	} {
+		data, err := f.SynthesizeContext(context.Background(), tt.text, "chunk.wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(samples(t, data)); n != tt.samples {
+			t.Errorf("%q: %d samples, want %d", tt.text, n, tt.samples)
+		}
+	}
+}
+
+func TestFakeDeterministic(t *testing.T) {
+	f := newTestFake(t, SignalPattern)
+
+	a, err := f.SynthesizeContext(context.Background(), "Hello there.", "a.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := f.SynthesizeContext(context.Background(), "Hello there.", "a.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := f.SynthesizeContext(context.Background(), "Hello there.", "b.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(a, again) {
+		t.Error("the same chunk sounds different on a second run")
+	}
+	// The pattern identifies the chunk, so a misplaced clip can be heard
+	if bytes.Equal(a, b) {
+		t.Error("different chunks have the same pattern")
+	}
+
+	written, err := os.ReadFile(filepath.Join(f.outputDir, "b.wav"))
+	if err != nil || !bytes.Equal(written, b) {
+		t.Errorf("written file differs from the returned audio: %v", err)
+	}
+}
+
+func TestFakeSignals(t *testing.T) {
+	silence, err := newTestFake(t, SignalSilence).SynthesizeContext(context.Background(), "Hello.", "chunk.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range samples(t, silence) {
+		if s != 0 {
+			t.Fatalf("sample %d of silence is %d", i, s)
+		}
+	}
+
+	tone, err := newTestFake(t, SignalTone).SynthesizeContext(context.Background(), "Hello.", "chunk.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peak float64
+	for _, s := range samples(t, tone) {
+		peak = max(peak, float64(s))
+	}
+	if peak < fakeAmplitude*0.9 {
+		t.Errorf("tone peaks at %g, want about %g", peak, fakeAmplitude)
+	}
+
+	c := &config.Config{}
+	c.Test.Signal = "noise"
+	if _, err := NewFakeService(c, t.TempDir()); err == nil {
+		t.Error("expected an error for an unknown signal")
+	}
+}
+
+func TestFakeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := newTestFake(t, SignalTone).SynthesizeContext(ctx, "Hello.", "chunk.wav"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
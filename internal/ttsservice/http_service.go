@@ -0,0 +1,330 @@
+package ttsservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// HTTP API schemas understood by HTTPTTSService.
+const (
+	// SchemaOpenAI is the OpenAI-style JSON API at /v1/audio/speech.
+	SchemaOpenAI = "openai"
+	// SchemaCoqui is the Coqui tts-server API at /api/tts.
+	SchemaCoqui = "coqui"
+)
+
+const (
+	openAISpeechPath = "/v1/audio/speech"
+	coquiServerPath  = "/api/tts"
+
+	maxRetryBackoff = 30 * time.Second
+)
+
+// HTTPTTSService sends each chunk to a remote TTS server and writes the returned audio to the output directory.
+type HTTPTTSService struct {
+	client     *http.Client
+	config     config.HTTP
+	endpoint   string
+	voice      string
+	language   string
+	outputDir  string
+	maxRetries int
+	limiter    *rateLimiter
+}
+
+func NewHTTPService(c *config.Config, outputDir string) (*HTTPTTSService, error) {
+	if c.HTTP.URL == "" {
+		return nil, fmt.Errorf("http.url must be provided for the http backend")
+	}
+
+	var path string
+	switch c.HTTP.Schema {
+	case SchemaOpenAI, "":
+		path = openAISpeechPath
+	case SchemaCoqui:
+		path = coquiServerPath
+	default:
+		return nil, fmt.Errorf("unknown http schema %q", c.HTTP.Schema)
+	}
+
+	// Allow the URL to be either the server root or the full endpoint.
+	endpoint := strings.TrimRight(c.HTTP.URL, "/")
+	if !strings.HasSuffix(endpoint, path) {
+		endpoint += path
+	}
+
+	voice := c.HTTP.Voice
+	if voice == "" {
+		voice = c.Model.SpeakerIdx
+	}
+
+	return &HTTPTTSService{
+		client:     &http.Client{Timeout: c.HTTP.Timeout},
+		config:     c.HTTP,
+		endpoint:   endpoint,
+		voice:      voice,
+		language:   string(c.Model.Language),
+		outputDir:  outputDir,
+		maxRetries: int(c.Model.MaxRetries),
+		limiter:    newRateLimiter(c.HTTP.RateLimit),
+	}, nil
+}
+
+func (h *HTTPTTSService) Synthesize(text, output string) ([]byte, error) {
+	return h.SynthesizeContext(context.Background(), text, output)
+}
+
+func (h *HTTPTTSService) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= h.maxRetries; attempt++ {
+		if err := h.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		audio, retryAfter, err := h.request(ctx, text)
+		if err == nil {
+			if err := writeFileAtomic(filepath.Join(h.outputDir, output), audio); err != nil {
+				return nil, err
+			}
+			return audio, nil
+		}
+
+		lastErr = err
+		if retryAfter < 0 {
+			// Not retryable
+			break
+		}
+
+		if retryAfter == 0 {
+			retryAfter = backoff(attempt)
+		} else {
+			// The server asked everyone to slow down, not just this request.
+			h.limiter.Delay(retryAfter)
+		}
+
+		if err := sleepContext(ctx, retryAfter); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (h *HTTPTTSService) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// request performs a single synthesis request.
+// On failure, retryAfter is negative when the error is not worth retrying,
+// zero when the default backoff should be used, or the delay requested by the server.
+func (h *HTTPTTSService) request(ctx context.Context, text string) (audio []byte, retryAfter time.Duration, err error) {
+	req, err := h.newRequest(ctx, text)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("tts request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read tts response: %w", err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		if len(body) == 0 {
+			return nil, 0, fmt.Errorf("tts server returned no audio")
+		}
+		return body, 0, nil
+
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable:
+		return nil, parseRetryAfter(res.Header.Get("Retry-After")), httpStatusError(res, body)
+
+	case res.StatusCode >= 500:
+		return nil, 0, httpStatusError(res, body)
+
+	default:
+		return nil, -1, httpStatusError(res, body)
+	}
+}
+
+func (h *HTTPTTSService) newRequest(ctx context.Context, text string) (*http.Request, error) {
+	var (
+		req *http.Request
+		err error
+	)
+
+	switch h.config.Schema {
+	case SchemaCoqui:
+		form := url.Values{}
+		form.Set("text", text)
+		if h.voice != "" {
+			form.Set("speaker_id", h.voice)
+		}
+		if h.language != "" {
+			form.Set("language_id", h.language)
+		}
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	default:
+		body := map[string]any{
+			"model":           h.config.Model,
+			"input":           text,
+			"voice":           h.voice,
+			"response_format": "wav",
+		}
+		if rate := ProsodyFromContext(ctx).Rate; rate > 0 && rate != 1 {
+			body["speed"] = rate
+		}
+
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("Accept", "audio/wav")
+	if h.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.config.APIKey)
+	}
+	for k, v := range h.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
+
+func httpStatusError(res *http.Response, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	return fmt.Errorf("tts server responded with %s: %s", res.Status, msg)
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if at, err := http.ParseTime(v); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+func backoff(attempt int) time.Duration {
+	d := time.Second << attempt
+	if d <= 0 || d > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return d
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// writeFileAtomic writes to a temporary file first so a partially written chunk is never mistaken for a finished one.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rateLimiter spaces requests out evenly. It is shared by all workers.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	r := &rateLimiter{}
+	if perSecond > 0 {
+		r.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return r
+}
+
+// Wait blocks until the caller is allowed to send a request.
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait := time.Until(at); wait > 0 {
+		return sleepContext(ctx, wait)
+	}
+	return nil
+}
+
+// Delay holds back every request for at least d.
+func (r *rateLimiter) Delay(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if at := time.Now().Add(d); at.After(r.next) {
+		r.next = at
+	}
+}
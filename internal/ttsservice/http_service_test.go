@@ -0,0 +1,346 @@
+package ttsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+var testAudio = []byte("RIFF....WAVE")
+
+// newTestService returns an HTTP service pointed at the handler, writing to a temporary directory.
+func newTestService(t *testing.T, handler http.HandlerFunc, configure func(c *config.Config)) *HTTPTTSService {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c := &config.Config{}
+	c.HTTP.URL = server.URL
+	c.HTTP.Model = "tts-1"
+	c.Model.SpeakerIdx = "alloy"
+	if configure != nil {
+		configure(c)
+	}
+
+	h, err := NewHTTPService(c, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestHTTPOpenAIRequest(t *testing.T) {
+	var (
+		body    map[string]any
+		request *http.Request
+	)
+	h := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.Write(testAudio)
+	}, func(c *config.Config) {
+		c.HTTP.APIKey = "secret"
+		c.HTTP.Headers = map[string]string{"X-Project": "books"}
+	})
+
+	ctx := WithProsody(context.Background(), config.Prosody{Rate: 1.25})
+	audio, err := h.SynthesizeContext(ctx, "Hello there.", "chunk.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(audio) != string(testAudio) {
+		t.Errorf("audio = %q, want %q", audio, testAudio)
+	}
+	if saved, err := os.ReadFile(filepath.Join(h.outputDir, "chunk.wav")); err != nil || string(saved) != string(testAudio) {
+		t.Errorf("saved audio = %q, %v", saved, err)
+	}
+
+	if request.URL.Path != openAISpeechPath {
+		t.Errorf("path = %s, want %s", request.URL.Path, openAISpeechPath)
+	}
+	if got := request.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := request.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := request.Header.Get("X-Project"); got != "books" {
+		t.Errorf("X-Project = %q", got)
+	}
+
+	want := map[string]any{
+		"model":           "tts-1",
+		"input":           "Hello there.",
+		"voice":           "alloy",
+		"response_format": "wav",
+		"speed":           1.25,
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("body[%q] = %v, want %v", k, body[k], v)
+		}
+	}
+}
+
+func TestHTTPCoquiRequest(t *testing.T) {
+	var request *http.Request
+	h := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		request = r
+		w.Write(testAudio)
+	}, func(c *config.Config) {
+		c.HTTP.Schema = SchemaCoqui
+		c.Model.SpeakerIdx = "p225"
+		c.Model.Language = "en"
+	})
+
+	if _, err := h.SynthesizeContext(context.Background(), "Hello there.", "chunk.wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.URL.Path != coquiServerPath {
+		t.Errorf("path = %s, want %s", request.URL.Path, coquiServerPath)
+	}
+	if got := request.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want none without an API key", got)
+	}
+	for field, want := range map[string]string{"text": "Hello there.", "speaker_id": "p225", "language_id": "en"} {
+		if got := request.PostForm.Get(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+}
+
+// flaky fails the first request with status and headers, and answers the rest with audio.
+func flaky(status int, header map[string]string, requests *atomic.Int32, times *[]time.Time) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*times = append(*times, time.Now())
+		mu.Unlock()
+
+		if requests.Add(1) == 1 {
+			for k, v := range header {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte("try again"))
+			return
+		}
+		w.Write(testAudio)
+	}
+}
+
+func TestHTTPRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header func() string
+		min    time.Duration
+	}{
+		{"429 seconds", http.StatusTooManyRequests, func() string { return "1" }, 900 * time.Millisecond},
+		{"503 seconds", http.StatusServiceUnavailable, func() string { return "1" }, 900 * time.Millisecond},
+		// HTTP dates only have whole seconds, so the wait is somewhere in the second before
+		{"429 date", http.StatusTooManyRequests, func() string {
+			return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+		}, 900 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				requests atomic.Int32
+				times    []time.Time
+			)
+			h := newTestService(t, flaky(tt.status, map[string]string{"Retry-After": tt.header()}, &requests, &times), func(c *config.Config) {
+				c.Model.MaxRetries = 1
+			})
+
+			if _, err := h.SynthesizeContext(context.Background(), "text", "chunk.wav"); err != nil {
+				t.Fatal(err)
+			}
+			if n := requests.Load(); n != 2 {
+				t.Fatalf("requests = %d, want 2", n)
+			}
+			if wait := times[1].Sub(times[0]); wait < tt.min || wait > 3*time.Second {
+				t.Errorf("waited %v between attempts, want at least %v", wait, tt.min)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("seconds: got %v", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 58*time.Second || got > time.Minute {
+		t.Errorf("date: got %v", got)
+	}
+	for _, v := range []string{"", "0", "-5", "soon", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)} {
+		if got := parseRetryAfter(v); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %v, want 0", v, got)
+		}
+	}
+}
+
+func TestHTTPServerErrorBackoff(t *testing.T) {
+	var (
+		requests atomic.Int32
+		times    []time.Time
+	)
+	h := newTestService(t, flaky(http.StatusInternalServerError, nil, &requests, &times), func(c *config.Config) {
+		c.Model.MaxRetries = 2
+	})
+
+	if _, err := h.SynthesizeContext(context.Background(), "text", "chunk.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("requests = %d, want 2", n)
+	}
+	if wait := times[1].Sub(times[0]); wait < backoff(0) {
+		t.Errorf("waited %v after a server error, want at least %v", wait, backoff(0))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, maxRetryBackoff, maxRetryBackoff}
+	for attempt, w := range want {
+		if got := backoff(attempt); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, w)
+		}
+	}
+	if got := backoff(100); got != maxRetryBackoff {
+		t.Errorf("backoff(100) = %v, want %v", got, maxRetryBackoff)
+	}
+}
+
+func TestHTTPClientErrorNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	h := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unknown voice", http.StatusBadRequest)
+	}, func(c *config.Config) {
+		c.Model.MaxRetries = 3
+	})
+
+	start := time.Now()
+	_, err := h.SynthesizeContext(context.Background(), "text", "chunk.wav")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %v, want an immediate failure", elapsed)
+	}
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	h := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.Write(testAudio)
+	}, func(c *config.Config) {
+		c.HTTP.RateLimit = 10
+	})
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.SynthesizeContext(context.Background(), "text", fmt.Sprintf("chunk-%d.wav", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Four requests at ten a second take at least three intervals
+	if spread := times[len(times)-1].Sub(times[0]); spread < 280*time.Millisecond {
+		t.Errorf("requests spread over %v, want at least 300ms", spread)
+	}
+}
+
+func TestHTTPCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	h := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}, func(c *config.Config) {
+		c.Model.MaxRetries = 3
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := h.SynthesizeContext(ctx, "text", "chunk.wav")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v to notice the cancellation", elapsed)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var requests atomic.Int32
+	h := newTestService(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}, func(c *config.Config) {
+		c.HTTP.Timeout = 50 * time.Millisecond
+	})
+
+	_, err := h.SynthesizeContext(context.Background(), "text", "chunk.wav")
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1 without retries", n)
+	}
+
+	// A deadline on the context stops the request too
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	h.client.Timeout = 0
+	if _, err := h.SynthesizeContext(ctx, "text", "chunk.wav"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
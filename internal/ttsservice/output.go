@@ -0,0 +1,79 @@
+package ttsservice
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixWriter writes each complete line to dst with a prefix, so output from parallel engines can be told apart.
+// Whole lines are written in a single call to keep them from interleaving.
+type prefixWriter struct {
+	mu     sync.Mutex
+	dst    io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+func newPrefixWriter(dst io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{
+		dst:    dst,
+		prefix: []byte(prefix),
+	}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf = append(p.buf, b...)
+	for {
+		// Engines often redraw progress with carriage returns, treat them as line ends too.
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		line := p.buf[:i]
+		p.buf = p.buf[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		out := make([]byte, 0, len(p.prefix)+len(line)+1)
+		out = append(out, p.prefix...)
+		out = append(out, line...)
+		out = append(out, '\n')
+		if _, err := p.dst.Write(out); err != nil {
+			return len(b), err
+		}
+	}
+
+	return len(b), nil
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, b...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = t.buf[over:]
+	}
+
+	return len(b), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(bytes.TrimSpace(t.buf))
+}
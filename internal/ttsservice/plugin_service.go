@@ -0,0 +1,388 @@
+package ttsservice
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// The plugin protocol lets any executable act as a TTS engine.
+//
+// The plugin is started once and talks JSON lines over stdin/stdout:
+//
+//  1. The plugin writes a hello message as its first line:
+//     {"type":"hello","protocol":1,"name":"my-engine","capabilities":{"speakers":["a","b"],"concurrency":2}}
+//  2. The host writes one request per line:
+//     {"id":1,"text":"Hello.","voice":"a","language":"en","output":"/abs/path/chunk.wav"}
+//     A "seed" is included when a clip is being synthesized again and a different take is wanted.
+//     "rate", "pitch" (semitones) and "gain" (dB) are included if the plugin listed them in its "controls" capability.
+//  3. The plugin writes the WAV file to the output path and answers with a response line:
+//     {"id":1,"status":"ok","duration":1.25} or {"id":1,"status":"error","error":"reason"}
+//
+// Responses may arrive in any order. When the host closes stdin the plugin should exit.
+// Anything the plugin writes to stderr is treated as log output.
+const pluginProtocolVersion = 1
+
+type pluginHello struct {
+	Type         string       `json:"type"`
+	Protocol     int          `json:"protocol"`
+	Name         string       `json:"name"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+type pluginRequest struct {
+	Id       uint64  `json:"id"`
+	Text     string  `json:"text"`
+	Voice    string  `json:"voice,omitempty"`
+	Language string  `json:"language,omitempty"`
+	Output   string  `json:"output"`
+	Seed     *int64  `json:"seed,omitempty"`
+	Rate     float64 `json:"rate,omitempty"`
+	Pitch    float64 `json:"pitch,omitempty"`
+	Gain     float64 `json:"gain,omitempty"`
+}
+
+type pluginResponse struct {
+	Id       uint64  `json:"id"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+const (
+	pluginStatusOk    = "ok"
+	pluginStatusError = "error"
+)
+
+// PluginTTSService delegates synthesis to a pool of external plugin processes.
+type PluginTTSService struct {
+	pool      *pluginPool
+	voice     string
+	language  string
+	outputDir string
+}
+
+func NewPluginService(c *config.Config, outputDir string) (*PluginTTSService, error) {
+	if c.Plugin.Command == "" {
+		return nil, fmt.Errorf("plugin.command must be provided for the plugin backend")
+	}
+
+	return newPluginService(c, c.Plugin, c.Plugin.Instances, c.Plugin.Instances, "plugin", outputDir)
+}
+
+func newPluginService(c *config.Config, spec config.Plugin, size, limit int, name, outputDir string) (*PluginTTSService, error) {
+	stderr := func(index int) io.Writer {
+		if !c.VerboseLogs {
+			return io.Discard
+		}
+		return newPrefixWriter(os.Stderr, fmt.Sprintf("[%s %d] ", name, index))
+	}
+
+	pool, err := newPluginPool(spec, size, limit, stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PluginTTSService{
+		pool:      pool,
+		voice:     c.Model.SpeakerIdx,
+		language:  string(c.Model.Language),
+		outputDir: outputDir,
+	}, nil
+}
+
+// Name returns the name the plugin announced during the handshake.
+func (p *PluginTTSService) Name() string {
+	return p.pool.hello.Name
+}
+
+func (p *PluginTTSService) Synthesize(text, output string) ([]byte, error) {
+	return p.SynthesizeContext(context.Background(), text, output)
+}
+
+func (p *PluginTTSService) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
+	path, err := filepath.Abs(filepath.Join(p.outputDir, output))
+	if err != nil {
+		return nil, err
+	}
+
+	req := pluginRequest{
+		Text:     text,
+		Voice:    p.voice,
+		Language: p.language,
+		Output:   path,
+	}
+	if seed, ok := seedFromContext(ctx); ok {
+		req.Seed = &seed
+	}
+	if prosody := ProsodyFromContext(ctx); !prosody.IsNeutral() {
+		req.Rate, req.Pitch, req.Gain = prosody.Rate, prosody.Pitch, prosody.Gain
+	}
+
+	_, err = p.pool.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+func (p *PluginTTSService) Capabilities(ctx context.Context) (Capabilities, error) {
+	return p.pool.hello.Capabilities, nil
+}
+
+func (p *PluginTTSService) Close() error {
+	return p.pool.Close()
+}
+
+// pluginProcess is a running plugin that has completed the handshake.
+type pluginProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	hello pluginHello
+
+	// slots limits the in-flight requests to what the plugin advertised.
+	slots   chan struct{}
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextId  uint64
+	pending map[uint64]chan pluginResponse
+
+	done chan struct{}
+	err  error
+	// killed is the reason the watchdog killed the process, if it did.
+	killed error
+}
+
+func startPlugin(c config.Plugin, stderr io.Writer) (*pluginProcess, error) {
+	cmd := exec.Command(c.Command, c.Args...)
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+	for k, v := range c.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", c.Command, err)
+	}
+
+	p := &pluginProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[uint64]chan pluginResponse),
+		done:    make(chan struct{}),
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	hello := make(chan error, 1)
+	go func() {
+		hello <- p.readHello(scanner)
+		p.readLoop(scanner)
+	}()
+
+	timeout := c.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	select {
+	case err = <-hello:
+	case <-time.After(timeout):
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s handshake failed: %w", c.Command, err)
+	}
+
+	concurrency := p.hello.Capabilities.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p.slots = make(chan struct{}, concurrency)
+
+	return p, nil
+}
+
+func (p *pluginProcess) readHello(scanner *bufio.Scanner) error {
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &p.hello); err != nil {
+		return fmt.Errorf("invalid hello message: %w", err)
+	}
+	if p.hello.Type != "hello" {
+		return fmt.Errorf("expected hello message, got %q", p.hello.Type)
+	}
+	if p.hello.Protocol != pluginProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", p.hello.Protocol)
+	}
+
+	return nil
+}
+
+// readLoop hands each response to the request waiting for it, until the plugin exits.
+func (p *pluginProcess) readLoop(scanner *bufio.Scanner) {
+	for scanner.Scan() {
+		var res pluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			// Not a response, most likely stray output from the engine.
+			continue
+		}
+
+		p.mu.Lock()
+		ch, ok := p.pending[res.Id]
+		delete(p.pending, res.Id)
+		p.mu.Unlock()
+
+		if ok {
+			ch <- res
+		}
+	}
+
+	err := scanner.Err()
+	if waitErr := p.cmd.Wait(); waitErr != nil || err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		err = io.EOF
+	}
+
+	p.mu.Lock()
+	if p.killed != nil {
+		err = p.killed
+	}
+	p.err = fmt.Errorf("plugin exited: %w", err)
+	p.pending = nil
+	p.mu.Unlock()
+	close(p.done)
+}
+
+func (p *pluginProcess) call(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	select {
+	case p.slots <- struct{}{}:
+		defer func() { <-p.slots }()
+	case <-p.done:
+		return pluginResponse{}, p.err
+	case <-ctx.Done():
+		return pluginResponse{}, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.pending == nil {
+		p.mu.Unlock()
+		return pluginResponse{}, p.err
+	}
+	p.nextId++
+	req.Id = p.nextId
+	ch := make(chan pluginResponse, 1)
+	p.pending[req.Id] = ch
+	p.mu.Unlock()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		p.forget(req.Id)
+		return pluginResponse{}, err
+	}
+
+	p.writeMu.Lock()
+	_, err = p.stdin.Write(append(line, '\n'))
+	p.writeMu.Unlock()
+	if err != nil {
+		p.forget(req.Id)
+		return pluginResponse{}, fmt.Errorf("failed to send request to plugin: %w", err)
+	}
+
+	select {
+	case res := <-ch:
+		if res.Status != pluginStatusOk {
+			if res.Error == "" {
+				res.Error = "unknown error"
+			}
+			return res, errors.New(res.Error)
+		}
+		return res, nil
+	case <-p.done:
+		return pluginResponse{}, p.err
+	case <-ctx.Done():
+		p.forget(req.Id)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// The request ran out of time while the plugin was working on it, so assume the plugin is stuck.
+			// The pool starts a fresh process in its place.
+			p.kill(errEngineKilled)
+		}
+		return pluginResponse{}, ctx.Err()
+	}
+}
+
+// kill stops a plugin that has stopped responding. Requests still waiting on it fail with reason.
+func (p *pluginProcess) kill(reason error) {
+	p.mu.Lock()
+	if p.killed == nil {
+		p.killed = reason
+	}
+	p.mu.Unlock()
+
+	p.cmd.Process.Kill()
+}
+
+// exited reports whether the process has exited, or has been killed and is on its way out.
+func (p *pluginProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.killed != nil
+}
+
+func (p *pluginProcess) forget(id uint64) {
+	p.mu.Lock()
+	if p.pending != nil {
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+}
+
+// Close asks the plugin to exit by closing its stdin, and kills it if it doesn't.
+func (p *pluginProcess) Close() error {
+	p.stdin.Close()
+
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+
+	return nil
+}
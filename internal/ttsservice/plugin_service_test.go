@@ -0,0 +1,265 @@
+package ttsservice
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// pluginModeEnv makes the test binary act as a plugin, see TestPluginProcess.
+const pluginModeEnv = "GO_AUDIOBOOK_TEST_PLUGIN"
+
+// testPluginCommand is the test binary, which acts as a plugin when pluginModeEnv is set.
+var testPluginCommand = os.Args[0]
+
+func noStderr(int) io.Writer { return io.Discard }
+
+// TestPluginProcess isn't a real test. It's the plugin the other tests start, by running the test binary again.
+// The mode picks how it behaves. Each request's "audio" is the request itself, so tests can check what was sent.
+func TestPluginProcess(t *testing.T) {
+	mode := os.Getenv(pluginModeEnv)
+	if mode == "" {
+		t.Skip("only runs as a plugin")
+	}
+	defer os.Exit(0)
+
+	out := json.NewEncoder(os.Stdout)
+	switch mode {
+	case "silent":
+		// Never says hello, and exits when the host gives up
+		io.Copy(io.Discard, os.Stdin)
+		return
+	case "old":
+		out.Encode(pluginHello{Type: "hello", Protocol: pluginProtocolVersion + 1, Name: "old"})
+		return
+	}
+
+	capabilities := Capabilities{Speakers: []string{"a", "b"}, Concurrency: 1}
+	switch mode {
+	case "reorder":
+		capabilities.Concurrency = 2
+	case "prosody":
+		capabilities.Controls = []string{ControlRate, ControlGain}
+	}
+	out.Encode(pluginHello{
+		Type:         "hello",
+		Protocol:     pluginProtocolVersion,
+		Name:         "test-" + mode,
+		Capabilities: capabilities,
+	})
+
+	var held []pluginRequest
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req pluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+
+		// Any plugin can be made to crash or hang
+		switch req.Text {
+		case "crash":
+			os.Exit(1)
+		case "hang":
+			continue
+		}
+
+		switch mode {
+		case "crash":
+			os.Exit(1)
+		case "hang":
+			continue
+		case "error":
+			fmt.Println("stray engine output")
+			out.Encode(pluginResponse{Id: req.Id, Status: pluginStatusError, Error: "no such voice " + req.Voice})
+			continue
+		case "reorder":
+			// Answer the second request before the first
+			held = append(held, req)
+			if len(held) < 2 {
+				continue
+			}
+		default:
+			held = []pluginRequest{req}
+		}
+
+		for i := len(held) - 1; i >= 0; i-- {
+			req := held[i]
+			data, _ := json.Marshal(req)
+			if err := os.WriteFile(req.Output, data, 0644); err != nil {
+				out.Encode(pluginResponse{Id: req.Id, Status: pluginStatusError, Error: err.Error()})
+				continue
+			}
+			out.Encode(pluginResponse{Id: req.Id, Status: pluginStatusOk, Duration: 1})
+		}
+		held = nil
+	}
+}
+
+func newTestPlugin(t *testing.T, mode string, timeout time.Duration) (*PluginTTSService, error) {
+	t.Helper()
+
+	c := &config.Config{}
+	c.Model.SpeakerIdx = "a"
+	c.Model.Language = "en"
+	c.Plugin = config.Plugin{
+		Command:          testPluginCommand,
+		Args:             []string{"-test.run=^TestPluginProcess$"},
+		Env:              map[string]string{pluginModeEnv: mode},
+		HandshakeTimeout: timeout,
+	}
+
+	p, err := NewPluginService(c, t.TempDir())
+	if err == nil {
+		t.Cleanup(func() { p.Close() })
+	}
+	return p, err
+}
+
+func TestPluginSynthesize(t *testing.T) {
+	p, err := newTestPlugin(t, "ok", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "test-ok" {
+		t.Errorf("name = %q, want the one from the hello message", p.Name())
+	}
+
+	for i := range 2 {
+		data, err := p.SynthesizeContext(context.Background(), fmt.Sprintf("Hello %d.", i), fmt.Sprintf("chunk-%d.wav", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var req pluginRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Id != uint64(i+1) || req.Text != fmt.Sprintf("Hello %d.", i) || req.Voice != "a" || req.Language != "en" {
+			t.Errorf("request = %+v", req)
+		}
+		if want := fmt.Sprintf("chunk-%d.wav", i); !strings.HasSuffix(req.Output, want) || !strings.HasPrefix(req.Output, "/") {
+			t.Errorf("output = %q, want an absolute path ending in %s", req.Output, want)
+		}
+	}
+}
+
+func TestPluginSeed(t *testing.T) {
+	p, err := newTestPlugin(t, "ok", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []*int64{nil, ptr(int64(7))} {
+		ctx := context.Background()
+		if want != nil {
+			ctx = WithSeed(ctx, *want)
+		}
+
+		data, err := p.SynthesizeContext(ctx, "Hello.", "chunk.wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req pluginRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		if (req.Seed == nil) != (want == nil) || (want != nil && *req.Seed != *want) {
+			t.Errorf("seed = %v, want %v", req.Seed, want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestPluginResponsesOutOfOrder(t *testing.T) {
+	p, err := newTestPlugin(t, "reorder", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			text := fmt.Sprintf("Chunk %d.", i)
+			data, err := p.SynthesizeContext(context.Background(), text, fmt.Sprintf("chunk-%d.wav", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var req pluginRequest
+			if err := json.Unmarshal(data, &req); err != nil || req.Text != text {
+				t.Errorf("got the audio for %q, want %q", req.Text, text)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPluginError(t *testing.T) {
+	p, err := newTestPlugin(t, "error", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.SynthesizeContext(context.Background(), "Hello.", "chunk.wav")
+	if err == nil || err.Error() != "no such voice a" {
+		t.Errorf("err = %v, want the plugin's error", err)
+	}
+}
+
+func TestPluginHandshake(t *testing.T) {
+	if _, err := newTestPlugin(t, "old", 0); err == nil || !strings.Contains(err.Error(), "unsupported protocol version") {
+		t.Errorf("err = %v, want an unsupported protocol", err)
+	}
+
+	start := time.Now()
+	if _, err := newTestPlugin(t, "silent", 200*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a handshake timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("gave up on a silent plugin after %v", elapsed)
+	}
+
+	if _, err := NewPluginService(&config.Config{}, t.TempDir()); err == nil {
+		t.Error("expected an error without a command")
+	}
+}
+
+func TestPluginExit(t *testing.T) {
+	p, err := newTestPlugin(t, "crash", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.SynthesizeContext(context.Background(), "Hello.", "chunk.wav")
+	if err == nil || !strings.Contains(err.Error(), "plugin exited") {
+		t.Errorf("err = %v, want the plugin to have exited", err)
+	}
+}
+
+func TestPluginCancel(t *testing.T) {
+	p, err := newTestPlugin(t, "hang", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.SynthesizeContext(ctx, "Hello.", "chunk.wav"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline", err)
+	}
+}
@@ -0,0 +1,234 @@
+package ttsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"golang.org/x/sync/errgroup"
+)
+
+// restartDelay is how long a worker waits before starting again after a failed start.
+const restartDelay = 5 * time.Second
+
+var errPoolClosed = errors.New("worker pool is closed")
+
+// pluginPool keeps long-lived plugin processes running and hands each request to one with a free slot.
+// Processes that exit are restarted in the background, so a crash only costs the chunk that was in flight.
+type pluginPool struct {
+	spec   config.Plugin
+	stderr func(index int) io.Writer
+	// limit is the most workers the pool grows to when a request finds them all busy.
+	limit int
+
+	mu      sync.Mutex
+	workers []*poolWorker
+	// idle holds each worker once for every request it can take on, as advertised in the handshake.
+	idle  chan *poolWorker
+	slots int
+	hello pluginHello
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+type poolWorker struct {
+	pool  *pluginPool
+	index int
+
+	mu      sync.Mutex
+	process *pluginProcess
+}
+
+// newPluginPool starts size processes in parallel and waits for all of them to complete the handshake.
+// More are started as requests need them, up to limit.
+func newPluginPool(spec config.Plugin, size, limit int, stderr func(index int) io.Writer) (*pluginPool, error) {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &pluginPool{
+		spec:    spec,
+		stderr:  stderr,
+		limit:   max(size, limit),
+		workers: make([]*poolWorker, size),
+		closed:  make(chan struct{}),
+	}
+
+	var eg errgroup.Group
+	for i := range p.workers {
+		w := &poolWorker{pool: p, index: i}
+		p.workers[i] = w
+		eg.Go(func() error {
+			_, err := w.get()
+			return err
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	p.hello = p.workers[0].process.hello
+
+	p.slots = max(1, p.hello.Capabilities.Concurrency)
+	p.idle = make(chan *poolWorker, p.limit*p.slots)
+	for _, w := range p.workers {
+		p.serve(w)
+	}
+
+	return p, nil
+}
+
+// serve hands the worker's slots to requests and keeps its process running.
+func (p *pluginPool) serve(w *poolWorker) {
+	for range p.slots {
+		p.idle <- w
+	}
+	p.wg.Add(1)
+	go w.supervise()
+}
+
+// grow starts another worker in the background, unless the pool is at its limit.
+// The request that asked for it takes whichever worker is free first.
+func (p *pluginPool) grow() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.workers) >= p.limit {
+		return
+	}
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+
+	w := &poolWorker{pool: p, index: len(p.workers)}
+	p.workers = append(p.workers, w)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		if _, err := w.get(); err != nil {
+			// The running workers carry on, and a later request can try again
+			p.mu.Lock()
+			p.workers = slices.DeleteFunc(p.workers, func(o *poolWorker) bool { return o == w })
+			p.mu.Unlock()
+			return
+		}
+		p.serve(w)
+	}()
+}
+
+func (p *pluginPool) call(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	var w *poolWorker
+
+	select {
+	case w = <-p.idle:
+	default:
+		p.grow()
+		select {
+		case w = <-p.idle:
+		case <-p.closed:
+			return pluginResponse{}, errPoolClosed
+		case <-ctx.Done():
+			return pluginResponse{}, ctx.Err()
+		}
+	}
+	defer func() { p.idle <- w }()
+
+	process, err := w.get()
+	if err != nil {
+		return pluginResponse{}, err
+	}
+
+	return process.call(ctx, req)
+}
+
+func (p *pluginPool) Close() error {
+	p.closeOnce.Do(func() {
+		// Under the lock, so grow either sees the pool closed or has added its worker to wg already
+		p.mu.Lock()
+		close(p.closed)
+		p.mu.Unlock()
+	})
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, w := range p.workers {
+		if w == nil {
+			continue
+		}
+		w.mu.Lock()
+		if w.process != nil {
+			errs = append(errs, w.process.Close())
+			w.process = nil
+		}
+		w.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// get returns the worker's process, starting a new one if it isn't running.
+func (w *poolWorker) get() (*pluginProcess, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.process != nil && !w.process.exited() {
+		return w.process, nil
+	}
+
+	select {
+	case <-w.pool.closed:
+		return nil, errPoolClosed
+	default:
+	}
+
+	process, err := startPlugin(w.pool.spec, w.pool.stderr(w.index))
+	if err != nil {
+		return nil, fmt.Errorf("worker %d: %w", w.index, err)
+	}
+	w.process = process
+
+	return process, nil
+}
+
+// supervise restarts the process as soon as it exits, so the model is loaded again before the next chunk arrives.
+func (w *poolWorker) supervise() {
+	defer w.pool.wg.Done()
+
+	for {
+		w.mu.Lock()
+		process := w.process
+		w.mu.Unlock()
+
+		if process != nil {
+			select {
+			case <-w.pool.closed:
+				return
+			case <-process.done:
+			}
+		}
+
+		if _, err := w.get(); err != nil {
+			select {
+			case <-w.pool.closed:
+				return
+			case <-time.After(restartDelay):
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package ttsservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func newTestPool(t *testing.T, mode string, size, limit int) *pluginPool {
+	t.Helper()
+
+	spec := config.Plugin{
+		Command: testPluginCommand,
+		Args:    []string{"-test.run=^TestPluginProcess$"},
+		Env:     map[string]string{pluginModeEnv: mode},
+	}
+
+	p, err := newPluginPool(spec, size, limit, noStderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func (w *poolWorker) pid() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.process == nil {
+		return 0
+	}
+	return w.process.cmd.Process.Pid
+}
+
+// running counts the workers with a process.
+func (p *pluginPool) running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, w := range p.workers {
+		if w.pid() != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPoolStartsEveryWorker(t *testing.T) {
+	p := newTestPool(t, "ok", 3, 3)
+
+	if len(p.workers) != 3 {
+		t.Fatalf("started %d workers, want 3", len(p.workers))
+	}
+	pids := make(map[int]bool)
+	for _, w := range p.workers {
+		pids[w.pid()] = true
+	}
+	if len(pids) != 3 || pids[0] {
+		t.Errorf("workers share processes: %v", pids)
+	}
+	if p.hello.Name != "test-ok" {
+		t.Errorf("hello = %+v, want the workers' hello", p.hello)
+	}
+}
+
+func TestPoolRunsRequestsInParallel(t *testing.T) {
+	p := newTestPool(t, "hang", 2, 2)
+
+	// Both workers get stuck on a request, so a third has to wait for one of them
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.call(ctx, pluginRequest{Text: "Hello.", Output: "/dev/null"})
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := len(p.idle); n != 0 {
+		t.Errorf("%d workers idle, want both busy", n)
+	}
+	wg.Wait()
+
+	if n := len(p.idle); n != 2 {
+		t.Errorf("%d workers idle after the requests gave up, want 2", n)
+	}
+}
+
+func TestPoolGrows(t *testing.T) {
+	p := newTestPool(t, "ok", 1, 2)
+
+	// Requests that find every worker busy start another one, up to the limit
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.call(ctx, pluginRequest{Text: "hang", Output: "/dev/null"})
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && (p.running() < 2 || len(p.idle) != 0) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	running := p.running()
+	cancel()
+	wg.Wait()
+
+	if running != 2 {
+		t.Errorf("%d workers running, want the pool to grow to 2", running)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.workers) != 2 {
+		t.Errorf("pool has %d workers, want it to stop at 2", len(p.workers))
+	}
+}
+
+func TestPoolRestartsCrashedWorker(t *testing.T) {
+	p := newTestPool(t, "ok", 1, 1)
+	before := p.workers[0].pid()
+
+	_, err := p.call(context.Background(), pluginRequest{Text: "crash", Output: "/dev/null"})
+	if err == nil || !strings.Contains(err.Error(), "plugin exited") {
+		t.Fatalf("err = %v, want the plugin to have exited", err)
+	}
+
+	// Only the chunk in flight is lost
+	res, err := p.call(context.Background(), pluginRequest{Text: "Hello.", Output: t.TempDir() + "/chunk.wav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != pluginStatusOk {
+		t.Errorf("status = %q, want ok", res.Status)
+	}
+	if after := p.workers[0].pid(); after == before {
+		t.Error("the crashed process wasn't replaced")
+	}
+}
+
+func TestPoolReplacesHungWorker(t *testing.T) {
+	p := newTestPool(t, "ok", 1, 1)
+	before := p.workers[0].pid()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.call(ctx, pluginRequest{Text: "hang", Output: "/dev/null"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the deadline", err)
+	}
+
+	// The next request goes to a fresh process rather than queueing behind the stuck one
+	if _, err := p.call(context.Background(), pluginRequest{Text: "Hello.", Output: t.TempDir() + "/chunk.wav"}); err != nil {
+		t.Fatal(err)
+	}
+	if after := p.workers[0].pid(); after == before {
+		t.Error("the hung process wasn't replaced")
+	}
+}
+
+func TestPoolCancelKeepsWorker(t *testing.T) {
+	p := newTestPool(t, "ok", 1, 1)
+	before := p.workers[0].pid()
+
+	// Cancelling isn't the plugin's fault, so it keeps running
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := p.call(ctx, pluginRequest{Text: "hang", Output: "/dev/null"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want it cancelled", err)
+	}
+
+	if _, err := p.call(context.Background(), pluginRequest{Text: "Hello.", Output: t.TempDir() + "/chunk.wav"}); err != nil {
+		t.Fatal(err)
+	}
+	if after := p.workers[0].pid(); after != before {
+		t.Error("cancelling replaced the process")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p := newTestPool(t, "ok", 2, 2)
+	processes := []*pluginProcess{p.workers[0].process, p.workers[1].process}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, process := range processes {
+		select {
+		case <-process.done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("worker %d is still running", i)
+		}
+	}
+
+	if _, err := p.call(context.Background(), pluginRequest{Text: "Hello."}); !errors.Is(err, errPoolClosed) {
+		t.Errorf("err = %v, want the pool to be closed", err)
+	}
+}
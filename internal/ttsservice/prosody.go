@@ -0,0 +1,80 @@
+package ttsservice
+
+import (
+	"context"
+	"slices"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+// Prosody controls a plugin can advertise in its capabilities.
+const (
+	ControlRate  = "rate"
+	ControlPitch = "pitch"
+	ControlGain  = "gain"
+)
+
+// OpenAI's speech API accepts speeds in this range.
+const (
+	openAIMinSpeed = 0.25
+	openAIMaxSpeed = 4.0
+)
+
+type prosodyKey struct{}
+
+// WithProsody asks the engine to apply p while synthesizing.
+// p should only contain what NativeProsody reported the engine can do.
+func WithProsody(ctx context.Context, p config.Prosody) context.Context {
+	return context.WithValue(ctx, prosodyKey{}, p)
+}
+
+// ProsodyFromContext returns the prosody the engine was asked to apply.
+func ProsodyFromContext(ctx context.Context) config.Prosody {
+	p, _ := ctx.Value(prosodyKey{}).(config.Prosody)
+	return p
+}
+
+// prosodic is implemented by services whose engine can apply some prosody itself.
+type prosodic interface {
+	nativeProsody(p config.Prosody) config.Prosody
+}
+
+// NativeProsody returns the part of p that the service's engine applies itself.
+// The rest has to be applied to the audio afterwards.
+func NativeProsody(service TTSservice, p config.Prosody) config.Prosody {
+	if n, ok := service.(prosodic); ok {
+		return n.nativeProsody(p)
+	}
+	return config.Prosody{}
+}
+
+func (d *deadlineService) nativeProsody(p config.Prosody) config.Prosody {
+	return NativeProsody(d.TTSservice, p)
+}
+
+func (h *HTTPTTSService) nativeProsody(p config.Prosody) config.Prosody {
+	if h.config.Schema == SchemaCoqui {
+		return config.Prosody{}
+	}
+
+	if rate := p.Speed(); rate != 1 && rate >= openAIMinSpeed && rate <= openAIMaxSpeed {
+		return config.Prosody{Rate: rate}
+	}
+	return config.Prosody{}
+}
+
+func (p *PluginTTSService) nativeProsody(want config.Prosody) config.Prosody {
+	controls := p.pool.hello.Capabilities.Controls
+
+	var native config.Prosody
+	if slices.Contains(controls, ControlRate) {
+		native.Rate = want.Rate
+	}
+	if slices.Contains(controls, ControlPitch) {
+		native.Pitch = want.Pitch
+	}
+	if slices.Contains(controls, ControlGain) {
+		native.Gain = want.Gain
+	}
+	return native
+}
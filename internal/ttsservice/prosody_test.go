@@ -0,0 +1,78 @@
+package ttsservice
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+)
+
+func TestNativeProsodyHTTP(t *testing.T) {
+	h := newTestService(t, nil, nil)
+
+	tests := []struct {
+		want config.Prosody
+		got  config.Prosody
+	}{
+		{config.Prosody{Rate: 1.5, Pitch: 2, Gain: 3}, config.Prosody{Rate: 1.5}},
+		// Outside the range the API accepts, so it's all done afterwards
+		{config.Prosody{Rate: 5}, config.Prosody{}},
+		{config.Prosody{Gain: 3}, config.Prosody{}},
+	}
+	for _, tt := range tests {
+		if got := NativeProsody(h, tt.want); got != tt.got {
+			t.Errorf("NativeProsody(%+v) = %+v, want %+v", tt.want, got, tt.got)
+		}
+	}
+
+	coqui := newTestService(t, nil, func(c *config.Config) { c.HTTP.Schema = SchemaCoqui })
+	if got := NativeProsody(coqui, config.Prosody{Rate: 1.5}); got != (config.Prosody{}) {
+		t.Errorf("coqui schema applies %+v, want nothing", got)
+	}
+}
+
+func TestNativeProsodyDeadline(t *testing.T) {
+	h := newTestService(t, nil, nil)
+	d := withDeadline(h, config.Model{Timeout: 1})
+
+	if got := NativeProsody(d, config.Prosody{Rate: 1.5}); got != (config.Prosody{Rate: 1.5}) {
+		t.Errorf("NativeProsody through the deadline = %+v, want the backend's", got)
+	}
+	if got := NativeProsody(&stubTTS{}, config.Prosody{Rate: 1.5}); got != (config.Prosody{}) {
+		t.Errorf("NativeProsody without support = %+v, want nothing", got)
+	}
+}
+
+func TestPluginProsody(t *testing.T) {
+	p, err := newTestPlugin(t, "prosody", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	native := NativeProsody(p, config.Prosody{Rate: 1.2, Pitch: 2, Gain: -1})
+	if native != (config.Prosody{Rate: 1.2, Gain: -1}) {
+		t.Fatalf("native = %+v, want only the advertised controls", native)
+	}
+
+	data, err := p.SynthesizeContext(WithProsody(context.Background(), native), "Hello.", "chunk.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req pluginRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Rate != 1.2 || req.Pitch != 0 || req.Gain != -1 {
+		t.Errorf("request = %+v, want the native prosody", req)
+	}
+
+	// Plugins without controls don't get any
+	plain, err := newTestPlugin(t, "ok", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := NativeProsody(plain, config.Prosody{Rate: 1.2}); got != (config.Prosody{}) {
+		t.Errorf("native = %+v, want nothing", got)
+	}
+}
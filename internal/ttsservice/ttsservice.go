@@ -1,14 +1,20 @@
 package ttsservice
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
-	"log"
 	"os"
-	"sync"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/pixellini/go-audiobook/internal/config"
-	"github.com/pixellini/go-coqui"
+	"github.com/pixellini/go-coqui/model"
 	"github.com/pixellini/go-coqui/models/tts"
 	"github.com/pixellini/go-coqui/models/vocoder"
 )
@@ -16,116 +22,272 @@ import (
 type TTSservice interface {
 	Synthesize(text, output string) ([]byte, error)
 	SynthesizeContext(ctx context.Context, text, output string) ([]byte, error)
+	// Capabilities describes the voices and limits of the engine. Lists are empty when the engine can't tell.
+	Capabilities(ctx context.Context) (Capabilities, error)
+	Close() error
 }
 
-type CoquiTTSService struct {
-	tts          *coqui.TTS
-	suppressLogs bool
+type seedKey struct{}
+
+// WithSeed asks the engine to use seed for its random sampling.
+// Backends that can't be seeded produce a new random take on every call anyway.
+func WithSeed(ctx context.Context, seed int64) context.Context {
+	return context.WithValue(ctx, seedKey{}, seed)
 }
 
-func NewCoquiService(config *config.Config, outputDir string) (*CoquiTTSService, error) {
-	tts, err := coqui.New(
-		// coqui.WithModelPath(config.Model.Name),
-		// coqui.WithSpeaker(config.Model.SpeakerWav),
-		coqui.WithModelId(tts.PresetVITSVCTK),
-		coqui.WithSpeakerIndex(config.Model.SpeakerIdx),
-		coqui.WithDevice(config.Model.Device),
-		coqui.WithMaxRetries(int(config.Model.MaxRetries)),
-		coqui.WithOutputDir(outputDir),
-	)
+func seedFromContext(ctx context.Context) (int64, bool) {
+	seed, ok := ctx.Value(seedKey{}).(int64)
+	return seed, ok
+}
 
+// Supported values for model.backend.
+const (
+	BackendCoqui  = "coqui"
+	BackendHTTP   = "http"
+	BackendPlugin = "plugin"
+)
+
+// New creates the TTS service selected by the config's model backend.
+// In test mode the fake service is always used.
+// Every attempt at a chunk gets a deadline from model.timeout and model.timeout_per_char.
+func New(config *config.Config, outputDir string) (TTSservice, error) {
+	service, err := newBackend(config, outputDir)
 	if err != nil {
 		return nil, err
 	}
 
-	if config.Model.SpeakerWav != "" {
-		tts.Configure(
-			coqui.WithSpeakerSample(config.Model.SpeakerWav),
-		)
-	} else {
-		tts.Configure(
-			coqui.WithSpeakerIndex(config.Model.SpeakerIdx),
-		)
+	return withDeadline(service, config.Model), nil
+}
+
+func newBackend(config *config.Config, outputDir string) (TTSservice, error) {
+	if config.TestMode {
+		return NewFakeService(config, outputDir)
 	}
 
-	if config.Vocoder.Name != "" {
-		tts.Configure(
-			coqui.WithVocoder(vocoder.PresetHifiganV2Blizzard2013),
-			coqui.WithVocoderLanguage(config.Vocoder.Language),
-		)
+	switch config.Model.Backend {
+	case BackendCoqui, "":
+		if config.Model.Persistent {
+			return NewCoquiWorkerService(config, outputDir)
+		}
+		return NewCoquiService(config, outputDir)
+	case BackendHTTP:
+		return NewHTTPService(config, outputDir)
+	case BackendPlugin:
+		return NewPluginService(config, outputDir)
+	default:
+		return nil, fmt.Errorf("unknown TTS backend %q", config.Model.Backend)
+	}
+}
+
+// coquiExecutable is the Coqui TTS command line tool.
+const coquiExecutable = "tts"
+
+// Fingerprint describes everything in the config that changes how the configured backend sounds.
+// Synthesized audio can be reused whenever the text and the fingerprint are the same.
+func Fingerprint(config *config.Config) string {
+	var parts []string
+
+	switch {
+	case config.TestMode:
+		parts = []string{
+			"fake",
+			config.Test.Signal,
+			fmt.Sprint(config.Test.CharsPerSecond),
+			fmt.Sprint(config.Test.SampleRate),
+		}
+
+	case config.Model.Backend == BackendHTTP:
+		voice := config.HTTP.Voice
+		if voice == "" {
+			voice = config.Model.SpeakerIdx
+		}
+		parts = []string{
+			BackendHTTP,
+			config.HTTP.Schema,
+			config.HTTP.URL,
+			config.HTTP.Model,
+			voice,
+			string(config.Model.Language),
+		}
+
+	case config.Model.Backend == BackendPlugin:
+		parts = append([]string{BackendPlugin, config.Plugin.Command}, config.Plugin.Args...)
+		parts = append(parts, config.Model.SpeakerIdx, string(config.Model.Language))
+
+	default:
+		parts = []string{
+			BackendCoqui,
+			coquiModel(config),
+			speakerIdentity(config.Model),
+		}
+		// Persistent workers use the model's default vocoder.
+		// A language without a vocoder fails when the engine is created.
+		if config.Vocoder.Name != "" && !config.Model.Persistent {
+			name, _ := vocoderName(config.Vocoder)
+			parts = append(parts, name)
+		}
 	}
 
-	return &CoquiTTSService{
-		tts:          tts,
-		suppressLogs: !config.VerboseLogs,
-	}, nil
+	return strings.Join(parts, "|")
 }
 
-var (
-	devNullOnce sync.Once
-	devNullFile *os.File
-	suppressMu  sync.Mutex
-)
+// coquiModel is the Coqui model the engine speaks with.
+func coquiModel(c *config.Config) string {
+	return cmp.Or(c.Model.Name, tts.PresetVITSVCTK.Name())
+}
+
+// coquiVocoders are the vocoders Coqui has for each language. Coqui names models
+// "vocoder_models/<language>/<dataset>/<model>", and every language has its own datasets.
+var coquiVocoders = map[model.Language]string{
+	model.English: vocoder.PresetHifiganV2Blizzard2013.Name(),
+	"be":          "vocoder_models/be/common-voice/hifigan",
+	"de":          "vocoder_models/de/thorsten/hifigan_v1",
+	"ja":          "vocoder_models/ja/kokoro/hifigan_v1",
+	"nl":          "vocoder_models/nl/mai/parallel-wavegan",
+	"tr":          "vocoder_models/tr/common-voice/hifigan",
+	"uk":          "vocoder_models/uk/mai/multiband-melgan",
+}
 
-// suppressOutput redirects Stdout, Stderr and the default logger to
-// /dev/null (or io.Discard for the logger).
-// We can call the returned restore function to put everything back.
-// TODO: It might be better to move this to go-coqui as an option instead of doing it here.
-func (c *CoquiTTSService) suppressOutput() (restore func(), err error) {
-	if !c.suppressLogs {
-		return func() {}, nil
+// vocoderName is the Coqui model name of the vocoder for the configured language.
+func vocoderName(v config.Vocoder) (string, error) {
+	if v.Language == "" {
+		return vocoder.PresetHifiganV2Blizzard2013.Name(), nil
 	}
 
-	var openErr error
-	// only open /dev/null once
-	devNullOnce.Do(func() {
-		devNullFile, openErr = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
-	})
-	if openErr != nil {
-		return nil, openErr
+	name, ok := coquiVocoders[v.Language]
+	if !ok {
+		languages := make([]string, 0, len(coquiVocoders))
+		for l := range coquiVocoders {
+			languages = append(languages, string(l))
+		}
+		slices.Sort(languages)
+		return "", fmt.Errorf("coqui has no vocoder for language %q, it has vocoders for %s", v.Language, strings.Join(languages, ", "))
 	}
+	return name, nil
+}
 
-	// allow only one active suppression at a time
-	suppressMu.Lock()
+// speakerIdentity identifies the voice. Speaker samples are identified by their content, so replacing the file is noticed.
+func speakerIdentity(m config.Model) string {
+	clips := m.SpeakerClips()
+	if len(clips) == 0 {
+		return "idx:" + m.SpeakerIdx
+	}
 
-	origStdout := os.Stdout
-	origStderr := os.Stderr
-	origLogOut := log.Writer()
+	ids := make([]string, len(clips))
+	for i, clip := range clips {
+		data, err := os.ReadFile(clip)
+		if err != nil {
+			ids[i] = clip
+			continue
+		}
+		sum := sha256.Sum256(data)
+		ids[i] = hex.EncodeToString(sum[:])
+	}
 
-	os.Stdout = devNullFile
-	os.Stderr = devNullFile
-	log.SetOutput(io.Discard)
+	return "wav:" + strings.Join(ids, ",")
+}
 
-	return func() {
-		os.Stdout = origStdout
-		os.Stderr = origStderr
-		log.SetOutput(origLogOut)
-		suppressMu.Unlock()
-	}, nil
+// CoquiTTSService runs the Coqui TTS command line tool once per chunk.
+// Each invocation gets its own output writers, so any number of chunks can be synthesized in parallel.
+type CoquiTTSService struct {
+	model      string
+	args       []string
+	outputDir  string
+	maxRetries int
+	// logs receives engine output in verbose mode. It is nil otherwise.
+	logs io.Writer
 }
 
-func (c *CoquiTTSService) Synthesize(text, output string) ([]byte, error) {
-	restore, err := c.suppressOutput()
-	if err != nil {
-		return nil, err
+func NewCoquiService(config *config.Config, outputDir string) (*CoquiTTSService, error) {
+	if _, err := exec.LookPath(coquiExecutable); err != nil {
+		return nil, fmt.Errorf("coqui %q command not found: %w", coquiExecutable, err)
+	}
+
+	args := []string{
+		"--model_name", coquiModel(config),
+	}
+
+	if clips := config.Model.SpeakerClips(); len(clips) > 0 {
+		args = append(args, "--speaker_wav")
+		args = append(args, clips...)
+	} else {
+		args = append(args, "--speaker_idx", config.Model.SpeakerIdx)
+	}
+
+	if config.Model.Device != "" {
+		args = append(args, "--device", string(config.Model.Device))
 	}
-	defer restore()
 
-	return c.tts.SynthesizeContext(context.Background(), text, output)
+	if config.Vocoder.Name != "" {
+		name, err := vocoderName(config.Vocoder)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--vocoder_name", name)
+	}
+
+	c := &CoquiTTSService{
+		model:      coquiModel(config),
+		args:       args,
+		outputDir:  outputDir,
+		maxRetries: int(config.Model.MaxRetries),
+	}
+	if config.VerboseLogs {
+		c.logs = os.Stderr
+	}
+
+	return c, nil
+}
+
+func (c *CoquiTTSService) Synthesize(text, output string) ([]byte, error) {
+	return c.SynthesizeContext(context.Background(), text, output)
 }
 
 func (c *CoquiTTSService) SynthesizeContext(ctx context.Context, text, output string) ([]byte, error) {
-	restore, err := c.suppressOutput()
-	if err != nil {
-		return nil, err
+	path := filepath.Join(c.outputDir, output)
+	chunkId := strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+
+	var err error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, backoff(attempt-1)); err != nil {
+				return nil, err
+			}
+		}
+
+		err = c.run(ctx, chunkId, text, path)
+		if err == nil {
+			return os.ReadFile(path)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 	}
-	defer restore()
 
-	bytes, err := c.tts.SynthesizeContext(ctx, text, output)
+	return nil, err
+}
+
+func (c *CoquiTTSService) Close() error {
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+// run performs a single engine invocation.
+// The engine output goes to the logs with the chunk ID as a prefix, and the tail is kept for error messages.
+func (c *CoquiTTSService) run(ctx context.Context, chunkId, text, path string) error {
+	args := append(slices.Clone(c.args), "--text", text, "--out_path", path)
+	cmd := exec.CommandContext(ctx, coquiExecutable, args...)
+
+	tail := &tailBuffer{limit: 2048}
+	var out io.Writer = tail
+	if c.logs != nil {
+		out = io.MultiWriter(tail, newPrefixWriter(c.logs, "["+chunkId+"] "))
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("coqui failed: %w\n%s", err, tail.String())
 	}
 
-	return bytes, nil
+	return nil
 }
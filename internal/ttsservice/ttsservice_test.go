@@ -0,0 +1,141 @@
+package ttsservice
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pixellini/go-audiobook/internal/config"
+	"github.com/pixellini/go-coqui/model"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := func() *config.Config {
+		c := &config.Config{}
+		c.Model.SpeakerIdx = "p225"
+		c.Model.Language = "en"
+		c.Vocoder.Name = "vocoder"
+		return c
+	}
+	want := Fingerprint(base())
+	if want != "coqui|tts_models/en/vctk/vits|idx:p225|vocoder_models/en/blizzard2013/hifigan_v2" {
+		t.Errorf("fingerprint = %q", want)
+	}
+
+	// Settings that don't change the sound don't change the fingerprint
+	same := base()
+	same.Model.Concurrency = 8
+	same.Model.MaxRetries = 1
+	same.Model.Device = "cuda"
+	same.VerboseLogs = true
+	if got := Fingerprint(same); got != want {
+		t.Errorf("fingerprint = %q, want %q", got, want)
+	}
+
+	for name, change := range map[string]func(c *config.Config){
+		"speaker":    func(c *config.Config) { c.Model.SpeakerIdx = "p226" },
+		"model":      func(c *config.Config) { c.Model.Name = "tts_models/en/ljspeech/vits" },
+		"vocoder":    func(c *config.Config) { c.Vocoder.Name = "" },
+		"persistent": func(c *config.Config) { c.Model.Persistent = true },
+		"backend":    func(c *config.Config) { c.Model.Backend = BackendHTTP },
+		"test mode":  func(c *config.Config) { c.TestMode = true },
+	} {
+		c := base()
+		change(c)
+		if got := Fingerprint(c); got == want {
+			t.Errorf("changing the %s kept the fingerprint %q", name, got)
+		}
+	}
+}
+
+func TestFingerprintSpeakerWav(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speaker.wav")
+	c := &config.Config{}
+	c.Model.SpeakerWav = path
+
+	write := func(data string) string {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return Fingerprint(c)
+	}
+
+	first := write("one voice")
+	if again := Fingerprint(c); again != first {
+		t.Errorf("fingerprint changed without the sample changing: %q, %q", first, again)
+	}
+	// Replacing the sample at the same path is a different voice
+	if second := write("another voice"); second == first {
+		t.Error("replacing the speaker sample kept the fingerprint")
+	}
+}
+
+func TestFingerprintSpeakerWavs(t *testing.T) {
+	dir := t.TempDir()
+	c := &config.Config{}
+	for _, name := range []string{"one.wav", "two.wav"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Model.SpeakerWav = filepath.Join(dir, "one.wav")
+	one := Fingerprint(c)
+
+	// Another clip of the voice changes how it's cloned
+	c.Model.SpeakerWavs = []string{filepath.Join(dir, "two.wav")}
+	if both := Fingerprint(c); both == one {
+		t.Error("adding a speaker clip kept the fingerprint")
+	}
+}
+
+func TestFingerprintBackends(t *testing.T) {
+	http := &config.Config{}
+	http.Model.Backend = BackendHTTP
+	http.Model.SpeakerIdx = "alloy"
+	http.HTTP.Model = "tts-1"
+	withVoice := *http
+	withVoice.HTTP.Voice = "nova"
+	if Fingerprint(http) == Fingerprint(&withVoice) {
+		t.Error("http.voice didn't change the fingerprint")
+	}
+
+	plugin := &config.Config{}
+	plugin.Model.Backend = BackendPlugin
+	plugin.Plugin.Command = "engine"
+	withArgs := *plugin
+	withArgs.Plugin.Args = []string{"--fast"}
+	if Fingerprint(plugin) == Fingerprint(&withArgs) {
+		t.Error("plugin.args didn't change the fingerprint")
+	}
+
+	fake := &config.Config{TestMode: true}
+	fake.Test.Signal = SignalTone
+	silent := *fake
+	silent.Test.Signal = SignalSilence
+	if Fingerprint(fake) == Fingerprint(&silent) {
+		t.Error("test.signal didn't change the fingerprint")
+	}
+}
+
+func TestVocoderName(t *testing.T) {
+	tests := []struct {
+		language model.Language
+		want     string
+		wantErr  bool
+	}{
+		{"", "vocoder_models/en/blizzard2013/hifigan_v2", false},
+		{"en", "vocoder_models/en/blizzard2013/hifigan_v2", false},
+		{"de", "vocoder_models/de/thorsten/hifigan_v1", false},
+		{"fr", "", true},
+	}
+	for _, tt := range tests {
+		got, err := vocoderName(config.Vocoder{Name: "vocoder", Language: tt.language})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("vocoderName(%q) error = %v, wantErr %v", tt.language, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("vocoderName(%q) = %q, want %q", tt.language, got, tt.want)
+		}
+	}
+}
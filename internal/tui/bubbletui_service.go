@@ -32,6 +32,7 @@ type bubbleTeaModel struct {
 	completed    []string
 	finished     bool
 	stopped      bool
+	quit         bool
 	showProgress bool
 	current      int
 	total        int
@@ -40,8 +41,9 @@ type bubbleTeaModel struct {
 }
 
 type BubbleTerminalUI struct {
-	p  *tea.Program
-	bm *bubbleTeaModel
+	p      *tea.Program
+	bm     *bubbleTeaModel
+	onQuit func()
 }
 
 const (
@@ -83,11 +85,21 @@ func NewBubbleTeaUI() TUIService {
 
 func (b *BubbleTerminalUI) Start() error {
 	go func() {
-		_, _ = b.p.Run()
+		m, _ := b.p.Run()
+
+		// Only a quit from the keyboard should stop the run
+		if fm, ok := m.(bubbleTeaModel); ok && fm.quit && b.onQuit != nil {
+			b.onQuit()
+		}
 	}()
 	return nil
 }
 
+// OnQuit must be called before Start.
+func (b *BubbleTerminalUI) OnQuit(fn func()) {
+	b.onQuit = fn
+}
+
 func (b *BubbleTerminalUI) UpdateProgress(message string) {
 	if b.p != nil {
 		b.p.Send(progressMsg{message: message})
@@ -138,6 +150,7 @@ func (bm bubbleTeaModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.String() {
 		case "q", "ctrl+c":
 			bm.stopped = true
+			bm.quit = true
 			return bm, tea.Quit
 		}
 
@@ -0,0 +1,32 @@
+package tui
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestQuitKeys(t *testing.T) {
+	for _, key := range []tea.KeyMsg{
+		{Type: tea.KeyRunes, Runes: []rune("q")},
+		{Type: tea.KeyCtrlC},
+	} {
+		m, cmd := bubbleTeaModel{}.Update(key)
+		if bm := m.(bubbleTeaModel); !bm.quit || !bm.stopped {
+			t.Errorf("%s didn't quit", key)
+		}
+		if cmd == nil {
+			t.Errorf("%s didn't stop the program", key)
+		}
+	}
+}
+
+func TestStopIsNotQuit(t *testing.T) {
+	// Stopping the UI from the program mustn't look like the user quitting
+	for _, msg := range []tea.Msg{stopMsg{}, finishMsg{message: "done"}} {
+		m, _ := bubbleTeaModel{}.Update(msg)
+		if m.(bubbleTeaModel).quit {
+			t.Errorf("%T was treated as the user quitting", msg)
+		}
+	}
+}
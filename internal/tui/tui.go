@@ -10,6 +10,8 @@ type TUIService interface {
 	CompleteCurrentTask(message string)
 	Finish(message string)
 	Stop()
+	// OnQuit registers fn to be called when the user quits the UI.
+	OnQuit(fn func())
 }
 
 const (
@@ -46,3 +48,5 @@ func (n *EmptyTUI) CompleteCurrentTask(message string) {}
 func (n *EmptyTUI) Finish(message string) {}
 
 func (n *EmptyTUI) Stop() {}
+
+func (n *EmptyTUI) OnQuit(fn func()) {}
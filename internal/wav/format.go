@@ -0,0 +1,73 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Format describes how the audio in a WAV file is stored.
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	// Float is set for floating point samples, rather than integer PCM.
+	Float bool
+}
+
+// IsPCM16 reports whether the samples are 16-bit integer PCM, which is what this package writes.
+func (f Format) IsPCM16() bool {
+	return !f.Float && f.BitsPerSample == bitsPerSample
+}
+
+// ReadFormat reads the format of the WAV file at path, without reading the audio.
+func ReadFormat(path string) (Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Format{}, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return Format{}, fmt.Errorf("not a WAV file")
+	}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return Format{}, fmt.Errorf("WAV file is missing its fmt chunk")
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		if string(chunk[0:4]) != "fmt " {
+			// Chunks are padded to an even size
+			if _, err := r.Discard(int(size + size%2)); err != nil {
+				return Format{}, fmt.Errorf("WAV file is missing its fmt chunk")
+			}
+			continue
+		}
+
+		if size < 16 {
+			return Format{}, fmt.Errorf("WAV fmt chunk is too short")
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return Format{}, err
+		}
+
+		format := binary.LittleEndian.Uint16(body[0:2])
+		if format == formatExtensible && len(body) >= 26 {
+			format = binary.LittleEndian.Uint16(body[24:26])
+		}
+		return Format{
+			SampleRate:    int(binary.LittleEndian.Uint32(body[4:8])),
+			Channels:      int(binary.LittleEndian.Uint16(body[2:4])),
+			BitsPerSample: int(binary.LittleEndian.Uint16(body[14:16])),
+			Float:         format == formatFloat,
+		}, nil
+	}
+}
@@ -0,0 +1,210 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	bitsPerSample = 16
+	channels      = 1
+	headerSize    = 44
+)
+
+// Encode writes mono 16-bit PCM samples as a WAV file.
+func Encode(w io.Writer, sampleRate int, samples []int16) error {
+	if err := writeHeader(w, sampleRate, channels, uint32(len(samples)*bitsPerSample/8)); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+func writeHeader(w io.Writer, sampleRate, channels int, dataSize uint32) error {
+	blockAlign := uint16(channels * bitsPerSample / 8)
+
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(headerSize - 8 + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16), // PCM fmt chunk size
+		uint16(1),  // PCM
+		uint16(channels),
+		uint32(sampleRate),
+		uint32(sampleRate) * uint32(blockAlign),
+		blockAlign,
+		uint16(bitsPerSample),
+
+		[4]byte{'d', 'a', 't', 'a'},
+		dataSize,
+	}
+
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeBytes is like Encode but returns the WAV file contents.
+func EncodeBytes(sampleRate int, samples []int16) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(headerSize + len(samples)*2)
+
+	if err := Encode(&buf, sampleRate, samples); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile writes the samples to a WAV file at path.
+func WriteFile(path string, sampleRate int, samples []int16) error {
+	data, err := EncodeBytes(sampleRate, samples)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Audio is decoded PCM audio. Samples are interleaved and scaled to [-1, 1].
+type Audio struct {
+	SampleRate int
+	Channels   int
+	Samples    []float64
+}
+
+const (
+	formatPCM        = 1
+	formatFloat      = 3
+	formatExtensible = 0xFFFE
+)
+
+// Duration returns the length of the audio in seconds.
+func (a *Audio) Duration() float64 {
+	if a.SampleRate == 0 || a.Channels == 0 {
+		return 0
+	}
+	return float64(len(a.Samples)/a.Channels) / float64(a.SampleRate)
+}
+
+// ReadFile decodes a PCM or floating point WAV file.
+func ReadFile(path string) (*Audio, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Decode decodes the contents of a PCM or floating point WAV file.
+func Decode(data []byte) (*Audio, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	var (
+		format, channels, bits uint16
+		sampleRate             uint32
+		pcm                    []byte
+		haveFmt                bool
+	)
+
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		// Streamed files may not know their data size, so take what's there
+		if size > len(body) || size < 0 {
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("WAV fmt chunk is too short")
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			sampleRate = binary.LittleEndian.Uint32(body[4:8])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format == formatExtensible && len(body) >= 26 {
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+			haveFmt = true
+		case "data":
+			pcm = body
+		}
+
+		// Chunks are padded to an even size
+		pos += 8 + size + size%2
+	}
+
+	if !haveFmt || pcm == nil {
+		return nil, fmt.Errorf("WAV file is missing its fmt or data chunk")
+	}
+	if channels == 0 || sampleRate == 0 {
+		return nil, fmt.Errorf("WAV file has an invalid format")
+	}
+
+	samples, err := decodeSamples(pcm, format, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Audio{
+		SampleRate: int(sampleRate),
+		Channels:   int(channels),
+		Samples:    samples,
+	}, nil
+}
+
+func decodeSamples(pcm []byte, format, bits uint16) ([]float64, error) {
+	width := int(bits) / 8
+	if width == 0 {
+		return nil, fmt.Errorf("unsupported WAV sample size %d", bits)
+	}
+
+	n := len(pcm) / width
+	samples := make([]float64, n)
+
+	switch {
+	case format == formatPCM && bits == 8:
+		for i := range samples {
+			samples[i] = (float64(pcm[i]) - 128) / 128
+		}
+	case format == formatPCM && bits == 16:
+		for i := range samples {
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / 32768
+		}
+	case format == formatPCM && bits == 24:
+		for i := range samples {
+			b := pcm[i*3:]
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			samples[i] = float64(v) / (1 << 23)
+		}
+	case format == formatPCM && bits == 32:
+		for i := range samples {
+			samples[i] = float64(int32(binary.LittleEndian.Uint32(pcm[i*4:]))) / (1 << 31)
+		}
+	case format == formatFloat && bits == 32:
+		for i := range samples {
+			samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(pcm[i*4:])))
+		}
+	case format == formatFloat && bits == 64:
+		for i := range samples {
+			samples[i] = math.Float64frombits(binary.LittleEndian.Uint64(pcm[i*8:]))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported WAV format %d with %d bit samples", format, bits)
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,281 @@
+package wav
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	samples := []int16{0, 1, -1, 16384, -16384, math.MaxInt16, math.MinInt16}
+
+	data, err := EncodeBytes(22050, samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != headerSize+len(samples)*2 {
+		t.Fatalf("encoded %d bytes, want %d", len(data), headerSize+len(samples)*2)
+	}
+
+	le := binary.LittleEndian
+	for _, field := range []struct {
+		name      string
+		got, want any
+	}{
+		{"RIFF", string(data[0:4]), "RIFF"},
+		{"RIFF size", le.Uint32(data[4:8]), uint32(len(data) - 8)},
+		{"WAVE", string(data[8:12]), "WAVE"},
+		{"fmt", string(data[12:16]), "fmt "},
+		{"format", le.Uint16(data[20:22]), uint16(1)},
+		{"channels", le.Uint16(data[22:24]), uint16(1)},
+		{"sample rate", le.Uint32(data[24:28]), uint32(22050)},
+		{"byte rate", le.Uint32(data[28:32]), uint32(44100)},
+		{"block align", le.Uint16(data[32:34]), uint16(2)},
+		{"bits per sample", le.Uint16(data[34:36]), uint16(16)},
+		{"data", string(data[36:40]), "data"},
+		{"data size", le.Uint32(data[40:44]), uint32(len(samples) * 2)},
+	} {
+		if field.got != field.want {
+			t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+		}
+	}
+
+	for i, s := range samples {
+		if got := int16(le.Uint16(data[headerSize+i*2:])); got != s {
+			t.Errorf("sample %d = %d, want %d", i, got, s)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tone.wav")
+	if err := WriteFile(path, 8000, make([]int16, 12000)); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != headerSize+24000 {
+		t.Errorf("file is %d bytes, want %d", info.Size(), headerSize+24000)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	samples := []int16{0, 1, -1, 16384, -16384, math.MaxInt16, math.MinInt16}
+
+	data, err := EncodeBytes(22050, samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audio.SampleRate != 22050 || audio.Channels != 1 {
+		t.Errorf("decoded %dHz with %d channels, want 22050Hz mono", audio.SampleRate, audio.Channels)
+	}
+	if len(audio.Samples) != len(samples) {
+		t.Fatalf("decoded %d samples, want %d", len(audio.Samples), len(samples))
+	}
+	for i, s := range samples {
+		if got := int16(audio.Samples[i] * 32768); got != s {
+			t.Errorf("sample %d = %d, want %d", i, got, s)
+		}
+	}
+}
+
+// rawWAV builds a WAV file with a fmt chunk of the given format, an unrelated chunk, and the data.
+func rawWAV(format, channels uint16, sampleRate uint32, bits uint16, pcm []byte) []byte {
+	le := binary.LittleEndian
+	blockAlign := channels * bits / 8
+
+	fmtChunk := le.AppendUint16(nil, format)
+	fmtChunk = le.AppendUint16(fmtChunk, channels)
+	fmtChunk = le.AppendUint32(fmtChunk, sampleRate)
+	fmtChunk = le.AppendUint32(fmtChunk, sampleRate*uint32(blockAlign))
+	fmtChunk = le.AppendUint16(fmtChunk, blockAlign)
+	fmtChunk = le.AppendUint16(fmtChunk, bits)
+
+	chunk := func(id string, body []byte) []byte {
+		out := append([]byte(id), le.AppendUint32(nil, uint32(len(body)))...)
+		out = append(out, body...)
+		if len(body)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+
+	body := []byte("WAVE")
+	body = append(body, chunk("fmt ", fmtChunk)...)
+	// Odd sized chunks are padded, which must be skipped
+	body = append(body, chunk("LIST", []byte("odd"))...)
+	body = append(body, chunk("data", pcm)...)
+
+	return append(append([]byte("RIFF"), le.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestDecodeFormats(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name     string
+		format   uint16
+		bits     uint16
+		pcm      []byte
+		channels uint16
+		want     []float64
+	}{
+		{"8 bit", formatPCM, 8, []byte{128, 192, 64}, 1, []float64{0, 0.5, -0.5}},
+		{"24 bit", formatPCM, 24, []byte{0, 0, 0x40, 0, 0, 0xc0}, 1, []float64{0.5, -0.5}},
+		{"32 bit", formatPCM, 32, le.AppendUint32(nil, 1<<30), 1, []float64{0.5}},
+		{"float", formatFloat, 32, le.AppendUint32(le.AppendUint32(nil, math.Float32bits(0.25)), math.Float32bits(-1)), 2, []float64{0.25, -1}},
+		{"double", formatFloat, 64, le.AppendUint64(nil, math.Float64bits(-0.75)), 1, []float64{-0.75}},
+	}
+
+	for _, tt := range tests {
+		audio, err := Decode(rawWAV(tt.format, tt.channels, 48000, tt.bits, tt.pcm))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if audio.SampleRate != 48000 || audio.Channels != int(tt.channels) {
+			t.Errorf("%s: decoded %dHz with %d channels", tt.name, audio.SampleRate, audio.Channels)
+		}
+		if len(audio.Samples) != len(tt.want) {
+			t.Errorf("%s: decoded %d samples, want %d", tt.name, len(audio.Samples), len(tt.want))
+			continue
+		}
+		for i, s := range tt.want {
+			if math.Abs(audio.Samples[i]-s) > 1e-9 {
+				t.Errorf("%s: sample %d = %g, want %g", tt.name, i, audio.Samples[i], s)
+			}
+		}
+	}
+
+	if _, err := Decode(rawWAV(2, 1, 8000, 4, []byte{0})); err == nil {
+		t.Error("expected an error for ADPCM")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tone.wav")
+	if err := WriteFile(path, 8000, make([]int16, 12000)); err != nil {
+		t.Fatal(err)
+	}
+
+	audio, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := audio.Duration(); d != 1.5 {
+		t.Errorf("duration = %g, want 1.5", d)
+	}
+
+	stereo := &Audio{SampleRate: 8000, Channels: 2, Samples: make([]float64, 16000)}
+	if d := stereo.Duration(); d != 1 {
+		t.Errorf("stereo duration = %g, want 1", d)
+	}
+	if d := (&Audio{}).Duration(); d != 0 {
+		t.Errorf("empty duration = %g, want 0", d)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":   nil,
+		"not wav": []byte("RIFF\x00\x00\x00\x00AVI "),
+		"no data": []byte("RIFF\x04\x00\x00\x00WAVE"),
+		"no rate": rawWAV(formatPCM, 1, 0, 16, []byte{0, 0}),
+	} {
+		if _, err := Decode(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.wav")
+
+	w, err := Create(path, 16000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]float64{0.5, -0.5, 0.25, -0.25}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Silence(3); err != nil {
+		t.Fatal(err)
+	}
+	// Samples out of range are clipped
+	if err := w.Write([]float64{2, -2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := ReadFormat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Format{SampleRate: 16000, Channels: 2, BitsPerSample: 16}); format != want {
+		t.Errorf("format = %+v, want %+v", format, want)
+	}
+	if !format.IsPCM16() {
+		t.Error("expected 16-bit PCM")
+	}
+
+	audio, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0.5, -0.5, 0.25, -0.25, 0, 0, 0, 0, 0, 0, 1, -1}
+	if len(audio.Samples) != len(want) {
+		t.Fatalf("read %d samples, want %d", len(audio.Samples), len(want))
+	}
+	for i, s := range want {
+		if math.Abs(audio.Samples[i]-s) > 2.0/32768 {
+			t.Errorf("sample %d = %g, want %g", i, audio.Samples[i], s)
+		}
+	}
+}
+
+func TestReadFormat(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want Format
+	}{
+		{"float", rawWAV(formatFloat, 2, 48000, 32, make([]byte, 8)), Format{SampleRate: 48000, Channels: 2, BitsPerSample: 32, Float: true}},
+		{"24 bit", rawWAV(formatPCM, 1, 44100, 24, make([]byte, 3)), Format{SampleRate: 44100, Channels: 1, BitsPerSample: 24}},
+	} {
+		path := filepath.Join(dir, tt.name+".wav")
+		if err := os.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadFormat(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: format = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if got.IsPCM16() {
+			t.Errorf("%s: reported as 16-bit PCM", tt.name)
+		}
+	}
+
+	path := filepath.Join(dir, "no-fmt.wav")
+	if err := os.WriteFile(path, []byte("RIFF\x04\x00\x00\x00WAVE"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFormat(path); err == nil {
+		t.Error("expected an error without a fmt chunk")
+	}
+}
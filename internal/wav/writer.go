@@ -0,0 +1,94 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Writer writes 16-bit PCM audio to a WAV file as it comes, and fills in the sizes when it's closed.
+type Writer struct {
+	file       *os.File
+	w          *bufio.Writer
+	sampleRate int
+	channels   int
+	size       int64
+}
+
+// Create starts a WAV file at path with the sample rate and channel count.
+func Create(path string, sampleRate, channels int) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		file:       file,
+		w:          bufio.NewWriter(file),
+		sampleRate: sampleRate,
+		channels:   channels,
+	}
+	// The sizes are written again once they're known
+	if err := writeHeader(w.w, sampleRate, channels, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends interleaved samples scaled to [-1, 1]. Louder samples are clipped.
+func (w *Writer) Write(samples []float64) error {
+	buf := make([]byte, len(samples)*bitsPerSample/8)
+	for i, s := range samples {
+		v := int16(math.Round(max(-1, min(1, s)) * math.MaxInt16))
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(v))
+	}
+	if _, err := w.w.Write(buf); err != nil {
+		return err
+	}
+	w.size += int64(len(buf))
+	return nil
+}
+
+// Silence appends frames of silence, each one sample per channel.
+func (w *Writer) Silence(frames int) error {
+	n := int64(frames * w.channels * bitsPerSample / 8)
+	if _, err := io.CopyN(w.w, zeros{}, n); err != nil {
+		return err
+	}
+	w.size += n
+	return nil
+}
+
+// Close writes the sizes into the header and closes the file.
+func (w *Writer) Close() error {
+	if err := w.finish(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *Writer) finish() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if w.size > math.MaxUint32-headerSize {
+		return fmt.Errorf("WAV file is larger than 4GB")
+	}
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return writeHeader(w.file, w.sampleRate, w.channels, uint32(w.size))
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
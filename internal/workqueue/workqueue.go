@@ -0,0 +1,170 @@
+package workqueue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Queue runs tasks on a bounded number of goroutines.
+// Pending tasks are started lowest priority value first, and the first error cancels everything else, like an errgroup.
+type Queue struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	tasks   taskHeap
+	seq     uint64
+	limit   int
+	running int
+	err     error
+
+	wg sync.WaitGroup
+}
+
+type task struct {
+	priority int64
+	seq      uint64
+	fn       func(ctx context.Context) error
+}
+
+func New(ctx context.Context, limit int) *Queue {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &Queue{
+		ctx:    ctx,
+		cancel: cancel,
+		limit:  limit,
+	}
+}
+
+// Context is cancelled when a task fails or the parent context is done.
+func (q *Queue) Context() context.Context {
+	return q.ctx
+}
+
+// Push adds a task. Tasks with the same priority start in the order they were pushed.
+func (q *Queue) Push(priority int64, fn func(ctx context.Context) error) {
+	q.wg.Add(1)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	heap.Push(&q.tasks, &task{priority: priority, seq: q.seq, fn: fn})
+	q.schedule()
+}
+
+// Go runs fn straight away, outside of the limit, but still as part of the queue.
+// It's meant for short follow-up work that shouldn't hold up the workers.
+func (q *Queue) Go(fn func(ctx context.Context) error) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.finish(fn(q.ctx))
+	}()
+}
+
+// SetLimit changes how many tasks may run at once. Running tasks are never interrupted.
+func (q *Queue) SetLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.limit = n
+	q.schedule()
+}
+
+func (q *Queue) Limit() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.limit
+}
+
+// Wait blocks until every task has finished and returns the first error.
+func (q *Queue) Wait() error {
+	q.wg.Wait()
+
+	q.mu.Lock()
+	err := q.err
+	q.mu.Unlock()
+
+	// Without a failed task, the context can only have been cancelled by the parent
+	if err == nil {
+		err = q.ctx.Err()
+	}
+	q.cancel(nil)
+
+	return err
+}
+
+// schedule starts pending tasks while there is room. It must be called with mu held.
+func (q *Queue) schedule() {
+	if q.ctx.Err() != nil {
+		// Drop everything that hasn't started yet
+		for q.tasks.Len() > 0 {
+			heap.Pop(&q.tasks)
+			q.wg.Done()
+		}
+		return
+	}
+
+	for q.running < q.limit && q.tasks.Len() > 0 {
+		t := heap.Pop(&q.tasks).(*task)
+		q.running++
+
+		go func() {
+			err := t.fn(q.ctx)
+
+			q.mu.Lock()
+			q.running--
+			q.mu.Unlock()
+
+			q.finish(err)
+			q.wg.Done()
+		}()
+	}
+}
+
+func (q *Queue) finish(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err != nil && q.err == nil {
+		q.err = err
+		q.cancel(err)
+	}
+
+	q.schedule()
+}
+
+// taskHeap orders tasks by priority, then by the order they were pushed.
+type taskHeap []*task
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x any) { *h = append(*h, x.(*task)) }
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
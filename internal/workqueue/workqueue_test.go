@@ -0,0 +1,208 @@
+package workqueue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriority(t *testing.T) {
+	q := New(context.Background(), 1)
+
+	// Hold the only worker so every other task is pending when it's pushed
+	release := make(chan struct{})
+	q.Push(0, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	for _, task := range []struct {
+		priority int64
+		name     string
+	}{
+		{5, "c1"},
+		{1, "a"},
+		{5, "c2"},
+		{3, "b"},
+		{5, "c3"},
+	} {
+		q.Push(task.priority, func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, task.name)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	close(release)
+	if err := q.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "b", "c1", "c2", "c3"}; !slices.Equal(order, want) {
+		t.Errorf("tasks ran in order %v, want %v", order, want)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	q := New(context.Background(), 2)
+
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	for range 8 {
+		q.Push(0, func(ctx context.Context) error {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := q.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if peak != 2 {
+		t.Errorf("%d tasks ran at once, want 2", peak)
+	}
+}
+
+func TestFirstErrorCancels(t *testing.T) {
+	q := New(context.Background(), 2)
+	failed := errors.New("synthesis failed")
+
+	cancelled := make(chan error, 1)
+	q.Push(0, func(ctx context.Context) error {
+		<-ctx.Done()
+		cancelled <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	q.Push(0, func(ctx context.Context) error {
+		return failed
+	})
+
+	ran := false
+	q.Push(1, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	if err := q.Wait(); !errors.Is(err, failed) {
+		t.Errorf("Wait = %v, want %v", err, failed)
+	}
+	if cause := <-cancelled; !errors.Is(cause, failed) {
+		t.Errorf("running task was cancelled with %v, want %v", cause, failed)
+	}
+	if ran {
+		t.Error("a pending task ran after another failed")
+	}
+}
+
+func TestParentCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := New(ctx, 1)
+
+	started := make(chan struct{})
+	q.Push(0, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+
+	ran := false
+	q.Push(1, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	<-started
+	cancel()
+
+	if err := q.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait = %v, want %v", err, context.Canceled)
+	}
+	if ran {
+		t.Error("a pending task ran after the parent context was cancelled")
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	q := New(context.Background(), 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	for range 3 {
+		q.Push(0, func(ctx context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	}
+
+	<-started
+	// Raising the limit starts pending tasks straight away
+	q.SetLimit(3)
+	for range 2 {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("pending tasks didn't start after raising the limit")
+		}
+	}
+	if q.Limit() != 3 {
+		t.Errorf("limit = %d, want 3", q.Limit())
+	}
+
+	q.SetLimit(0)
+	if q.Limit() != 1 {
+		t.Errorf("limit = %d, want at least 1", q.Limit())
+	}
+
+	close(release)
+	if err := q.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGo(t *testing.T) {
+	q := New(context.Background(), 1)
+
+	// Go runs even while the only worker is busy
+	release := make(chan struct{})
+	q.Push(0, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	q.Go(func(ctx context.Context) error {
+		close(release)
+		return nil
+	})
+	if err := q.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("combine failed")
+	q = New(context.Background(), 1)
+	q.Go(func(ctx context.Context) error { return failed })
+	if err := q.Wait(); !errors.Is(err, failed) {
+		t.Errorf("Wait = %v, want %v", err, failed)
+	}
+	if q.Context().Err() == nil {
+		t.Error("context wasn't cancelled after Wait")
+	}
+}